@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// Go-live announcements on Twitter, Bluesky and Discord. Configured in config/announce.json.
+
+const announceConfigFile = "announce.json"
+
+type AnnounceConfig struct {
+	// Post the announcement automatically when a stream session starts.
+	Auto bool `json:"auto"`
+	// Supports {title}, {youtube_url} and {twitch_url} placeholders.
+	Template string `json:"template"`
+	Twitter  bool   `json:"twitter"`
+	Bluesky  bool   `json:"bluesky"`
+	Discord  bool   `json:"discord"`
+}
+
+var defaultAnnounceConfig = AnnounceConfig{
+	Template: "🔴 Live now: \"{title}\"! 🎉🎉🎉\n\n📺 {youtube_url} {twitch_url} https://tv.algora.io/maf",
+	Twitter:  true,
+	Bluesky:  true,
+}
+
+var announceConfig atomic.Pointer[AnnounceConfig]
+
+func LoadAnnounceConfig() {
+	cfg := defaultAnnounceConfig
+	if err := LoadConfig(announceConfigFile, &cfg); err != nil {
+		warn_color.Println("Using default announcement config:", err)
+		cfg = defaultAnnounceConfig
+	}
+	announceConfig.Store(&cfg)
+}
+
+func GetAnnounceConfig() *AnnounceConfig {
+	if cfg := announceConfig.Load(); cfg != nil {
+		return cfg
+	}
+	return &defaultAnnounceConfig
+}
+
+// GoLiveMessage fills the announcement template. It blocks until the YouTube bot responds.
+func GoLiveMessage(template string) string {
+	youtubeURL := ""
+	if videoID := GetYouTubeVideoID(); videoID != "" {
+		youtubeURL = "https://youtu.be/" + videoID
+	}
+	return strings.NewReplacer(
+		"{title}", twitchTitle,
+		"{youtube_url}", youtubeURL,
+		"{twitch_url}", "https://twitch.tv/"+twitchBroadcasterUsername,
+	).Replace(template)
+}
+
+// AnnounceGoLive posts the go-live message to all enabled platforms.
+func AnnounceGoLive() error {
+	cfg := GetAnnounceConfig()
+	message := GoLiveMessage(cfg.Template)
+	var errs []error
+	if cfg.Twitter {
+		if err := PostTweetSync(message); err != nil {
+			errs = append(errs, fmt.Errorf("twitter: %w", err))
+		} else {
+			fmt.Printf("Tweeted: %s\n", message)
+		}
+	}
+	if cfg.Bluesky {
+		if err := PostBlueskySynch(message); err != nil {
+			errs = append(errs, fmt.Errorf("bluesky: %w", err))
+		} else {
+			fmt.Printf("Posted to Bluesky: %s\n", message)
+		}
+	}
+	if cfg.Discord {
+		if err := SendDiscordMessage(message); err != nil {
+			errs = append(errs, fmt.Errorf("discord: %w", err))
+		} else {
+			fmt.Printf("Posted to Discord: %s\n", message)
+		}
+	}
+	return errors.Join(errs...)
+}
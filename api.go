@@ -0,0 +1,407 @@
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// REST API for scripts & Stream Deck buttons. Every endpoint (except the OpenAPI document) requires an
+// "Authorization: Bearer <token>" header with one of the tokens from secrets/api_tokens.txt (one per line, lines
+// starting with # are ignored). Without that file the API is disabled. The endpoints are described in
+// static/openapi.json, served at /api/v1/openapi.json.
+
+const apiPrefix = "/api/v1"
+
+const apiMaxBodySize = 1 << 20
+
+var apiTokensPath = secretsPath("api_tokens.txt")
+
+var apiColor = color.New(color.FgHiGreen)
+
+var errAPIDisabled = errors.New("API is disabled: create secrets/api_tokens.txt")
+
+// loadAPITokens reads the tokens on every request, so they can be rotated without restarting the bot.
+func loadAPITokens() ([]string, error) {
+	file, err := os.Open(apiTokensPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var tokens []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		tokens = append(tokens, line)
+	}
+	return tokens, scanner.Err()
+}
+
+func checkAPIToken(r *http.Request) (status int, err error) {
+	tokens, err := loadAPITokens()
+	if err != nil || len(tokens) == 0 {
+		return http.StatusServiceUnavailable, errAPIDisabled
+	}
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found || token == "" {
+		return http.StatusUnauthorized, errors.New("missing bearer token")
+	}
+	valid := 0
+	for _, t := range tokens {
+		// Check all tokens, so the timing doesn't reveal which one matched
+		valid |= subtle.ConstantTimeCompare([]byte(token), []byte(t))
+	}
+	if valid == 0 {
+		return http.StatusUnauthorized, errors.New("invalid bearer token")
+	}
+	return http.StatusOK, nil
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+type apiOK struct {
+	OK bool `json:"ok"`
+}
+
+func writeAPIJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, err error) {
+	writeAPIJSON(w, status, apiError{Error: err.Error()})
+}
+
+// readAPIBody decodes the JSON body. Unknown fields are rejected, so typos in scripts don't go unnoticed.
+func readAPIBody(w http.ResponseWriter, r *http.Request, out any) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, apiMaxBodySize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(out); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = errors.New("missing request body")
+		}
+		writeAPIError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return false
+	}
+	return true
+}
+
+// handleAPI registers an authenticated endpoint. The pattern is relative to /api/v1, for example "POST /alerts".
+func handleAPI(mux *http.ServeMux, pattern string, handler http.HandlerFunc) {
+	method, path, _ := strings.Cut(pattern, " ")
+	mux.HandleFunc(method+" "+apiPrefix+path, func(w http.ResponseWriter, r *http.Request) {
+		if status, err := checkAPIToken(r); err != nil {
+			apiColor.Printf("API %s %s from %s: %s\n", r.Method, r.URL.Path, r.RemoteAddr, err)
+			writeAPIError(w, status, err)
+			return
+		}
+		apiColor.Printf("API %s %s from %s\n", r.Method, r.URL.Path, r.RemoteAddr)
+		handler(w, r)
+	})
+}
+
+// RegisterAPI adds the REST API endpoints to the mux.
+func RegisterAPI(mux *http.ServeMux) {
+	mux.HandleFunc("GET "+apiPrefix+"/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, "static/openapi.json")
+	})
+
+	handleAPI(mux, "POST /alerts", apiShowAlert)
+	handleAPI(mux, "POST /tts", apiSay)
+	handleAPI(mux, "POST /chat", apiSendChat)
+
+	handleAPI(mux, "POST /users/mute", apiMuteUser)
+	handleAPI(mux, "POST /users/ban", apiBanUser)
+
+	handleAPI(mux, "GET /stream/info", apiGetStreamInfo)
+	handleAPI(mux, "PUT /stream/info", apiSetStreamInfo)
+	handleAPI(mux, "POST /stream/title", apiSetTitle)
+
+	handleAPI(mux, "GET /polls/current", apiGetPoll)
+	handleAPI(mux, "POST /polls", apiStartPoll)
+	handleAPI(mux, "POST /polls/current/end", apiEndPoll)
+
+	handleAPI(mux, "GET /obs", apiGetOBSState)
+	handleAPI(mux, "POST /obs/scene", apiOBSSetScene)
+	handleAPI(mux, "POST /obs/source-visibility", apiOBSSetSourceVisible)
+	handleAPI(mux, "POST /obs/input-mute", apiOBSSetInputMute)
+	handleAPI(mux, "POST /obs/streaming", apiOBSSetStreaming)
+	handleAPI(mux, "POST /obs/recording", apiOBSSetRecording)
+}
+
+// Alerts, TTS & chat
+
+func apiShowAlert(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		HTML string `json:"html"`
+	}
+	if !readAPIBody(w, r, &req) {
+		return
+	}
+	if req.HTML == "" {
+		writeAPIError(w, http.StatusBadRequest, errors.New("html is required"))
+		return
+	}
+	select {
+	case TTSChannel <- Alert{HTML: req.HTML}:
+	default:
+		writeAPIError(w, http.StatusServiceUnavailable, errors.New("TTS queue is full"))
+		return
+	}
+	writeAPIJSON(w, http.StatusAccepted, apiOK{OK: true})
+}
+
+func apiSay(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Message string `json:"message"`
+		// Voice sample, the narrator by default.
+		Voice string `json:"voice,omitempty"`
+	}
+	if !readAPIBody(w, r, &req) {
+		return
+	}
+	if strings.TrimSpace(req.Message) == "" {
+		writeAPIError(w, http.StatusBadRequest, errors.New("message is required"))
+		return
+	}
+	if req.Voice != "" && !slices.Contains(TTSVoices(), req.Voice) {
+		writeAPIError(w, http.StatusBadRequest, fmt.Errorf("unknown voice %q", req.Voice))
+		return
+	}
+	if !Narrate(req.Message, req.Voice) {
+		writeAPIError(w, http.StatusServiceUnavailable, errors.New("TTS queue is full"))
+		return
+	}
+	writeAPIJSON(w, http.StatusAccepted, apiOK{OK: true})
+}
+
+func apiSendChat(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Message string `json:"message"`
+		// Platforms from ChatPlatforms. Empty means all of them.
+		Platforms []string `json:"platforms,omitempty"`
+	}
+	if !readAPIBody(w, r, &req) {
+		return
+	}
+	if strings.TrimSpace(req.Message) == "" {
+		writeAPIError(w, http.StatusBadRequest, errors.New("message is required"))
+		return
+	}
+	SendChat(req.Message, req.Platforms...)
+	writeAPIJSON(w, http.StatusAccepted, apiOK{OK: true})
+}
+
+// Moderation
+
+func apiMuteUser(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		User  User `json:"user"`
+		Muted bool `json:"muted"`
+	}
+	if !readAPIBody(w, r, &req) {
+		return
+	}
+	if req.User.Key() == "" {
+		writeAPIError(w, http.StatusBadRequest, errors.New("user needs a twitch, youtube or discord account"))
+		return
+	}
+	SetMuted(req.User, req.Muted)
+	writeAPIJSON(w, http.StatusOK, apiOK{OK: true})
+}
+
+func apiBanUser(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		User User `json:"user"`
+	}
+	if !readAPIBody(w, r, &req) {
+		return
+	}
+	if req.User.TwitchUser == nil || req.User.TwitchUser.TwitchID == "" {
+		writeAPIError(w, http.StatusBadRequest, errors.New("only Twitch users can be banned"))
+		return
+	}
+	BanUser(req.User)
+	writeAPIJSON(w, http.StatusAccepted, apiOK{OK: true})
+}
+
+// Stream info
+
+func apiGetStreamInfo(w http.ResponseWriter, r *http.Request) {
+	writeAPIJSON(w, http.StatusOK, GetStreamInfo())
+}
+
+func apiSetStreamInfo(w http.ResponseWriter, r *http.Request) {
+	var info StreamInfo
+	if !readAPIBody(w, r, &info) {
+		return
+	}
+	if err := SetStreamInfo(info); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, GetStreamInfo())
+}
+
+func apiSetTitle(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Title string `json:"title"`
+	}
+	if !readAPIBody(w, r, &req) {
+		return
+	}
+	info := GetStreamInfo()
+	info.Title = req.Title
+	if err := SetStreamInfo(info); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, GetStreamInfo())
+}
+
+// Polls
+
+var errNoPoll = errors.New("no poll")
+
+func apiGetPoll(w http.ResponseWriter, r *http.Request) {
+	view := onMainThread(func() *PollView {
+		if activePoll == nil {
+			return nil
+		}
+		view := activePoll.View()
+		return &view
+	})
+	if view == nil {
+		writeAPIError(w, http.StatusNotFound, errNoPoll)
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, view)
+}
+
+func apiStartPoll(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Question        string   `json:"question"`
+		Options         []string `json:"options"`
+		DurationSeconds int      `json:"duration_s"`
+		// "", "poll" or "prediction"
+		Mirror string `json:"mirror,omitempty"`
+	}
+	if !readAPIBody(w, r, &req) {
+		return
+	}
+	err := onMainThread(func() error {
+		return StartPoll(req.Question, req.Options, time.Duration(req.DurationSeconds)*time.Second, req.Mirror)
+	})
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	apiGetPoll(w, r)
+}
+
+func apiEndPoll(w http.ResponseWriter, r *http.Request) {
+	err := onMainThread(func() error {
+		if activePoll == nil || activePoll.Closed {
+			return errNoPoll
+		}
+		EndPoll()
+		return nil
+	})
+	if err != nil {
+		writeAPIError(w, http.StatusNotFound, err)
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, apiOK{OK: true})
+}
+
+// OBS
+
+// writeOBSResult reports the result of an OBS request. OBS errors are reported as 502.
+func writeOBSResult(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errOBSNotConnected):
+		writeAPIError(w, http.StatusServiceUnavailable, err)
+	case err != nil:
+		writeAPIError(w, http.StatusBadGateway, err)
+	default:
+		writeAPIJSON(w, http.StatusOK, apiOK{OK: true})
+	}
+}
+
+func apiGetOBSState(w http.ResponseWriter, r *http.Request) {
+	state, err := TryGetOBSState()
+	if err != nil {
+		writeOBSResult(w, err)
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, state)
+}
+
+func apiOBSSetScene(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Scene string `json:"scene"`
+	}
+	if !readAPIBody(w, r, &req) {
+		return
+	}
+	writeOBSResult(w, OBSSetScene(req.Scene))
+}
+
+func apiOBSSetSourceVisible(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Scene   string `json:"scene"`
+		ID      int    `json:"id"`
+		Visible bool   `json:"visible"`
+	}
+	if !readAPIBody(w, r, &req) {
+		return
+	}
+	writeOBSResult(w, OBSSetSourceVisible(req.Scene, req.ID, req.Visible))
+}
+
+func apiOBSSetInputMute(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Input string `json:"input"`
+		Muted bool   `json:"muted"`
+	}
+	if !readAPIBody(w, r, &req) {
+		return
+	}
+	writeOBSResult(w, OBSSetInputMute(req.Input, req.Muted))
+}
+
+func apiOBSSetStreaming(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Active bool `json:"active"`
+	}
+	if !readAPIBody(w, r, &req) {
+		return
+	}
+	writeOBSResult(w, OBSSetStreaming(req.Active))
+}
+
+func apiOBSSetRecording(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Active bool `json:"active"`
+	}
+	if !readAPIBody(w, r, &req) {
+		return
+	}
+	writeOBSResult(w, OBSSetRecording(req.Active))
+}
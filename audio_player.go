@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bytes"
 	"streambot/backoff"
 	"time"
 
@@ -9,31 +8,46 @@ import (
 	"github.com/fatih/color"
 )
 
-var AudioPlayerChannel = make(chan interface{}, 20)
+var AudioPlayerChannel = make(chan PlayMessage, 20)
 
 var audioPlayerColor = color.New(color.FgGreen)
 
 type PlayMessage struct {
-	wavData  []byte // first 44 bytes (WAV header) are ignored
+	wavData  []byte // any WAV file supported by ParseWAV
 	prePlay  func() // optional function to run before playing (blocks audio playback)
 	postPlay func() // optional function to run after playing (blocks audio playback)
 	author   *User
+	bus      string // mixer bus (BusTTS if empty)
 }
 
-func WAVDuration(wav []byte) time.Duration {
-	const bytesPerSecond = 44100 * 2
-	return time.Duration(len(wav)-44) * time.Second / time.Duration(bytesPerSecond)
-}
+// Format of the audio output. All sounds are converted to it before playback.
+const (
+	playerSampleRate = 44100
+	playerChannels   = 1
+)
 
-func WaitForMicSilence() {
-	if !MicIsSilent.Load() {
-		audioPlayerColor.Println("Audio Player waiting for mic silence...")
-		waitStart := time.Now()
-		// wait for the mic to be silent
-		for !MicIsSilent.Load() {
-			time.Sleep(time.Millisecond * 100)
+// ConcatWAV joins several WAV files into a single WAV file in the player format.
+// Files that can't be parsed are skipped.
+func ConcatWAV(wavs [][]byte) []byte {
+	joined := &WAV{SampleRate: playerSampleRate, Channels: playerChannels}
+	for _, wav := range wavs {
+		decoded, err := ParseWAV(wav)
+		if err != nil {
+			audioPlayerColor.Println("Couldn't parse WAV:", err)
+			continue
 		}
-		audioPlayerColor.Println("Resuming playback after", time.Since(waitStart))
+		joined.Samples = append(joined.Samples, decoded.Convert(playerSampleRate, playerChannels).Samples...)
+	}
+	return joined.Encode()
+}
+
+// Skips the currently playing TTS message or alert.
+var audioSkipChannel = make(chan struct{}, 1)
+
+func SkipAudio() {
+	select {
+	case audioSkipChannel <- struct{}{}:
+	default:
 	}
 }
 
@@ -46,8 +60,8 @@ func AudioPlayer() {
 		backoff.Attempt()
 		// initialize things here
 		otoOptions := &oto.NewContextOptions{
-			SampleRate:   44100,
-			ChannelCount: 1,
+			SampleRate:   playerSampleRate,
+			ChannelCount: playerChannels,
 			Format:       oto.FormatSignedInt16LE,
 		}
 		otoCtx, otoReadyChan, err := oto.NewContext(otoOptions)
@@ -57,30 +71,58 @@ func AudioPlayer() {
 		}
 		<-otoReadyChan
 
+		// The mixer never runs out of samples so this player keeps running forever
+		player := otoCtx.NewPlayer(AudioMixer)
+		player.SetBufferSize(playerSampleRate * playerChannels * 2 / 10) // 100ms - keeps fades responsive
+		player.Play()
+
 		for { // work loop
 			select {
-			case msg := <-AudioPlayerChannel:
-				switch t := msg.(type) {
-				case PlayMessage:
-					samples := t.wavData[44:] // remove WAV header
-					player := otoCtx.NewPlayer(bytes.NewReader(samples))
-					WaitForMicSilence()
-					if t.prePlay != nil {
-						t.prePlay()
-					}
-					player.Play()
-					for player.IsPlaying() {
-						if t.author != nil && IsMuted(*t.author) {
-							player.Pause()
-							break
+			case t := <-AudioPlayerChannel:
+				wav, err := ParseWAV(t.wavData)
+				if err != nil {
+					audioPlayerColor.Println("Couldn't parse WAV:", err)
+					continue
+				}
+				// Drop stale skip requests
+				select {
+				case <-audioSkipChannel:
+				default:
+				}
+				if !WaitForMicSilence() {
+					continue
+				}
+				if t.prePlay != nil {
+					t.prePlay()
+				}
+				bus := t.bus
+				if bus == "" {
+					bus = BusTTS
+				}
+				Events.Publish(TTSStarted{Author: t.author, Bus: bus})
+				voice := AudioMixer.Play(bus, wav)
+				ticker := time.NewTicker(50 * time.Millisecond)
+				fading := false
+			playing:
+				for {
+					select {
+					case <-voice.Done():
+						break playing
+					case <-audioSkipChannel:
+						audioPlayerColor.Println("Skipping audio message")
+						AudioMixer.FadeOut(voice, fadeOutDuration)
+						fading = true
+					case <-ticker.C:
+						if !fading && t.author != nil && IsMuted(*t.author) {
+							AudioMixer.FadeOut(voice, fadeOutDuration)
+							fading = true
 						}
-						time.Sleep(time.Millisecond)
-					}
-					if t.postPlay != nil {
-						t.postPlay()
 					}
-				default:
-					audioPlayerColor.Printf("Player received unknown message type: %T\n", t)
+				}
+				ticker.Stop()
+				Events.Publish(TTSFinished{Author: t.author, Bus: bus})
+				if t.postPlay != nil {
+					t.postPlay()
 				}
 			}
 		}
@@ -33,16 +33,26 @@ func (b *Backoff) printf(format_string string, args ...interface{}) {
 }
 
 func (b *Backoff) Attempt() {
+	b.AttemptBefore(time.Time{})
+}
+
+// AttemptBefore is Attempt that gives up instead of sleeping past the deadline (zero means no deadline). Returns
+// false when it gave up.
+func (b *Backoff) AttemptBefore(deadline time.Time) bool {
 	if time.Since(b.last_attempt) > time.Minute {
 		b.attempts = 0
 	} else {
 		backoffIndex := min(b.attempts, len(backoffSleepTimes)-1)
-		b.attempts++
 		sleep_time := backoffSleepTimes[backoffIndex]
+		if !deadline.IsZero() && time.Now().Add(sleep_time).After(deadline) {
+			return false
+		}
+		b.attempts++
 		b.printf("Backing off %s for %s\n", b.Description, sleep_time)
 		time.Sleep(sleep_time)
 	}
 	b.last_attempt = time.Now()
+	return true
 }
 
 func (b *Backoff) Success() {
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"strings"
+)
+
+// Chat messages are made of typed fragments. Platforms only build the fragments - Render turns them into the HTML
+// for the pages, the line printed in the terminal, the text read by TTS and the plain text used for language
+// detection. The fragments are also sent to the pages, so the themes (static/themes/) can render them with their
+// own templates.
+
+const (
+	FragmentText    = "text"
+	FragmentEmote   = "emote"
+	FragmentMention = "mention"
+	// http(s) URLs found in the text.
+	FragmentLink = "link"
+	// Files, embeds & GIFs posted on Discord.
+	FragmentAttachment = "attachment"
+	// Twitch bits.
+	FragmentCheermote = "cheermote"
+)
+
+// Kinds of attachments.
+const (
+	MediaImage = "image"
+	MediaVideo = "video"
+	MediaFile  = "file"
+)
+
+type ChatFragment struct {
+	Type string `json:"type"`
+	// Text of the fragment. Name of the emote or the attached file, "Cheer100" for cheermotes.
+	Text string `json:"text"`
+	// Image of the emote or cheermote, target of the link, URL of the attachment.
+	URL string `json:"url,omitempty"`
+	// Higher resolution images of the emote, in the format of the srcset attribute.
+	SrcSet string `json:"srcset,omitempty"`
+	// Mentioned user.
+	User *User `json:"user,omitempty"`
+	// Kind of the attachment (MediaImage, MediaVideo or MediaFile).
+	Media string `json:"media,omitempty"`
+	// Number of bits of the cheermote.
+	Bits int `json:"bits,omitempty"`
+}
+
+// AppendText adds the text to the message. URLs become link fragments, the rest is merged with the preceding text
+// fragment.
+func (t *ChatEntry) AppendText(text string) {
+	t.AppendShortcodes(text, nil)
+}
+
+func (t *ChatEntry) AppendFragment(fragment ChatFragment) {
+	if fragment.Type == FragmentText {
+		t.AppendText(fragment.Text)
+		return
+	}
+	t.Fragments = append(t.Fragments, fragment)
+}
+
+// AppendShortcodes is AppendText that also replaces the :shortcodes: found in the emotes map (shortcode => image
+// URL) with emote fragments.
+func (t *ChatEntry) AppendShortcodes(text string, emotes map[string]string) {
+	for _, loc := range urlRegexp.FindAllStringIndex(text, -1) {
+		t.appendEmotes(text[:loc[0]], emotes)
+		link := text[loc[0]:loc[1]]
+		t.Fragments = append(t.Fragments, ChatFragment{Type: FragmentLink, Text: link, URL: link})
+		text = text[loc[1]:]
+	}
+	t.appendEmotes(text, emotes)
+}
+
+func (t *ChatEntry) appendEmotes(text string, emotes map[string]string) {
+	for len(emotes) > 0 {
+		start := strings.IndexByte(text, ':')
+		if start < 0 {
+			break
+		}
+		length := strings.IndexByte(text[start+1:], ':')
+		if length < 0 {
+			break
+		}
+		shortcode := text[start : start+length+2]
+		url, found := emotes[shortcode]
+		if !found {
+			// The closing colon may start the next shortcode
+			t.appendPlainText(text[:start+1])
+			text = text[start+1:]
+			continue
+		}
+		t.appendPlainText(text[:start])
+		t.Fragments = append(t.Fragments, ChatFragment{Type: FragmentEmote, Text: shortcode, URL: url})
+		text = text[start+len(shortcode):]
+	}
+	t.appendPlainText(text)
+}
+
+func (t *ChatEntry) appendPlainText(text string) {
+	if text == "" {
+		return
+	}
+	if n := len(t.Fragments); n > 0 && t.Fragments[n-1].Type == FragmentText {
+		t.Fragments[n-1].Text += text
+		return
+	}
+	t.Fragments = append(t.Fragments, ChatFragment{Type: FragmentText, Text: text})
+}
+
+// HTML returns the fragment as HTML. All text is escaped.
+func (f ChatFragment) HTML() string {
+	text := html.EscapeString(f.Text)
+	url := html.EscapeString(f.URL)
+	switch f.Type {
+	case FragmentEmote:
+		if f.SrcSet != "" {
+			return fmt.Sprintf(`<img title="%s" class="emoji" src="%s" srcset="%s">`, text, url, html.EscapeString(f.SrcSet))
+		}
+		return fmt.Sprintf(`<img title="%s" class="emoji" src="%s">`, text, url)
+	case FragmentMention:
+		if f.User != nil && strings.HasPrefix(f.User.DisplayName(), "@") { // YouTube handles
+			return f.User.HTML()
+		} else if f.User != nil {
+			return "@" + f.User.HTML()
+		}
+		return "<strong>" + text + "</strong>"
+	case FragmentLink:
+		return fmt.Sprintf(`<a href="%s" target="_blank">%s</a>`, url, text)
+	case FragmentAttachment:
+		switch f.Media {
+		case MediaImage:
+			return fmt.Sprintf(`<a href="%s"><img src="%s" class="attachment" title="%s"></a>`, url, url, text)
+		case MediaVideo:
+			return fmt.Sprintf(`<video src="%s" class="attachment" autoplay loop controls></video>`, url)
+		}
+		return fmt.Sprintf(`<a href="%s">%s</a>`, url, text)
+	case FragmentCheermote:
+		return fmt.Sprintf(`<img title="%s" class="emoji" src="%s"><strong class="cheermote">%d</strong>`, text, url, f.Bits)
+	}
+	return text
+}
+
+// Terminal returns the fragment as printed in the terminal.
+func (f ChatFragment) Terminal() string {
+	switch f.Type {
+	case FragmentEmote, FragmentCheermote:
+		return "[" + f.Text + "]"
+	case FragmentAttachment:
+		return "[attachment " + f.Text + "]"
+	}
+	return f.Text
+}
+
+// TTS returns the text that TTS reads for the fragment. Links are vocalized later, with the rest of the message
+// (see VocalizeHTML).
+func (f ChatFragment) TTS() string {
+	switch f.Type {
+	case FragmentText, FragmentLink:
+		return f.Text
+	case FragmentMention:
+		if f.User != nil {
+			return f.User.DisplayName()
+		}
+		return strings.TrimPrefix(f.Text, "@")
+	case FragmentCheermote:
+		return fmt.Sprintf("* Cheered %d bits *", f.Bits)
+	}
+	return ""
+}
+
+// PlatformIcon returns the icon shown before the messages from the platform.
+func PlatformIcon(platform string) string {
+	switch platform {
+	case PlatformTwitch:
+		return TWITCH_ICON
+	case PlatformYouTube:
+		return YOUTUBE_ICON
+	case PlatformDiscord:
+		return DISCORD_ICON
+	}
+	return BOT_ICON
+}
+
+// Render fills HTML, terminalMsg, ttsMsg & textOnly from the author, the badges, the reply & the fragments. Call it
+// after adding all fragments.
+func (t *ChatEntry) Render() {
+	var htmlMsg, terminalMsg, ttsMsg, textOnly strings.Builder
+	for _, f := range t.Fragments {
+		htmlMsg.WriteString(f.HTML())
+		terminalMsg.WriteString(f.Terminal())
+		ttsMsg.WriteString(f.TTS())
+		if f.Type == FragmentText {
+			textOnly.WriteString(f.Text)
+		}
+	}
+	t.HTML = PlatformIcon(t.Platform) + " " + t.badgesHTML() + t.Author.HTML() + ": " + htmlMsg.String()
+	t.terminalMsg = fmt.Sprintf("  %s%s: %s\n", t.badgesTerminal(), t.Author.DisplayName(), terminalMsg.String())
+	t.ttsMsg = ttsMsg.String()
+	t.textOnly = textOnly.String()
+	if t.ReplyTo != nil {
+		t.HTML = t.ReplyTo.HTML() + t.HTML
+		t.terminalMsg = t.ReplyTo.Terminal() + t.terminalMsg
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestChatFragmentOutputs(t *testing.T) {
+	alice := &User{TwitchUser: &TwitchUser{TwitchID: "1", Login: "alice", Name: "Alice", Color: "#ff0000"}}
+	handle := &User{YouTubeUser: &YouTubeUser{ChannelID: "UC1", Name: "@bob"}}
+	tests := []struct {
+		name     string
+		fragment ChatFragment
+		html     string
+		terminal string
+		tts      string
+	}{
+		{
+			name:     "text is escaped",
+			fragment: ChatFragment{Type: FragmentText, Text: `<b>hi</b> & "you"`},
+			html:     `&lt;b&gt;hi&lt;/b&gt; &amp; &#34;you&#34;`,
+			terminal: `<b>hi</b> & "you"`,
+			tts:      `<b>hi</b> & "you"`,
+		},
+		{
+			name:     "emote",
+			fragment: ChatFragment{Type: FragmentEmote, Text: "Kappa", URL: "https://example.com/kappa.png"},
+			html:     `<img title="Kappa" class="emoji" src="https://example.com/kappa.png">`,
+			terminal: "[Kappa]",
+		},
+		{
+			name: "emote with srcset",
+			fragment: ChatFragment{Type: FragmentEmote, Text: "Kappa", URL: "https://example.com/1.0",
+				SrcSet: "https://example.com/1.0 1x,https://example.com/2.0 2x"},
+			html:     `<img title="Kappa" class="emoji" src="https://example.com/1.0" srcset="https://example.com/1.0 1x,https://example.com/2.0 2x">`,
+			terminal: "[Kappa]",
+		},
+		{
+			name:     "emote name is escaped",
+			fragment: ChatFragment{Type: FragmentEmote, Text: `"><script>`, URL: `x" onerror="alert(1)`},
+			html:     `<img title="&#34;&gt;&lt;script&gt;" class="emoji" src="x&#34; onerror=&#34;alert(1)">`,
+			terminal: `["><script>]`,
+		},
+		{
+			name:     "mention of a Twitch user",
+			fragment: ChatFragment{Type: FragmentMention, Text: "@alice", User: alice},
+			html:     `@<strong style="color:#ff0000">Alice</strong>`,
+			terminal: "@alice",
+			tts:      "Alice",
+		},
+		{
+			name:     "mention of a YouTube handle",
+			fragment: ChatFragment{Type: FragmentMention, Text: "@bob", User: handle},
+			html:     `<strong>@bob</strong>`,
+			terminal: "@bob",
+			tts:      "@bob",
+		},
+		{
+			name:     "mention of an unknown user",
+			fragment: ChatFragment{Type: FragmentMention, Text: "@<carol>"},
+			html:     `<strong>@&lt;carol&gt;</strong>`,
+			terminal: "@<carol>",
+			tts:      "<carol>",
+		},
+		{
+			name:     "link",
+			fragment: ChatFragment{Type: FragmentLink, Text: "https://example.com/?a=1&b=2", URL: "https://example.com/?a=1&b=2"},
+			html:     `<a href="https://example.com/?a=1&amp;b=2" target="_blank">https://example.com/?a=1&amp;b=2</a>`,
+			terminal: "https://example.com/?a=1&b=2",
+			tts:      "https://example.com/?a=1&b=2",
+		},
+		{
+			name:     "image attachment",
+			fragment: ChatFragment{Type: FragmentAttachment, Text: "cat.png", URL: "/attachments/cat.png", Media: MediaImage},
+			html:     `<a href="/attachments/cat.png"><img src="/attachments/cat.png" class="attachment" title="cat.png"></a>`,
+			terminal: "[attachment cat.png]",
+		},
+		{
+			name:     "video attachment",
+			fragment: ChatFragment{Type: FragmentAttachment, Text: "cat.mp4", URL: "/attachments/cat.mp4", Media: MediaVideo},
+			html:     `<video src="/attachments/cat.mp4" class="attachment" autoplay loop controls></video>`,
+			terminal: "[attachment cat.mp4]",
+		},
+		{
+			name:     "file attachment",
+			fragment: ChatFragment{Type: FragmentAttachment, Text: "notes.txt", URL: "/attachments/notes.txt", Media: MediaFile},
+			html:     `<a href="/attachments/notes.txt">notes.txt</a>`,
+			terminal: "[attachment notes.txt]",
+		},
+		{
+			name:     "cheermote",
+			fragment: ChatFragment{Type: FragmentCheermote, Text: "Cheer100", URL: "https://example.com/cheer.gif", Bits: 100},
+			html:     `<img title="Cheer100" class="emoji" src="https://example.com/cheer.gif"><strong class="cheermote">100</strong>`,
+			terminal: "[Cheer100]",
+			tts:      "* Cheered 100 bits *",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.fragment.HTML(); got != test.html {
+				t.Errorf("HTML = %q, want %q", got, test.html)
+			}
+			if got := test.fragment.Terminal(); got != test.terminal {
+				t.Errorf("Terminal = %q, want %q", got, test.terminal)
+			}
+			if got := test.fragment.TTS(); got != test.tts {
+				t.Errorf("TTS = %q, want %q", got, test.tts)
+			}
+		})
+	}
+}
+
+func TestChatEntryRender(t *testing.T) {
+	entry := ChatEntry{
+		Platform: PlatformTwitch,
+		Author:   User{TwitchUser: &TwitchUser{TwitchID: "1", Login: "alice", Name: "Alice"}},
+	}
+	entry.AppendText("hi ")
+	entry.AppendFragment(ChatFragment{Type: FragmentEmote, Text: "Kappa", URL: "https://example.com/kappa.png"})
+	entry.AppendText(" see https://example.com ")
+	entry.AppendFragment(ChatFragment{Type: FragmentCheermote, Text: "Cheer5", URL: "https://example.com/cheer.gif", Bits: 5})
+	entry.Render()
+
+	wantHTML := TWITCH_ICON + ` <strong>Alice</strong>: hi <img title="Kappa" class="emoji" src="https://example.com/kappa.png">` +
+		` see <a href="https://example.com" target="_blank">https://example.com</a> ` +
+		`<img title="Cheer5" class="emoji" src="https://example.com/cheer.gif"><strong class="cheermote">5</strong>`
+	if entry.HTML != wantHTML {
+		t.Errorf("HTML = %q, want %q", entry.HTML, wantHTML)
+	}
+	if want := "  Alice: hi [Kappa] see https://example.com [Cheer5]\n"; entry.terminalMsg != want {
+		t.Errorf("terminal = %q, want %q", entry.terminalMsg, want)
+	}
+	if want := "hi  see https://example.com * Cheered 5 bits *"; entry.ttsMsg != want {
+		t.Errorf("TTS = %q, want %q", entry.ttsMsg, want)
+	}
+	if want := "hi  see  "; entry.textOnly != want {
+		t.Errorf("textOnly = %q, want %q", entry.textOnly, want)
+	}
+}
+
+func TestAppendShortcodes(t *testing.T) {
+	emotes := map[string]string{":cat:": "cat.png", ":dog:": "dog.png"}
+	text := func(s string) ChatFragment { return ChatFragment{Type: FragmentText, Text: s} }
+	cat := ChatFragment{Type: FragmentEmote, Text: ":cat:", URL: "cat.png"}
+	dog := ChatFragment{Type: FragmentEmote, Text: ":dog:", URL: "dog.png"}
+	tests := []struct {
+		text   string
+		emotes map[string]string
+		want   []ChatFragment
+	}{
+		{"hello :cat:!", emotes, []ChatFragment{text("hello "), cat, text("!")}},
+		{"::", emotes, []ChatFragment{text("::")}},
+		{":::", emotes, []ChatFragment{text(":::")}},
+		{":cat::dog:", emotes, []ChatFragment{cat, dog}},
+		{"::cat:", emotes, []ChatFragment{text(":"), cat}},
+		{":cat::", emotes, []ChatFragment{cat, text(":")}},
+		{"a :x:cat: b", emotes, []ChatFragment{text("a :x"), cat, text(" b")}},
+		{"ratio 1:2", emotes, []ChatFragment{text("ratio 1:2")}},
+		{":unknown: :cat", emotes, []ChatFragment{text(":unknown: :cat")}},
+		{":cat: without emotes", nil, []ChatFragment{text(":cat: without emotes")}},
+		{
+			"https://example.com/:cat: :cat:",
+			emotes,
+			[]ChatFragment{{Type: FragmentLink, Text: "https://example.com/:cat:", URL: "https://example.com/:cat:"}, text(" "), cat},
+		},
+	}
+	for _, test := range tests {
+		var entry ChatEntry
+		entry.AppendShortcodes(test.text, test.emotes)
+		if !slices.Equal(entry.Fragments, test.want) {
+			t.Errorf("AppendShortcodes(%q) = %+v, want %+v", test.text, entry.Fragments, test.want)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"cmp"
+	"html"
+	"regexp"
+	"strings"
+)
+
+// Replies. Twitch & Discord tell which message is being replied to. On YouTube, a message that starts with an
+// @mention of a recent chatter replies to their last message. The parent message is looked up in chat_log, so the
+// pages can quote it even when the platform doesn't send its text.
+
+// Maximum length of the quoted parent message, in runes.
+const replySnippetLength = 80
+
+type ChatReply struct {
+	// Twitch, YouTube or Discord ID of the parent message.
+	MessageID string `json:"message_id,omitempty"`
+	// ID of the parent message in chat_log. Zero when it's not in the history.
+	ID     int   `json:"id,omitempty"`
+	Author *User `json:"author,omitempty"`
+	// Beginning of the parent message, as plain text.
+	Text string `json:"text,omitempty"`
+}
+
+// YouTube handles & the first word of the older channel names (which may contain spaces).
+var youtubeMentionPattern = regexp.MustCompile(`@([\p{L}\p{N}_-]+(?:\.[\p{L}\p{N}_-]+)*)`)
+
+// replySnippet shortens the parent message for the quote.
+func replySnippet(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	runes := []rune(text)
+	if len(runes) <= replySnippetLength {
+		return text
+	}
+	return strings.TrimSpace(string(runes[:replySnippetLength-1])) + "…"
+}
+
+func (r ChatReply) HTML() string {
+	author := ""
+	if r.Author != nil {
+		author = r.Author.HTML() + ": "
+	}
+	return `<span class="reply">↪ ` + author + html.EscapeString(r.Text) + "</span>"
+}
+
+func (r ChatReply) Terminal() string {
+	author := ""
+	if r.Author != nil {
+		author = r.Author.DisplayName() + ": "
+	}
+	return "  ↪ " + author + r.Text + "\n"
+}
+
+// platformMessageID returns the ID of the message on its chat platform.
+func (t ChatEntry) platformMessageID() string {
+	return cmp.Or(t.TwitchMessageID, t.YouTubeMessageID, t.DiscordMessageID)
+}
+
+// resolveReply links the YouTube @mentions, finds the parent message in chat_log and removes the @mention of the
+// parent's author from the beginning of the reply. Returns true if the entry has to be rendered again.
+// Run this only on the main thread!
+func resolveReply(t *ChatEntry) bool {
+	changed := false
+	if t.Platform == PlatformYouTube && linkYouTubeMentions(t) {
+		changed = true
+		if t.ReplyTo == nil {
+			t.ReplyTo = youtubeReply(t)
+		}
+	}
+	if t.ReplyTo == nil {
+		return changed
+	}
+	if t.ReplyTo.ID == 0 && t.ReplyTo.MessageID != "" {
+		for i := len(chat_log) - 1; i >= 0; i-- {
+			parent := chat_log[i]
+			if parent.platformMessageID() != t.ReplyTo.MessageID {
+				continue
+			}
+			author := parent.Author
+			t.ReplyTo.ID = parent.ID
+			t.ReplyTo.Author = &author
+			t.ReplyTo.Text = replySnippet(parent.OriginalMessage)
+			break
+		}
+	}
+	trimReplyMention(t)
+	return true
+}
+
+// linkYouTubeMentions turns the @names of the recent YouTube chatters into mention fragments. Names with spaces are
+// matched by their first word. Full names take precedence, then the most recent chatter.
+func linkYouTubeMentions(t *ChatEntry) bool {
+	authors := map[string]User{}
+	firstWords := map[string]User{}
+	for _, entry := range chat_log {
+		if entry.Author.YouTubeUser == nil {
+			continue
+		}
+		name := strings.ToLower(strings.TrimPrefix(entry.Author.YouTubeUser.Name, "@"))
+		authors[name] = entry.Author
+		if first, _, found := strings.Cut(name, " "); found {
+			firstWords[first] = entry.Author
+		}
+	}
+	if len(authors) == 0 {
+		return false
+	}
+	fragments := t.Fragments
+	t.Fragments = nil
+	linked := false
+	for _, f := range fragments {
+		if f.Type != FragmentText {
+			t.Fragments = append(t.Fragments, f)
+			continue
+		}
+		last := 0
+		for _, loc := range youtubeMentionPattern.FindAllStringSubmatchIndex(f.Text, -1) {
+			name := strings.ToLower(f.Text[loc[2]:loc[3]])
+			user, found := authors[name]
+			if !found {
+				user, found = firstWords[name]
+			}
+			if !found {
+				continue
+			}
+			t.appendPlainText(f.Text[last:loc[0]])
+			t.Fragments = append(t.Fragments, ChatFragment{Type: FragmentMention, Text: f.Text[loc[0]:loc[1]], User: &user})
+			last = loc[1]
+			linked = true
+		}
+		t.appendPlainText(f.Text[last:])
+	}
+	return linked
+}
+
+// youtubeReply returns the last message of the user mentioned at the beginning of the message (if any).
+func youtubeReply(t *ChatEntry) *ChatReply {
+	i := 0
+	if len(t.Fragments) > 1 && t.Fragments[0].Type == FragmentText && strings.TrimSpace(t.Fragments[0].Text) == "" {
+		i = 1
+	}
+	if i >= len(t.Fragments) || t.Fragments[i].Type != FragmentMention || t.Fragments[i].User == nil {
+		return nil
+	}
+	key := t.Fragments[i].User.Key()
+	for j := len(chat_log) - 1; j >= 0; j-- {
+		if chat_log[j].Author.Key() == key {
+			return &ChatReply{MessageID: chat_log[j].YouTubeMessageID}
+		}
+	}
+	return nil
+}
+
+// trimReplyMention removes the @mention of the parent's author that platforms put at the beginning of replies. The
+// quote already shows who is being replied to.
+func trimReplyMention(t *ChatEntry) {
+	if t.ReplyTo.Author == nil {
+		return
+	}
+	i := 0
+	if len(t.Fragments) > 1 && t.Fragments[0].Type == FragmentText && strings.TrimSpace(t.Fragments[0].Text) == "" {
+		i = 1
+	}
+	if i >= len(t.Fragments) || t.Fragments[i].Type != FragmentMention || t.Fragments[i].User == nil || t.Fragments[i].User.Key() != t.ReplyTo.Author.Key() {
+		return
+	}
+	rest := t.Fragments[i+1:]
+	if len(rest) > 0 && rest[0].Type == FragmentText {
+		rest[0].Text = strings.TrimLeft(rest[0].Text, " ")
+		if rest[0].Text == "" {
+			rest = rest[1:]
+		}
+	}
+	if len(rest) > 0 { // keep replies that are just the @mention
+		t.Fragments = rest
+	}
+}
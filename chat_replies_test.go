@@ -0,0 +1,239 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+var (
+	replyAlice = User{TwitchUser: &TwitchUser{TwitchID: "1", Login: "alice", Name: "Alice"}}
+	replyBob   = User{YouTubeUser: &YouTubeUser{ChannelID: "UC2", Name: "@Bob"}}
+	replyJohn  = User{YouTubeUser: &YouTubeUser{ChannelID: "UC3", Name: "John Smith"}}
+	replyCarol = User{YouTubeUser: &YouTubeUser{ChannelID: "UC4", Name: "@Carol"}}
+)
+
+// withReplyChatLog replaces chat_log with a few messages for the duration of the test.
+func withReplyChatLog(t *testing.T) {
+	saved := chat_log
+	t.Cleanup(func() { chat_log = saved })
+	chat_log = []ChatEntry{
+		{ID: 1, Author: replyAlice, Platform: PlatformTwitch, TwitchMessageID: "tw-1", OriginalMessage: "hello   everyone"},
+		{ID: 2, Author: replyBob, Platform: PlatformYouTube, YouTubeMessageID: "yt-2", OriginalMessage: "first from bob"},
+		{ID: 3, Author: replyJohn, Platform: PlatformYouTube, YouTubeMessageID: "yt-3", OriginalMessage: "hi from john"},
+		{ID: 4, Author: replyBob, Platform: PlatformYouTube, YouTubeMessageID: "yt-4", OriginalMessage: "second from bob"},
+	}
+}
+
+// describeFragments returns the fragments in a compact form, for example `text:"hi" mention:"@Bob"(YouTube:UC2)`.
+func describeFragments(fragments []ChatFragment) string {
+	var parts []string
+	for _, f := range fragments {
+		part := f.Type + ":" + `"` + f.Text + `"`
+		if f.User != nil {
+			part += "(" + f.User.Key() + ")"
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, " ")
+}
+
+func mentionFragment(text string, user User) ChatFragment {
+	return ChatFragment{Type: FragmentMention, Text: text, User: &user}
+}
+
+func textFragment(text string) ChatFragment {
+	return ChatFragment{Type: FragmentText, Text: text}
+}
+
+func TestResolveReply(t *testing.T) {
+	withReplyChatLog(t)
+	tests := []struct {
+		name      string
+		entry     ChatEntry
+		changed   bool
+		fragments string
+		// Expected reply. Nil when the entry shouldn't be a reply.
+		reply *ChatReply
+	}{
+		{
+			name: "Twitch reply",
+			entry: ChatEntry{
+				Platform:  PlatformTwitch,
+				ReplyTo:   &ChatReply{MessageID: "tw-1"},
+				Fragments: []ChatFragment{mentionFragment("@alice", replyAlice), textFragment(" hi!")},
+			},
+			changed:   true,
+			fragments: `text:"hi!"`,
+			reply:     &ChatReply{MessageID: "tw-1", ID: 1, Author: &replyAlice, Text: "hello everyone"},
+		},
+		{
+			name: "Twitch reply to a message that's not in the history",
+			entry: ChatEntry{
+				Platform:  PlatformTwitch,
+				ReplyTo:   &ChatReply{MessageID: "tw-old"},
+				Fragments: []ChatFragment{mentionFragment("@alice", replyAlice), textFragment(" hi!")},
+			},
+			changed:   true,
+			fragments: `mention:"@alice"(Twitch:1) text:" hi!"`,
+			reply:     &ChatReply{MessageID: "tw-old"},
+		},
+		{
+			name:      "YouTube handle",
+			entry:     ChatEntry{Platform: PlatformYouTube, Fragments: []ChatFragment{textFragment("@bob thanks")}},
+			changed:   true,
+			fragments: `text:"thanks"`,
+			reply:     &ChatReply{MessageID: "yt-4", ID: 4, Author: &replyBob, Text: "second from bob"},
+		},
+		{
+			name:      "first word of a YouTube name",
+			entry:     ChatEntry{Platform: PlatformYouTube, Fragments: []ChatFragment{textFragment("@John. nice")}},
+			changed:   true,
+			fragments: `text:". nice"`,
+			reply:     &ChatReply{MessageID: "yt-3", ID: 3, Author: &replyJohn, Text: "hi from john"},
+		},
+		{
+			name:      "YouTube mention in the middle",
+			entry:     ChatEntry{Platform: PlatformYouTube, Fragments: []ChatFragment{textFragment("ask @Bob about it")}},
+			changed:   true,
+			fragments: `text:"ask " mention:"@Bob"(YouTube:UC2) text:" about it"`,
+		},
+		{
+			name:      "YouTube reply that's just the mention",
+			entry:     ChatEntry{Platform: PlatformYouTube, Fragments: []ChatFragment{textFragment("@Bob")}},
+			changed:   true,
+			fragments: `mention:"@Bob"(YouTube:UC2)`,
+			reply:     &ChatReply{MessageID: "yt-4", ID: 4, Author: &replyBob, Text: "second from bob"},
+		},
+		{
+			name:      "YouTube mention of an unknown user",
+			entry:     ChatEntry{Platform: PlatformYouTube, Fragments: []ChatFragment{textFragment("@dave hi")}},
+			fragments: `text:"@dave hi"`,
+		},
+		{
+			name:      "@ on Twitch",
+			entry:     ChatEntry{Platform: PlatformTwitch, Fragments: []ChatFragment{textFragment("@Bob hi")}},
+			fragments: `text:"@Bob hi"`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			entry := test.entry
+			if changed := resolveReply(&entry); changed != test.changed {
+				t.Errorf("resolveReply = %v, want %v", changed, test.changed)
+			}
+			if got := describeFragments(entry.Fragments); got != test.fragments {
+				t.Errorf("fragments = %s, want %s", got, test.fragments)
+			}
+			checkReply(t, entry.ReplyTo, test.reply)
+		})
+	}
+}
+
+func checkReply(t *testing.T, got, want *ChatReply) {
+	t.Helper()
+	if got == nil || want == nil {
+		if got != want {
+			t.Errorf("ReplyTo = %+v, want %+v", got, want)
+		}
+		return
+	}
+	gotAuthor, wantAuthor := "", ""
+	if got.Author != nil {
+		gotAuthor = got.Author.Key()
+	}
+	if want.Author != nil {
+		wantAuthor = want.Author.Key()
+	}
+	if got.MessageID != want.MessageID || got.ID != want.ID || gotAuthor != wantAuthor || got.Text != want.Text {
+		t.Errorf("ReplyTo = {%q %d %q %q}, want {%q %d %q %q}", got.MessageID, got.ID, gotAuthor, got.Text,
+			want.MessageID, want.ID, wantAuthor, want.Text)
+	}
+}
+
+func TestYouTubeReply(t *testing.T) {
+	withReplyChatLog(t)
+	tests := []struct {
+		name      string
+		fragments []ChatFragment
+		messageID string // empty when there's no reply
+	}{
+		{"mention", []ChatFragment{mentionFragment("@Bob", replyBob), textFragment(" hi")}, "yt-4"},
+		{"space before the mention", []ChatFragment{textFragment("  "), mentionFragment("@John", replyJohn)}, "yt-3"},
+		{"mention in the middle", []ChatFragment{textFragment("hi "), mentionFragment("@Bob", replyBob)}, ""},
+		{"user without messages", []ChatFragment{mentionFragment("@Carol", replyCarol)}, ""},
+		{"mention without a user", []ChatFragment{{Type: FragmentMention, Text: "@Bob"}}, ""},
+		{"no fragments", nil, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reply := youtubeReply(&ChatEntry{Platform: PlatformYouTube, Fragments: test.fragments})
+			messageID := ""
+			if reply != nil {
+				messageID = reply.MessageID
+			}
+			if messageID != test.messageID {
+				t.Errorf("youtubeReply = %q, want %q", messageID, test.messageID)
+			}
+		})
+	}
+}
+
+func TestTrimReplyMention(t *testing.T) {
+	tests := []struct {
+		name      string
+		author    *User
+		fragments []ChatFragment
+		want      string
+	}{
+		{
+			name:      "mention of the parent's author",
+			author:    &replyBob,
+			fragments: []ChatFragment{mentionFragment("@Bob", replyBob), textFragment("   thanks")},
+			want:      `text:"thanks"`,
+		},
+		{
+			name:      "space before the mention",
+			author:    &replyBob,
+			fragments: []ChatFragment{textFragment(" "), mentionFragment("@Bob", replyBob), textFragment(" thanks")},
+			want:      `text:"thanks"`,
+		},
+		{
+			name:      "mention followed by an emote",
+			author:    &replyBob,
+			fragments: []ChatFragment{mentionFragment("@Bob", replyBob), textFragment(" "), {Type: FragmentEmote, Text: "Kappa"}},
+			want:      `emote:"Kappa"`,
+		},
+		{
+			name:      "just the mention",
+			author:    &replyBob,
+			fragments: []ChatFragment{mentionFragment("@Bob", replyBob)},
+			want:      `mention:"@Bob"(YouTube:UC2)`,
+		},
+		{
+			name:      "mention of someone else",
+			author:    &replyBob,
+			fragments: []ChatFragment{mentionFragment("@John", replyJohn), textFragment(" thanks")},
+			want:      `mention:"@John"(YouTube:UC3) text:" thanks"`,
+		},
+		{
+			name:      "mention in the middle",
+			author:    &replyBob,
+			fragments: []ChatFragment{textFragment("thanks "), mentionFragment("@Bob", replyBob)},
+			want:      `text:"thanks " mention:"@Bob"(YouTube:UC2)`,
+		},
+		{
+			name:      "unknown parent",
+			fragments: []ChatFragment{mentionFragment("@Bob", replyBob), textFragment(" thanks")},
+			want:      `mention:"@Bob"(YouTube:UC2) text:" thanks"`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			entry := ChatEntry{Fragments: test.fragments, ReplyTo: &ChatReply{Author: test.author}}
+			trimReplyMention(&entry)
+			if got := describeFragments(entry.Fragments); got != test.want {
+				t.Errorf("fragments = %s, want %s", got, test.want)
+			}
+		})
+	}
+}
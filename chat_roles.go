@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Roles & badges of the chat authors. Platforms translate their own flags (Twitch badges, YouTube author details)
+// into the roles below, so the overlay, moderation and commands don't need to know where a message came from.
+
+const (
+	// Broadcaster on Twitch, owner of the live chat on YouTube.
+	RoleOwner     = "owner"
+	RoleModerator = "moderator"
+	RoleVIP       = "vip"
+	// Twitch subscriber. See ChatEntry.SubscriberTier.
+	RoleSubscriber = "subscriber"
+	// YouTube channel member.
+	RoleMember   = "member"
+	RoleVerified = "verified"
+)
+
+type ChatBadge struct {
+	// Badge set on Twitch ("moderator", "subscriber", "bits"...). On other platforms - the role.
+	SetID string `json:"set_id"`
+	ID    string `json:"id,omitempty"`
+	// Extra info. Number of months for Twitch subscriber badges.
+	Info string `json:"info,omitempty"`
+	// Image of the badge. Empty when it's unknown - the pages show badgeSymbols instead.
+	URL string `json:"url,omitempty"`
+}
+
+// Shown in place of the badges without images. Keep in sync with BADGE_SYMBOLS in script.js.
+var badgeSymbols = map[string]string{
+	"broadcaster":  "👑",
+	RoleOwner:      "👑",
+	RoleModerator:  "⚔️",
+	RoleVIP:        "💎",
+	RoleSubscriber: "⭐",
+	"founder":      "⭐",
+	RoleMember:     "⭐",
+	RoleVerified:   "✔️",
+}
+
+// AddRole adds the role to the author of the message (once).
+func (t *ChatEntry) AddRole(role string) {
+	if !slices.Contains(t.Roles, role) {
+		t.Roles = append(t.Roles, role)
+	}
+}
+
+// HasRole returns true if the author has any of the roles.
+func (t ChatEntry) HasRole(roles ...string) bool {
+	for _, role := range roles {
+		if slices.Contains(t.Roles, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsModerator returns true for the moderators & the owner of the chat.
+func (t ChatEntry) IsModerator() bool {
+	return t.HasRole(RoleOwner, RoleModerator)
+}
+
+func (b ChatBadge) HTML() string {
+	if b.URL != "" {
+		return fmt.Sprintf(`<img class="emoji badge" src="%s" title="%s">`, html.EscapeString(b.URL), html.EscapeString(b.SetID))
+	}
+	if symbol, ok := badgeSymbols[b.SetID]; ok {
+		return fmt.Sprintf(`<span class="badge" title="%s">%s</span>`, html.EscapeString(b.SetID), symbol)
+	}
+	return ""
+}
+
+func (t ChatEntry) badgesHTML() string {
+	var b strings.Builder
+	for _, badge := range t.Badges {
+		b.WriteString(badge.HTML())
+	}
+	return b.String()
+}
+
+func (t ChatEntry) badgesTerminal() string {
+	var b strings.Builder
+	for _, badge := range t.Badges {
+		b.WriteString(badgeSymbols[badge.SetID])
+	}
+	if b.Len() > 0 {
+		b.WriteString(" ")
+	}
+	return b.String()
+}
+
+// markChatHistory sets FirstMessage & Returning and remembers the message in the user record (saved together with
+// the points). Run this only on the main thread!
+func markChatHistory(t *ChatEntry) {
+	if t.Author.BotUser != nil || (t.Author.TwitchUser == nil && t.Author.YouTubeUser == nil && t.Author.DiscordUser == nil) {
+		return
+	}
+	record := t.Author.Record()
+	now := time.Now()
+	// Users who chatted before the history was recorded already have points
+	t.FirstMessage = record.FirstChat.IsZero() && record.Points == 0
+	if session := Stream.Session(); session != nil && !record.LastChat.IsZero() && record.LastChat.Before(session.Start) {
+		t.Returning = true
+	}
+	if record.FirstChat.IsZero() {
+		record.FirstChat = now
+	}
+	record.LastChat = now
+	pointsDirty = true
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/nicklaw5/helix/v2"
+	"google.golang.org/api/youtube/v3"
+)
+
+// Chat platforms that the bot can post to.
+const (
+	PlatformTwitch  = "twitch"
+	PlatformYouTube = "youtube"
+	PlatformDiscord = "discord"
+)
+
+var ChatPlatforms = []string{PlatformTwitch, PlatformYouTube, PlatformDiscord}
+
+// Twitch limits chat messages to 500 characters. YouTube allows only 200 - longer messages are split.
+const youtubeMaxChatMessageLength = 200
+
+// SendChat posts the message as the bot on the given platforms (all of them if none are given).
+// Returns immediately - errors are only logged.
+func SendChat(message string, platforms ...string) {
+	if len(platforms) == 0 {
+		platforms = ChatPlatforms
+	}
+	if slices.Contains(platforms, PlatformTwitch) {
+		TwitchHelixChannel <- func(client *helix.Client) {
+			resp, err := client.SendChatMessage(&helix.SendChatMessageParams{
+				BroadcasterID: twitchBroadcasterID,
+				SenderID:      twitchBotID,
+				Message:       message,
+			})
+			if err != nil {
+				twitchColor.Println("Couldn't send chat message:", err)
+				return
+			}
+			if resp.ErrorMessage != "" {
+				twitchColor.Println("Couldn't send chat message:", resp.ErrorMessage)
+			}
+		}
+	}
+	if slices.Contains(platforms, PlatformYouTube) {
+		go func() {
+			YouTubeBotChannel <- func(yt *youtube.Service) error {
+				if youtubeLiveChatID == "" {
+					return nil
+				}
+				for _, part := range splitChatMessage(message, youtubeMaxChatMessageLength) {
+					_, err := yt.LiveChatMessages.Insert([]string{"snippet"}, &youtube.LiveChatMessage{
+						Snippet: &youtube.LiveChatMessageSnippet{
+							LiveChatId: youtubeLiveChatID,
+							Type:       "textMessageEvent",
+							TextMessageDetails: &youtube.LiveChatTextMessageDetails{
+								MessageText: part,
+							},
+						},
+					}).Do()
+					if err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+		}()
+	}
+	if slices.Contains(platforms, PlatformDiscord) {
+		go func() {
+			if err := SendDiscordMessage(message); err != nil {
+				discordColor.Println("Couldn't send chat message:", err)
+			}
+		}()
+	}
+}
+
+// splitChatMessage splits the message into parts of at most limit runes, preferably between words.
+func splitChatMessage(message string, limit int) []string {
+	var parts []string
+	current := ""
+	for _, word := range strings.Fields(message) {
+		for len([]rune(word)) > limit {
+			if current != "" {
+				parts = append(parts, current)
+				current = ""
+			}
+			runes := []rune(word)
+			parts = append(parts, string(runes[:limit]))
+			word = string(runes[limit:])
+		}
+		if current == "" {
+			current = word
+		} else if len([]rune(current))+1+len([]rune(word)) <= limit {
+			current += " " + word
+		} else {
+			parts = append(parts, current)
+			current = word
+		}
+	}
+	if current != "" {
+		parts = append(parts, current)
+	}
+	return parts
+}
+
+// EscapeChatText makes user-provided text (like display names) safe to put in the bot's chat messages. It can't split
+// the message into several lines or ping anyone on Discord.
+func EscapeChatText(text string) string {
+	text = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, text)
+	return strings.ReplaceAll(text, "@", "@\u200b")
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+)
+
+// Optional configuration files live in the config directory. They're JSON files and every field has a default
+// value in Go, so the bot works without any of them.
+var configDir = path.Join(baseDir, "config")
+
+// LoadConfig fills cfg with the contents of config/<filename>. If the file doesn't exist, cfg keeps its defaults.
+func LoadConfig(filename string, cfg any) error {
+	bytes, err := os.ReadFile(path.Join(configDir, filename))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't read %s: %w", filename, err)
+	}
+	err = json.Unmarshal(bytes, cfg)
+	if err != nil {
+		return fmt.Errorf("couldn't parse %s: %w", filename, err)
+	}
+	return nil
+}
+
+func SaveConfig(filename string, cfg any) error {
+	bytes, err := json.MarshalIndent(cfg, "", "\t")
+	if err != nil {
+		return fmt.Errorf("couldn't marshal %s: %w", filename, err)
+	}
+	err = os.MkdirAll(configDir, 0755)
+	if err != nil {
+		return fmt.Errorf("couldn't create config directory: %w", err)
+	}
+	err = WriteStringToFile(path.Join(configDir, filename), string(bytes))
+	if err != nil {
+		return fmt.Errorf("couldn't write %s: %w", filename, err)
+	}
+	return nil
+}
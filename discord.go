@@ -3,13 +3,13 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"html"
 	"io"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"streambot/backoff"
 	"strings"
 	"time"
@@ -96,10 +96,9 @@ func fetchTenorGIF(gifID string) (*TenorGIF, error) {
 	return &tenorResp.Results[0], nil
 }
 
-// detectAndProcessGIFURLs detects GIF service URLs in content and returns HTML for them
-func detectAndProcessGIFURLs(content string, messageID string) (string, string, error) {
-	var attachmentHTML string
-	var attachmentText string
+// detectAndProcessGIFURLs detects GIF service URLs in content and returns them as attachments
+func detectAndProcessGIFURLs(content string, messageID string) ([]ChatFragment, error) {
+	var attachments []ChatFragment
 
 	// Check for Tenor URLs
 	if matches := tenorURLPattern.FindAllStringSubmatch(content, -1); len(matches) > 0 {
@@ -135,12 +134,10 @@ func detectAndProcessGIFURLs(content string, messageID string) (string, string,
 					if err != nil {
 						discordColor.Printf("Failed to download Tenor GIF %s: %v\n", gifURL, err)
 						// Fallback to direct URL
-						attachmentHTML += fmt.Sprintf(`<img src="%s" class="attachment" title="%s">`, gifURL, gif.Title)
+						attachments = append(attachments, ChatFragment{Type: FragmentAttachment, Text: gif.Title, URL: gifURL, Media: MediaImage})
 					} else {
-						attachmentHTML += fmt.Sprintf(`<img src="attachments/%s" class="attachment" title="%s">`, filename, gif.Title)
+						attachments = append(attachments, ChatFragment{Type: FragmentAttachment, Text: gif.Title, URL: "attachments/" + filename, Media: MediaImage})
 					}
-
-					attachmentText += fmt.Sprintf("[Tenor GIF: %s]", gif.ItemURL)
 				}
 			}
 		}
@@ -164,12 +161,10 @@ func detectAndProcessGIFURLs(content string, messageID string) (string, string,
 				if err != nil {
 					discordColor.Printf("Failed to download Giphy GIF %s: %v\n", gifURL, err)
 					// Fallback to direct URL
-					attachmentHTML += fmt.Sprintf(`<img src="%s" class="attachment">`, gifURL)
+					attachments = append(attachments, ChatFragment{Type: FragmentAttachment, Text: "Giphy GIF", URL: gifURL, Media: MediaImage})
 				} else {
-					attachmentHTML += fmt.Sprintf(`<img src="attachments/%s" class="attachment">`, filename)
+					attachments = append(attachments, ChatFragment{Type: FragmentAttachment, Text: "Giphy GIF", URL: "attachments/" + filename, Media: MediaImage})
 				}
-
-				attachmentText += "[Giphy GIF]"
 			}
 		}
 	}
@@ -188,16 +183,14 @@ func detectAndProcessGIFURLs(content string, messageID string) (string, string,
 			if err != nil {
 				discordColor.Printf("Failed to download Giphy media %s: %v\n", gifURL, err)
 				// Fallback to direct URL
-				attachmentHTML += fmt.Sprintf(`<img src="%s" class="attachment">`, gifURL)
+				attachments = append(attachments, ChatFragment{Type: FragmentAttachment, Text: "Giphy GIF", URL: gifURL, Media: MediaImage})
 			} else {
-				attachmentHTML += fmt.Sprintf(`<img src="attachments/%s" class="attachment">`, filename)
+				attachments = append(attachments, ChatFragment{Type: FragmentAttachment, Text: "Giphy GIF", URL: "attachments/" + filename, Media: MediaImage})
 			}
-
-			attachmentText += "[Giphy GIF]"
 		}
 	}
 
-	return attachmentHTML, attachmentText, nil
+	return attachments, nil
 }
 
 // Initialize the Discord session, connect to the server, and start listening for messages
@@ -209,7 +202,7 @@ func DiscordChatBot() {
 
 	for {
 		backoff.Attempt()
-		Webserver.Call("Ping", "Discord")
+		Webserver.CallTopic(TopicHealth, "Ping", "Discord")
 
 		// Create a new Discord session
 		dg, err := discordgo.New("Bot " + discordBotToken)
@@ -290,13 +283,9 @@ func messageHandler(s *discordgo.Session, m *discordgo.MessageCreate) {
 	}
 
 	// Convert Discord message to the standard ChatEntry format
-	username := m.Author.GlobalName
-	if username == "" {
-		username = m.Author.Username
-	}
 	discordUser := DiscordUser{
 		ID:       m.Author.ID,
-		Username: username,
+		Username: discordName(m.Author),
 		Avatar:   m.Author.Avatar,
 	}
 
@@ -309,51 +298,35 @@ func messageHandler(s *discordgo.Session, m *discordgo.MessageCreate) {
 	}
 
 	content := m.Content
-	textOnly := content
-	attachmentHTML := ""
-	attachmentText := ""
+	var attachments []ChatFragment
 
 	// Handle attachments if any
-	if len(m.Attachments) > 0 {
-		for _, attachment := range m.Attachments {
-
-			filename, err := downloadDiscordAttachment(attachment, m.ID)
-			if err != nil {
-				discordColor.Printf("Failed to download attachment %s: %v\n", attachment.Filename, err)
-				continue
-			}
-			attachmentText += fmt.Sprintf("[attachment %s]", attachment.Filename)
-			attachmentHTML += fmt.Sprintf("<a href=\"attachments/%s\">", filename)
-			// If it's an image, download it and add to HTML
-			if isImageFile(attachment.Filename) {
-				if err != nil {
-					discordColor.Printf("Failed to download attachment %s: %v\n", attachment.Filename, err)
-				} else {
-					// Convert to web path (replace backslashes with forward slashes for web)
-					attachmentHTML += fmt.Sprintf(`<img src="attachments/%s" class="attachment">`, filename)
-				}
-			} else {
-				attachmentHTML += attachment.Filename
-			}
-			attachmentHTML += "</a>"
+	for _, attachment := range m.Attachments {
+		filename, err := downloadDiscordAttachment(attachment, m.ID)
+		if err != nil {
+			discordColor.Printf("Failed to download attachment %s: %v\n", attachment.Filename, err)
+			continue
+		}
+		media := MediaFile
+		if isImageFile(attachment.Filename) {
+			media = MediaImage
 		}
+		attachments = append(attachments, ChatFragment{Type: FragmentAttachment, Text: attachment.Filename, URL: "attachments/" + filename, Media: media})
 	}
 
 	// Handle embeds (for GIFs from Tenor, Giphy, etc.)
 	if len(m.Embeds) == 0 {
 		// Only process URLs if there are no embeds (meaning Discord hasn't processed them yet)
-		gifHTML, gifText, err := detectAndProcessGIFURLs(content, m.ID)
+		gifs, err := detectAndProcessGIFURLs(content, m.ID)
 		if err != nil {
 			discordColor.Printf("Error processing GIF URLs: %v\n", err)
-		} else if gifHTML != "" {
+		} else if len(gifs) > 0 {
 			content = ""
-			attachmentHTML += gifHTML
-			attachmentText += gifText
+			attachments = append(attachments, gifs...)
 		}
 	} else {
 		content = ""
 		for _, embed := range m.Embeds {
-			attachmentText += fmt.Sprintf("[embed %s]", embed.URL)
 			// Check if the embed has an image (like GIFs)
 			if embed.Image != nil && embed.Image.URL != "" {
 				// Download the embed image (GIF)
@@ -361,43 +334,80 @@ func messageHandler(s *discordgo.Session, m *discordgo.MessageCreate) {
 				if err != nil {
 					discordColor.Printf("Failed to download embed image %s: %v\n", embed.Image.URL, err)
 					// If download fails, just show the image directly from the URL
-					attachmentHTML += fmt.Sprintf(`<img src="%s" class="attachment">`, embed.Image.URL)
+					attachments = append(attachments, ChatFragment{Type: FragmentAttachment, Text: embed.Title, URL: embed.Image.URL, Media: MediaImage})
 				} else {
 					// Use the downloaded image
-					attachmentHTML += fmt.Sprintf(`<img src="attachments/%s" class="attachment">`, filename)
+					attachments = append(attachments, ChatFragment{Type: FragmentAttachment, Text: embed.Title, URL: "attachments/" + filename, Media: MediaImage})
 				}
 			}
 			// Check if the embed has a video (less common but possible)
 			if embed.Video != nil && embed.Video.URL != "" {
-				attachmentHTML += fmt.Sprintf(`<video src="%s" class="attachment" autoplay loop controls></video>`, embed.Video.URL)
+				attachments = append(attachments, ChatFragment{Type: FragmentAttachment, Text: embed.Title, URL: embed.Video.URL, Media: MediaVideo})
 			}
 		}
 	}
 
-	// Handle mentions and replace them with proper names
-	for _, mention := range m.Mentions {
-		mentionText := fmt.Sprintf("<@%s>", mention.ID)
-		replacementText := fmt.Sprintf("@%s", mention.Username)
-		content = strings.Replace(content, mentionText, replacementText, -1)
-		textOnly = strings.Replace(textOnly, mentionText, replacementText, -1)
-	}
-
 	// Create chat entry
 	chatEntry := ChatEntry{
 		Author:           *user,
-		OriginalMessage:  content,
 		DiscordMessageID: m.ID,
+		Platform:         PlatformDiscord,
 		timestamp:        time.Now(),
-		textOnly:         textOnly,
-		ttsMsg:           VocalizeHTML(content),
-		terminalMsg:      fmt.Sprintf("%s: %s%s\n", user.DisplayName(), content, attachmentText),
-		HTML:             fmt.Sprintf(DISCORD_ICON+` %s: %s%s`, user.HTML(), html.EscapeString(content), attachmentHTML),
 	}
+	if parent := m.ReferencedMessage; m.Type == discordgo.MessageTypeReply && m.MessageReference != nil {
+		chatEntry.ReplyTo = &ChatReply{MessageID: m.MessageReference.MessageID}
+		if parent != nil && parent.Author != nil {
+			chatEntry.ReplyTo.Author = &User{DiscordUser: &DiscordUser{ID: parent.Author.ID, Username: discordName(parent.Author), Avatar: parent.Author.Avatar}}
+			chatEntry.ReplyTo.Text = replySnippet(discordPlainText(parent.Content, parent.Mentions))
+		}
+	}
+	appendDiscordContent(&chatEntry, content, m.Mentions)
+	for _, attachment := range attachments {
+		chatEntry.AppendFragment(attachment)
+	}
+	chatEntry.Render()
+
+	chatEntry.OriginalMessage = discordPlainText(content, m.Mentions)
+
+	Events.Publish(ChatMessage{Entry: chatEntry})
+}
+
+var discordMentionPattern = regexp.MustCompile(`<@!?(\d+)>`)
 
-	// Process message in the main channel
-	MainChannel <- func() {
-		MainOnChatEntry(chatEntry)
+// appendDiscordContent adds the text of the message, turning <@id> into mention fragments.
+func appendDiscordContent(entry *ChatEntry, content string, mentions []*discordgo.User) {
+	last := 0
+	for _, loc := range discordMentionPattern.FindAllStringSubmatchIndex(content, -1) {
+		id := content[loc[2]:loc[3]]
+		i := slices.IndexFunc(mentions, func(u *discordgo.User) bool { return u.ID == id })
+		if i < 0 {
+			continue
+		}
+		entry.AppendText(content[last:loc[0]])
+		name := discordName(mentions[i])
+		mention := User{DiscordUser: &DiscordUser{ID: id, Username: name, Avatar: mentions[i].Avatar}}
+		entry.AppendFragment(ChatFragment{Type: FragmentMention, Text: "@" + name, User: &mention})
+		last = loc[1]
 	}
+	entry.AppendText(content[last:])
+}
+
+// discordPlainText replaces the <@id> mentions with @names.
+func discordPlainText(content string, mentions []*discordgo.User) string {
+	for _, mention := range mentions {
+		mentionText := fmt.Sprintf("<@%s>", mention.ID)
+		replacementText := fmt.Sprintf("@%s", mention.Username)
+		content = strings.Replace(content, mentionText, replacementText, -1)
+	}
+	return content
+}
+
+// discordName returns the display name of the user, or the username if there's none.
+func discordName(u *discordgo.User) string {
+	if u.GlobalName != "" {
+		return u.GlobalName
+	}
+	return u.Username
 }
 
 // Delete a Discord message
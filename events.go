@@ -0,0 +1,260 @@
+package main
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// Event bus. Subsystems publish typed events (chat messages, follows, scene changes...) and other subsystems
+// subscribe to the kinds they care about. Each subscriber has its own buffer and decides what happens when it's full
+// (see BackpressurePolicy). All events can be watched live on /events.html.
+//
+// Requests that must run on a specific thread (MainChannel, OBSChannel, TwitchHelixChannel...) are not events - they
+// are typed function channels.
+
+var eventsColor = color.New(color.FgHiBlack)
+
+type Event interface {
+	// Kind of the event. Same as the name of the type.
+	EventKind() string
+}
+
+// ChatMessage is a message that should appear in the chat (from any platform, or generated by the bot).
+// Never publish it from the main thread - the main thread consumes it with the Block policy. Threads that the main
+// thread sends requests to (like the Twitch Helix thread) must use PublishAsync for the same reason.
+type ChatMessage struct {
+	Entry ChatEntry `json:"entry"`
+}
+
+type Follow struct {
+	User User `json:"user"`
+}
+
+type Raid struct {
+	From    User `json:"from"`
+	Viewers int  `json:"viewers"`
+}
+
+type Redemption struct {
+	User   User   `json:"user"`
+	Reward string `json:"reward"`
+	Input  string `json:"input,omitempty"`
+}
+
+const (
+	ModerationBan    = "ban"
+	ModerationMute   = "mute"
+	ModerationUnmute = "unmute"
+	ModerationDelete = "delete"
+)
+
+type ModerationAction struct {
+	Action string `json:"action"`
+	Target User   `json:"target"`
+	// ID of the deleted message (for ModerationDelete)
+	MessageID int `json:"message_id,omitempty"`
+}
+
+type SceneChanged struct {
+	Scene string `json:"scene"`
+}
+
+type StreamStateChanged struct {
+	Source string `json:"source"`
+	Online bool   `json:"online"`
+}
+
+// TTSStarted is published when the player starts a TTS message or a narrated alert.
+type TTSStarted struct {
+	// Author of the chat message. Empty for alerts.
+	Author *User  `json:"author,omitempty"`
+	Bus    string `json:"bus"`
+}
+
+type TTSFinished struct {
+	Author *User  `json:"author,omitempty"`
+	Bus    string `json:"bus"`
+}
+
+func (ChatMessage) EventKind() string        { return "ChatMessage" }
+func (Follow) EventKind() string             { return "Follow" }
+func (Raid) EventKind() string               { return "Raid" }
+func (Redemption) EventKind() string         { return "Redemption" }
+func (ModerationAction) EventKind() string   { return "ModerationAction" }
+func (SceneChanged) EventKind() string       { return "SceneChanged" }
+func (StreamStateChanged) EventKind() string { return "StreamStateChanged" }
+func (TTSStarted) EventKind() string         { return "TTSStarted" }
+func (TTSFinished) EventKind() string        { return "TTSFinished" }
+
+// BackpressurePolicy decides what happens to events published while the subscriber's buffer is full.
+type BackpressurePolicy int
+
+const (
+	// Block the publisher until there is space. For subscribers that must see every event.
+	Block BackpressurePolicy = iota
+	// Drop the event that's being published.
+	DropNewest
+	// Drop the oldest buffered event to make space. For subscribers that only care about recent events.
+	DropOldest
+)
+
+func (p BackpressurePolicy) String() string {
+	switch p {
+	case Block:
+		return "block"
+	case DropNewest:
+		return "drop_newest"
+	case DropOldest:
+		return "drop_oldest"
+	}
+	return "unknown"
+}
+
+type Subscription struct {
+	Name   string
+	Policy BackpressurePolicy
+	// Receives the events. Never closed.
+	C       <-chan Event
+	ch      chan Event
+	kinds   []string // empty means all events
+	dropped atomic.Uint64
+	// Serializes DropOldest deliveries, so two publishers don't both drop an event for the same slot.
+	mu sync.Mutex
+}
+
+func (s *Subscription) wants(e Event) bool {
+	return len(s.kinds) == 0 || slices.Contains(s.kinds, e.EventKind())
+}
+
+func (s *Subscription) deliver(e Event) {
+	switch s.Policy {
+	case Block:
+		select {
+		case s.ch <- e:
+			return
+		default:
+		}
+		start := time.Now()
+		s.ch <- e
+		if blocked := time.Since(start); blocked > time.Second {
+			eventsColor.Printf("%s event blocked for %s by subscriber %q\n", e.EventKind(), blocked.Round(time.Millisecond), s.Name)
+		}
+	case DropNewest:
+		select {
+		case s.ch <- e:
+		default:
+			s.dropped.Add(1)
+		}
+	case DropOldest:
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for {
+			select {
+			case s.ch <- e:
+				return
+			default:
+			}
+			select {
+			case <-s.ch:
+				s.dropped.Add(1)
+			default:
+			}
+		}
+	}
+}
+
+type EventBus struct {
+	mu   sync.RWMutex
+	subs []*Subscription
+}
+
+var Events = &EventBus{}
+
+// Subscribe starts delivering events of the given kinds (all events if none are given) to the returned
+// subscription.
+func (b *EventBus) Subscribe(name string, policy BackpressurePolicy, buffer int, kinds ...string) *Subscription {
+	ch := make(chan Event, buffer)
+	s := &Subscription{Name: name, Policy: policy, C: ch, ch: ch, kinds: kinds}
+	b.mu.Lock()
+	b.subs = append(b.subs, s)
+	b.mu.Unlock()
+	return s
+}
+
+func (b *EventBus) Unsubscribe(s *Subscription) {
+	b.mu.Lock()
+	b.subs = slices.DeleteFunc(b.subs, func(other *Subscription) bool { return other == s })
+	b.mu.Unlock()
+}
+
+// Publish delivers the event to all interested subscribers, in the order of subscription. It may block if one of
+// them uses the Block policy.
+func (b *EventBus) Publish(e Event) {
+	b.mu.RLock()
+	subs := slices.Clone(b.subs)
+	b.mu.RUnlock()
+	for _, s := range subs {
+		if s.wants(e) {
+			s.deliver(e)
+		}
+	}
+}
+
+// PublishAsync publishes the events, in order, from a new goroutine, so the caller never waits for the subscribers.
+func (b *EventBus) PublishAsync(events ...Event) {
+	go func() {
+		for _, e := range events {
+			b.Publish(e)
+		}
+	}()
+}
+
+type SubscriptionStats struct {
+	Name     string   `json:"name"`
+	Policy   string   `json:"policy"`
+	Kinds    []string `json:"kinds"`
+	Buffered int      `json:"buffered"`
+	Capacity int      `json:"capacity"`
+	Dropped  uint64   `json:"dropped"`
+}
+
+func (b *EventBus) Stats() []SubscriptionStats {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	var stats []SubscriptionStats
+	for _, s := range b.subs {
+		stats = append(stats, SubscriptionStats{
+			Name:     s.Name,
+			Policy:   s.Policy.String(),
+			Kinds:    s.kinds,
+			Buffered: len(s.ch),
+			Capacity: cap(s.ch),
+			Dropped:  s.dropped.Load(),
+		})
+	}
+	return stats
+}
+
+// EventTap forwards all events to the websocket clients that called TapEvents.
+func EventTap() {
+	tap := Events.Subscribe("tap", DropOldest, 256)
+	for e := range tap.C {
+		Webserver.CallTappers("BusEvent", e.EventKind(), time.Now(), e)
+	}
+}
+
+// Websocket handlers
+
+// TapEventsHandler makes the client receive all events from the bus (as BusEvent calls).
+func TapEventsHandler(c *WebsocketClient) []SubscriptionStats {
+	c.tap.Store(true)
+	return Events.Stats()
+}
+
+func GetEventBusStatsHandler(c *WebsocketClient) []SubscriptionStats {
+	return Events.Stats()
+}
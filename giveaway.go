@@ -0,0 +1,409 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	mathrand "math/rand/v2"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/nicklaw5/helix/v2"
+)
+
+// Giveaways. Viewers on every platform enter by typing the keyword in chat. Accounts linked with !login enter only
+// once.
+//
+// The draw is reproducible: a random seed is generated when the giveaway opens and only its SHA-256 hash is
+// announced. The seed is kept in memory while the entries are open and revealed by the draw - eligible entrants sorted
+// by key and shuffled with ChaCha8 seeded with it give the same winners. All giveaways are saved in giveaways.json.
+
+var giveawaysPath = path.Join(baseDir, "giveaways.json")
+
+type GiveawayRules struct {
+	// Only entrants whose linked Twitch account follows the channel.
+	FollowersOnly bool `json:"followers_only"`
+	// Minimum number of messages in chat_log.txt (all time, before the entry).
+	MinMessages int `json:"min_messages"`
+}
+
+type GiveawayEntrant struct {
+	// Linked user key - see User.LinkedKey
+	Key      string `json:"key"`
+	Name     string `json:"name"`
+	TwitchID string `json:"twitch_id,omitempty"`
+	Messages int    `json:"messages"`
+	Follower bool   `json:"follower"`
+	// ID of the last message in chat_log.txt when the entrant entered. Later messages (including the keyword) don't
+	// count towards MinMessages.
+	lastChatID int
+}
+
+type Giveaway struct {
+	ID       int           `json:"id"`
+	Keyword  string        `json:"keyword"`
+	Prize    string        `json:"prize"`
+	Rules    GiveawayRules `json:"rules"`
+	Opened   time.Time     `json:"opened"`
+	Closed   *time.Time    `json:"closed,omitempty"`
+	SeedHash string        `json:"seed_hash"`
+	// Empty until the entries are closed.
+	Seed     string            `json:"seed,omitempty"`
+	Entrants []GiveawayEntrant `json:"entrants"`
+	// Keys of the eligible entrants, in the order used for the draw.
+	Eligible  []string          `json:"eligible,omitempty"`
+	Winners   []GiveawayEntrant `json:"winners,omitempty"`
+	Cancelled bool              `json:"cancelled,omitempty"`
+	// Shuffled indices into Eligible. Winners are taken from it in order.
+	order []int
+	// Set while follower status is checked on Twitch.
+	checking bool
+	// Hidden seed of an open giveaway. Never saved - the giveaway is cancelled if the bot restarts before the draw.
+	seed string
+}
+
+// Only accessed from the main thread.
+var giveaways []*Giveaway
+
+func LoadGiveaways() error {
+	bytes, err := os.ReadFile(giveawaysPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(bytes, &giveaways); err != nil {
+		return fmt.Errorf("couldn't parse %s: %w", giveawaysPath, err)
+	}
+	// Unfinished giveaways can't be resumed because their seeds weren't saved
+	for _, g := range giveaways {
+		if g.Closed == nil {
+			now := time.Now()
+			g.Closed = &now
+			g.Cancelled = true
+		}
+	}
+	return nil
+}
+
+func saveGiveaways() {
+	bytes, err := json.MarshalIndent(giveaways, "", "\t")
+	if err != nil {
+		warn_color.Println("Couldn't marshal giveaways:", err)
+		return
+	}
+	if err := WriteStringToFile(giveawaysPath, string(bytes)); err != nil {
+		warn_color.Println("Couldn't save giveaways:", err)
+	}
+}
+
+// ActiveGiveaway returns the latest giveaway, unless it was cancelled. Run this only on the main thread!
+func ActiveGiveaway() *Giveaway {
+	if len(giveaways) == 0 {
+		return nil
+	}
+	g := giveaways[len(giveaways)-1]
+	if g.Cancelled {
+		return nil
+	}
+	return g
+}
+
+func (g *Giveaway) IsOpen() bool {
+	return g.Closed == nil
+}
+
+// Public returns a copy that can be shown to the admins.
+func (g *Giveaway) Public() Giveaway {
+	return *g
+}
+
+func (g *Giveaway) push() {
+	Webserver.CallAdmins("GiveawayUpdate", g.Public())
+}
+
+// OpenGiveaway starts collecting entries. Run this only on the main thread!
+func OpenGiveaway(keyword, prize string, rules GiveawayRules) error {
+	keyword = strings.TrimSpace(keyword)
+	if keyword == "" || strings.ContainsAny(keyword, " \t") {
+		return fmt.Errorf("the keyword must be a single word")
+	}
+	if strings.TrimSpace(prize) == "" {
+		return fmt.Errorf("empty prize")
+	}
+	if g := ActiveGiveaway(); g != nil && g.IsOpen() {
+		return fmt.Errorf("giveaway for %q is still open", g.Prize)
+	}
+	var seed [32]byte
+	if _, err := rand.Read(seed[:]); err != nil {
+		return fmt.Errorf("couldn't generate seed: %w", err)
+	}
+	hash := sha256.Sum256(seed[:])
+	g := &Giveaway{
+		ID:       len(giveaways) + 1,
+		Keyword:  keyword,
+		Prize:    prize,
+		Rules:    rules,
+		Opened:   time.Now(),
+		SeedHash: hex.EncodeToString(hash[:]),
+		seed:     hex.EncodeToString(seed[:]),
+	}
+	giveaways = append(giveaways, g)
+	saveGiveaways()
+
+	var requirements []string
+	if rules.FollowersOnly {
+		requirements = append(requirements, "Twitch followers only")
+	}
+	if rules.MinMessages > 0 {
+		requirements = append(requirements, fmt.Sprintf("at least %d chat messages", rules.MinMessages))
+	}
+	message := fmt.Sprintf("🎁 Giveaway: %s! Type %s in chat to enter.", prize, keyword)
+	if len(requirements) > 0 {
+		message += " (" + strings.Join(requirements, ", ") + ")"
+	}
+	SendChat(message)
+	SendChat(fmt.Sprintf("🎁 Seed hash: %s", g.SeedHash))
+	g.push()
+	return nil
+}
+
+// OnGiveawayEntry handles messages with the giveaway keyword. Returns true if the message was an entry.
+func OnGiveawayEntry(t ChatEntry) bool {
+	g := ActiveGiveaway()
+	if g == nil || !g.IsOpen() || !strings.EqualFold(strings.TrimSpace(t.OriginalMessage), g.Keyword) {
+		return false
+	}
+	if t.Author.BotUser != nil {
+		return true
+	}
+	settings := t.Author.LoadSettings()
+	key := settings.Key()
+	for _, entrant := range g.Entrants {
+		if entrant.Key == key {
+			return true
+		}
+	}
+	entrant := GiveawayEntrant{Key: key, Name: t.Author.DisplayName(), lastChatID: lastChatID()}
+	if settings.TwitchUser != nil {
+		entrant.TwitchID = settings.TwitchUser.TwitchID
+	}
+	g.Entrants = append(g.Entrants, entrant)
+	g.push()
+	return true
+}
+
+// countChatMessages returns the number of messages in chat_log.txt per linked user, counting only the messages up
+// to maxIDs[key]. Run this only on the main thread!
+func countChatMessages(maxIDs map[string]int) (map[string]int, error) {
+	file, err := os.Open("chat_log.txt")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	counts := map[string]int{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		entry, err := MakeChatEntry(scanner.Text())
+		if err != nil || entry.Author.BotUser != nil {
+			continue
+		}
+		key := entry.Author.LinkedKey()
+		if maxID, found := maxIDs[key]; !found || entry.ID > maxID {
+			continue
+		}
+		counts[key]++
+	}
+	return counts, scanner.Err()
+}
+
+// DrawGiveaway closes the entries (if still open) and picks the next winner. Run this only on the main thread!
+func DrawGiveaway() error {
+	g := ActiveGiveaway()
+	if g == nil {
+		return fmt.Errorf("no giveaway")
+	}
+	if g.checking {
+		return fmt.Errorf("still checking followers")
+	}
+	if !g.IsOpen() {
+		return g.drawNext()
+	}
+	now := time.Now()
+	g.Closed = &now
+	// The entries can't change anymore, so the seed can be revealed
+	g.Seed = g.seed
+	SendChat(fmt.Sprintf("🎁 Entries for %s are closed!", g.Prize))
+
+	if g.Rules.MinMessages > 0 {
+		maxIDs := map[string]int{}
+		for _, entrant := range g.Entrants {
+			maxIDs[entrant.Key] = entrant.lastChatID
+		}
+		counts, err := countChatMessages(maxIDs)
+		if err != nil {
+			warn_color.Println("Couldn't count chat messages:", err)
+		}
+		for i := range g.Entrants {
+			g.Entrants[i].Messages = counts[g.Entrants[i].Key]
+		}
+	}
+	if !g.Rules.FollowersOnly {
+		if err := g.shuffle(); err != nil {
+			return err
+		}
+		return g.drawNext()
+	}
+	g.checking = true
+	entrants := slices.Clone(g.Entrants)
+	TwitchHelixChannel <- func(client *helix.Client) {
+		for i, entrant := range entrants {
+			if entrant.TwitchID == "" {
+				continue
+			}
+			resp, err := client.GetChannelFollows(&helix.GetChannelFollowsParams{
+				BroadcasterID: twitchBroadcasterID,
+				UserID:        entrant.TwitchID,
+			})
+			if err != nil {
+				twitchColor.Println("Couldn't check follower status:", err)
+				continue
+			}
+			entrants[i].Follower = len(resp.Data.Channels) > 0
+		}
+		go func() {
+			MainChannel <- func() {
+				g.checking = false
+				if g.Cancelled {
+					return
+				}
+				for i := range g.Entrants {
+					g.Entrants[i].Follower = entrants[i].Follower
+				}
+				err := g.shuffle()
+				if err == nil {
+					err = g.drawNext()
+				}
+				if err != nil {
+					warn_color.Println("DrawGiveaway:", err)
+				}
+			}
+		}()
+	}
+	return nil
+}
+
+func (g *Giveaway) eligible(entrant GiveawayEntrant) bool {
+	if g.Rules.FollowersOnly && !entrant.Follower {
+		return false
+	}
+	return entrant.Messages >= g.Rules.MinMessages
+}
+
+// shuffle fixes the list of eligible entrants and their draw order.
+func (g *Giveaway) shuffle() error {
+	g.Eligible = nil
+	for _, entrant := range g.Entrants {
+		if g.eligible(entrant) {
+			g.Eligible = append(g.Eligible, entrant.Key)
+		}
+	}
+	slices.Sort(g.Eligible)
+	order, err := GiveawayOrder(g.Seed, len(g.Eligible))
+	if err != nil {
+		return err
+	}
+	g.order = order
+	return nil
+}
+
+// GiveawayOrder returns the draw order for n eligible entrants sorted by key. Anyone can use it to verify the draw.
+func GiveawayOrder(seedHex string, n int) ([]int, error) {
+	var seed [32]byte
+	if len(seedHex) != hex.EncodedLen(len(seed)) {
+		return nil, fmt.Errorf("giveaway seed must have %d hex digits", hex.EncodedLen(len(seed)))
+	}
+	if _, err := hex.Decode(seed[:], []byte(seedHex)); err != nil {
+		return nil, fmt.Errorf("invalid giveaway seed: %w", err)
+	}
+	return mathrand.New(mathrand.NewChaCha8(seed)).Perm(n), nil
+}
+
+func (g *Giveaway) drawNext() error {
+	if g.order == nil {
+		// Loaded from the file - restore the order
+		order, err := GiveawayOrder(g.Seed, len(g.Eligible))
+		if err != nil {
+			return err
+		}
+		g.order = order
+	}
+	defer saveGiveaways()
+	defer g.push()
+	if len(g.Winners) >= len(g.order) {
+		SendChat(fmt.Sprintf("🎁 No more eligible entrants for %s (%d entered)", g.Prize, len(g.Entrants)))
+		return fmt.Errorf("no more eligible entrants")
+	}
+	key := g.Eligible[g.order[len(g.Winners)]]
+	i := slices.IndexFunc(g.Entrants, func(e GiveawayEntrant) bool { return e.Key == key })
+	winner := g.Entrants[i]
+	g.Winners = append(g.Winners, winner)
+
+	message := fmt.Sprintf("🎁 %s won %s! (%d eligible of %d entrants, seed %s)", EscapeChatText(winner.Name), g.Prize, len(g.Eligible), len(g.Entrants), g.Seed)
+	chat_color.Println(message)
+	SendChat(message)
+	alert := Alert{HTML: fmt.Sprintf(`<div class="big">%s</div>won %s!`, html.EscapeString(winner.Name), html.EscapeString(g.Prize))}
+	go func() { TTSChannel <- alert }()
+	return nil
+}
+
+// CancelGiveaway drops the active giveaway without drawing. Run this only on the main thread!
+func CancelGiveaway() {
+	g := ActiveGiveaway()
+	if g == nil {
+		return
+	}
+	if g.IsOpen() {
+		now := time.Now()
+		g.Closed = &now
+		SendChat(fmt.Sprintf("🎁 Giveaway for %s was cancelled", g.Prize))
+	}
+	g.Cancelled = true
+	saveGiveaways()
+	Webserver.CallAdmins("GiveawayUpdate", nil)
+}
+
+// Websocket handlers
+
+func OpenGiveawayHandler(c *WebsocketClient, keyword, prize string, rules GiveawayRules) error {
+	return onMainThread(func() error {
+		return OpenGiveaway(keyword, prize, rules)
+	})
+}
+
+func DrawGiveawayHandler(c *WebsocketClient) error {
+	return onMainThread(DrawGiveaway)
+}
+
+func CancelGiveawayHandler(c *WebsocketClient) {
+	MainChannel <- CancelGiveaway
+}
+
+func GetGiveawaysHandler(c *WebsocketClient) []Giveaway {
+	return onMainThread(func() []Giveaway {
+		var history []Giveaway
+		for _, g := range giveaways {
+			history = append(history, g.Public())
+		}
+		return history
+	})
+}
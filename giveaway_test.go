@@ -0,0 +1,40 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestGiveawayOrder(t *testing.T) {
+	seed := strings.Repeat("0123456789abcdef", 4)
+	order, err := GiveawayOrder(seed, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sorted := slices.Sorted(slices.Values(order))
+	if want := []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}; !slices.Equal(sorted, want) {
+		t.Errorf("GiveawayOrder = %v, not a permutation of 0-9", order)
+	}
+	again, err := GiveawayOrder(seed, 10)
+	if err != nil || !slices.Equal(order, again) {
+		t.Errorf("GiveawayOrder isn't reproducible: %v, then %v (%v)", order, again, err)
+	}
+
+	tests := []struct {
+		name string
+		seed string
+	}{
+		{"empty", ""},
+		{"too short", seed[:62]},
+		{"too long", seed + "00"},
+		{"not hex", strings.Repeat("zz", 32)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if order, err := GiveawayOrder(test.seed, 10); err == nil {
+				t.Errorf("GiveawayOrder(%q) = %v, want an error", test.seed, order)
+			}
+		})
+	}
+}
@@ -22,17 +22,32 @@ type Alert struct {
 }
 
 type ChatEntry struct {
-	Author           User   `json:"author,omitempty"`
-	OriginalMessage  string `json:"original_message"`
-	HTML             string `json:"html,omitempty"`
-	TwitchMessageID  string `json:"twitch_message_id,omitempty"`
-	YouTubeMessageID string `json:"youtube_message_id,omitempty"`
-	DiscordMessageID string `json:"discord_message_id,omitempty"`
-	ID               int    `json:"id,omitempty"`
+	Author          User   `json:"author,omitempty"`
+	OriginalMessage string `json:"original_message"`
+	HTML            string `json:"html,omitempty"`
+	// PlatformTwitch, PlatformYouTube or PlatformDiscord. Empty for the messages of the bot.
+	Platform string `json:"platform,omitempty"`
+	// Structured message. HTML, terminalMsg, ttsMsg & textOnly are rendered from it (see chat_fragments.go).
+	Fragments        []ChatFragment `json:"fragments,omitempty"`
+	TwitchMessageID  string         `json:"twitch_message_id,omitempty"`
+	YouTubeMessageID string         `json:"youtube_message_id,omitempty"`
+	DiscordMessageID string         `json:"discord_message_id,omitempty"`
+	ID               int            `json:"id,omitempty"`
 	ttsMsg           string
 	timestamp        time.Time
 	terminalMsg      string
 	textOnly         string // user-generated text, excluding emotes
+	// Normalized roles of the author (RoleOwner, RoleModerator...) & the badges shown next to the name (see chat_roles.go).
+	Roles  []string    `json:"roles,omitempty"`
+	Badges []ChatBadge `json:"badges,omitempty"`
+	// 1-3 for Twitch subscribers.
+	SubscriberTier int `json:"subscriber_tier,omitempty"`
+	// First message of the author in the channel.
+	FirstMessage bool `json:"first_message,omitempty"`
+	// First message in this stream of an author who chatted during earlier streams.
+	Returning bool `json:"returning,omitempty"`
+	// Message that this one replies to (see chat_replies.go).
+	ReplyTo *ChatReply `json:"reply_to,omitempty"`
 }
 
 func (t ChatEntry) TryTTS() {
@@ -87,6 +102,16 @@ const nChatMessages = 20
 
 const x11_display = ":0"
 
+// lastChatID returns the ID of the last message appended to chat_log.txt.
+func lastChatID() int {
+	id_bytes, err := os.ReadFile("chat_id.txt")
+	id_int := 0
+	if err == nil {
+		fmt.Sscanf(string(id_bytes), "%d", &id_int)
+	}
+	return id_int
+}
+
 func ReadLastChatLog() ([]ChatEntry, error) {
 	file, err := os.Open("chat_log.txt")
 	if err != nil {
@@ -118,6 +143,9 @@ var linguaDetector = lingua.NewLanguageDetectorBuilder().FromLanguages(lingua.En
 var lastReminderTime time.Time
 
 func MainOnChatEntry(t ChatEntry) {
+	if resolveReply(&t) {
+		t.Render()
+	}
 	if t.terminalMsg != "" {
 		chat_color.Printf("%s", t.terminalMsg)
 	}
@@ -138,6 +166,7 @@ func MainOnChatEntry(t ChatEntry) {
 			// Sign out of the old session (if any)
 			if oldSession, found := TwitchIndex[t.Author.TwitchUser.Key()]; found {
 				oldSession.TwitchUser = nil
+				mergePoints(newSession, oldSession)
 			}
 			newSession.TwitchUser = t.Author.TwitchUser
 			TwitchIndex[newSession.TwitchUser.Key()] = newSession
@@ -146,6 +175,7 @@ func MainOnChatEntry(t ChatEntry) {
 			// Sign out of the old session (if any)
 			if oldSession, found := YouTubeIndex[t.Author.YouTubeUser.Key()]; found {
 				oldSession.YouTubeUser = nil
+				mergePoints(newSession, oldSession)
 			}
 			newSession.YouTubeUser = t.Author.YouTubeUser
 			YouTubeIndex[newSession.YouTubeUser.Key()] = newSession
@@ -154,6 +184,7 @@ func MainOnChatEntry(t ChatEntry) {
 			// Sign out of the old session (if any)
 			if oldSession, found := DiscordIndex[t.Author.DiscordUser.Key()]; found {
 				oldSession.DiscordUser = nil
+				mergePoints(newSession, oldSession)
 			}
 			newSession.DiscordUser = t.Author.DiscordUser
 			DiscordIndex[newSession.DiscordUser.Key()] = newSession
@@ -169,6 +200,19 @@ func MainOnChatEntry(t ChatEntry) {
 		return
 	}
 
+	markChatHistory(&t)
+	if t.FirstMessage {
+		chat_color.Printf("  ✨ First message from %s\n", t.Author.DisplayName())
+	} else if t.Returning {
+		chat_color.Printf("  👋 %s is back\n", t.Author.DisplayName())
+	}
+
+	OnChatPoints(t)
+	OnTimersChatMessage(t)
+	if OnSoundCommand(t) || OnVoteCommand(t) || OnGiveawayEntry(t) || OnPointsCommand(t) {
+		t.ttsMsg = ""
+	}
+
 	msgCount := 0
 	for _, chat_entry := range chat_log {
 		if chat_entry.Author.Key() == t.Author.Key() {
@@ -190,7 +234,7 @@ func MainOnChatEntry(t ChatEntry) {
 		detectionThreshold = 0.99
 	}
 
-	if len(t.textOnly) >= 5 {
+	if len(t.textOnly) >= 5 && !t.IsModerator() {
 		polishConfidence := linguaDetector.ComputeLanguageConfidence(t.textOnly, lingua.Polish)
 		if polishConfidence > detectionThreshold {
 			fmt.Printf("Blocking likely Polish message: \"%s\" (confidence %f)\n", t.textOnly, polishConfidence)
@@ -211,12 +255,7 @@ func MainOnChatEntry(t ChatEntry) {
 	}
 
 	// Assign ID
-	id_bytes, err := os.ReadFile("chat_id.txt")
-	id_int := 0
-	if err == nil {
-		fmt.Sscanf(string(id_bytes), "%d", &id_int)
-	}
-	id_int += 1
+	id_int := lastChatID() + 1
 	t.ID = id_int
 	os.WriteFile("chat_id.txt", []byte(fmt.Sprintf("%d", id_int)), 0644)
 
@@ -233,13 +272,21 @@ func MainOnChatEntry(t ChatEntry) {
 	if err != nil {
 		warn_color.Println("Couldn't append to chat_log.txt:", err)
 	}
-	Webserver.Call("OnChatMessage", t)
+	Webserver.CallTopic(TopicChat, "OnChatMessage", t)
 	if t.ttsMsg != "" {
 		t.TryTTS()
 	}
 }
 
-var MainChannel = make(chan interface{})
+// Functions sent here run on the main thread, which owns the chat log, the user indexes and other chat state.
+var MainChannel = make(chan func())
+
+// onMainThread runs the function on the main thread and waits for its result.
+func onMainThread[T any](fn func() T) T {
+	result := make(chan T, 1)
+	MainChannel <- func() { result <- fn() }
+	return <-result
+}
 
 var Webserver *WebsocketHub
 
@@ -272,6 +319,12 @@ func NetworkSetup() {
 func main() {
 	var err error
 
+	// Subscribe before starting the publishers, so no event is missed
+	mainEvents := Events.Subscribe("main", Block, 64, "ChatMessage", "Follow", "Raid", "Redemption")
+	OBSRules.Subscribe()
+	StartWebhooks()
+	go EventTap()
+
 	newWebsocketClients := make(chan *WebsocketClient, 16)
 
 	Webserver = StartWebserver(newWebsocketClients)
@@ -289,6 +342,16 @@ func main() {
 
 	// YouTube Chat
 	go YouTubeBot()
+	LoadVADConfig()
+	LoadOBSRules()
+	LoadAnnounceConfig()
+	LoadStreamInfo()
+	LoadPointsConfig()
+	LoadMixerConfig()
+	LoadSoundboardConfig()
+	go StreamStatusPoller()
+	go Stats.Run()
+	go PointsTicker()
 	go AudioPlayer()
 	go OBS()
 
@@ -305,30 +368,50 @@ func main() {
 		warn_color.Println("Error while reading chat_log.txt:", err)
 	}
 
+	err = ScanSounds()
+	if err != nil {
+		warn_color.Println("Error while scanning sounds:", err)
+	}
+
 	err = LoadUsers()
 	if err != nil {
 		warn_color.Println("Error while loading users:", err)
 	}
 
+	LoadTimers()
+	go TimersTicker()
+
+	err = LoadGiveaways()
+	if err != nil {
+		warn_color.Println("Error while loading giveaways:", err)
+	}
+
 	for {
 		select {
 		case audioMessage := <-audioMessages:
 			lastAudioMessage = audioMessage
-			Webserver.Call("SetAudioMessage", audioMessage)
+			Webserver.CallTopic(TopicNowPlaying, "SetAudioMessage", audioMessage)
 		case client := <-newWebsocketClients:
-			client.Call("SetAudioMessage", lastAudioMessage)
-			client.Call("SetStreamTitle", twitchTitle)
+			client.CallTopic(TopicNowPlaying, "SetAudioMessage", lastAudioMessage)
+			client.CallTopic(TopicNowPlaying, "SetStreamTitle", twitchTitle)
+			if activePoll != nil {
+				client.CallTopic(TopicPolls, "PollUpdate", activePoll.View())
+			}
 			for _, entry := range chat_log {
-				client.Call("OnChatMessage", entry)
+				client.CallTopic(TopicChat, "OnChatMessage", entry)
 			}
-		case msg := <-MainChannel:
-			switch t := msg.(type) {
-			case ChatEntry:
-				MainOnChatEntry(t)
-			case func():
-				t()
-			default:
-				warn_color.Printf("Main thread received unknown message: %#v\n", msg)
+		case fn := <-MainChannel:
+			fn()
+		case event := <-mainEvents.C:
+			switch event := event.(type) {
+			case ChatMessage:
+				MainOnChatEntry(event.Entry)
+			case Follow:
+				AwardPoints(event.User, GetPointsConfig().FollowPoints)
+			case Raid:
+				AwardPoints(event.From, GetPointsConfig().RaidPoints)
+			case Redemption:
+				OnRedemption(event)
 			}
 		}
 	}
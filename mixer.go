@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+// Mixer buses. Every sound is played on one of them.
+const (
+	BusTTS    = "tts"
+	BusAlerts = "alerts"
+	BusSFX    = "sfx"
+)
+
+const (
+	// How long it takes to duck (or restore) the other buses.
+	duckDuration = 300 * time.Millisecond
+	// How long it takes to silence a skipped message or a muted author.
+	fadeOutDuration = 500 * time.Millisecond
+)
+
+const mixerConfigFile = "mixer.json"
+
+type MixerConfig struct {
+	// Volume of the ducked buses (and the music input) while TTS or an alert is speaking.
+	DuckVolume float64 `json:"duck_volume"`
+	// Name of the OBS input that plays music. It's ducked together with the mixer buses. Empty disables OBS ducking.
+	MusicDuckInput string `json:"music_duck_input,omitempty"`
+}
+
+var defaultMixerConfig = MixerConfig{
+	DuckVolume: 0.3,
+}
+
+// Volumes of the buses, changed from the admin panel.
+var mixerVolumesPath = path.Join(baseDir, "mixer_volumes.json")
+
+// AudioMixer combines sounds from all buses into a single stream that is played by the AudioPlayer.
+var AudioMixer = NewMixer()
+
+type mixerBus struct {
+	volume float32
+	// Whether sounds on this bus duck the other buses (TTS & alerts duck sound effects).
+	ducker bool
+	// Current ducking gain, smoothly moves towards 1 or MixerConfig.DuckVolume.
+	duck   float32
+	voices []*MixerVoice
+}
+
+// MixerVoice is a single sound playing on a bus.
+type MixerVoice struct {
+	samples []float32
+	pos     int
+	gain    float32
+	fade    float32 // gain change per sample (negative while fading out)
+	done    chan struct{}
+}
+
+// Done is closed when the sound finishes playing (or fades out).
+func (v *MixerVoice) Done() <-chan struct{} {
+	return v.done
+}
+
+type Mixer struct {
+	mu      sync.Mutex
+	config  MixerConfig
+	buses   map[string]*mixerBus
+	ducking bool
+	buf     []float32
+}
+
+func NewMixer() *Mixer {
+	return &Mixer{
+		config: defaultMixerConfig,
+		buses: map[string]*mixerBus{
+			BusTTS:    {volume: 1, ducker: true, duck: 1},
+			BusAlerts: {volume: 1, ducker: true, duck: 1},
+			BusSFX:    {volume: 1, duck: 1},
+		},
+	}
+}
+
+// LoadMixerConfig reads config/mixer.json and the bus volumes saved from the admin panel.
+func LoadMixerConfig() {
+	cfg := defaultMixerConfig
+	err := LoadConfig(mixerConfigFile, &cfg)
+	if err == nil && (cfg.DuckVolume < 0 || cfg.DuckVolume > 1) {
+		err = fmt.Errorf("duck volume %f out of range [0, 1]", cfg.DuckVolume)
+	}
+	if err != nil {
+		warn_color.Println("Using default mixer config:", err)
+		cfg = defaultMixerConfig
+	}
+	AudioMixer.mu.Lock()
+	AudioMixer.config = cfg
+	AudioMixer.mu.Unlock()
+	AudioMixer.loadVolumes()
+}
+
+// Play starts playing the sound on the given bus and returns immediately.
+func (m *Mixer) Play(bus string, wav *WAV) *MixerVoice {
+	voice := &MixerVoice{
+		samples: wav.Convert(playerSampleRate, playerChannels).Samples,
+		gain:    1,
+		done:    make(chan struct{}),
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.buses[bus]
+	if !ok {
+		audioPlayerColor.Println("Unknown mixer bus:", bus)
+		b = m.buses[BusSFX]
+	}
+	b.voices = append(b.voices, voice)
+	return voice
+}
+
+// FadeOut smoothly silences the voice. Its Done channel is closed once it's silent.
+func (m *Mixer) FadeOut(voice *MixerVoice, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	samples := max(1, int(duration.Seconds()*playerSampleRate))
+	voice.fade = -voice.gain / float32(samples)
+}
+
+// FadeOutBus fades out every sound on the bus.
+func (m *Mixer) FadeOutBus(bus string, duration time.Duration) {
+	m.mu.Lock()
+	b, ok := m.buses[bus]
+	var voices []*MixerVoice
+	if ok {
+		voices = append(voices, b.voices...)
+	}
+	m.mu.Unlock()
+	for _, voice := range voices {
+		m.FadeOut(voice, duration)
+	}
+}
+
+func checkVolume(volume float64) error {
+	if !(volume >= 0 && volume <= 2) { // also rejects NaN
+		return fmt.Errorf("volume %f out of range [0, 2]", volume)
+	}
+	return nil
+}
+
+func (m *Mixer) SetVolume(bus string, volume float64) error {
+	if err := checkVolume(volume); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	b, ok := m.buses[bus]
+	if !ok {
+		m.mu.Unlock()
+		return fmt.Errorf("unknown bus %q", bus)
+	}
+	b.volume = float32(volume)
+	m.mu.Unlock()
+	m.saveVolumes()
+	return nil
+}
+
+func (m *Mixer) Volumes() map[string]float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	volumes := map[string]float64{}
+	for name, b := range m.buses {
+		volumes[name] = float64(b.volume)
+	}
+	return volumes
+}
+
+func (m *Mixer) loadVolumes() {
+	var volumes map[string]float64
+	bytes, err := os.ReadFile(mixerVolumesPath)
+	if err != nil {
+		return // defaults are fine
+	}
+	if err := json.Unmarshal(bytes, &volumes); err != nil {
+		audioPlayerColor.Println("Couldn't parse mixer volumes:", err)
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for name, volume := range volumes {
+		b, ok := m.buses[name]
+		if !ok {
+			continue
+		}
+		if err := checkVolume(volume); err != nil {
+			audioPlayerColor.Printf("Ignoring the volume of the %s bus in %s: %v\n", name, mixerVolumesPath, err)
+			continue
+		}
+		b.volume = float32(volume)
+	}
+}
+
+func (m *Mixer) saveVolumes() {
+	bytes, err := json.MarshalIndent(m.Volumes(), "", "\t")
+	if err != nil {
+		audioPlayerColor.Println("Couldn't marshal mixer volumes:", err)
+		return
+	}
+	if err := WriteStringToFile(mixerVolumesPath, string(bytes)); err != nil {
+		audioPlayerColor.Println("Couldn't save mixer volumes:", err)
+	}
+}
+
+// Read implements io.Reader for the oto player. It never returns an error - when nothing is playing, it
+// produces silence.
+func (m *Mixer) Read(p []byte) (int, error) {
+	n := len(p) / 2
+	if cap(m.buf) < n {
+		m.buf = make([]float32, n)
+	}
+	out := m.buf[:n]
+	clear(out)
+
+	m.mu.Lock()
+	ducking := false
+	for _, b := range m.buses {
+		if b.ducker && len(b.voices) > 0 {
+			ducking = true
+		}
+	}
+	duckVolume := float32(m.config.DuckVolume)
+	duckStep := (1 - duckVolume) / float32(duckDuration.Seconds()*playerSampleRate)
+	for _, b := range m.buses {
+		duckTarget := float32(1)
+		if ducking && !b.ducker {
+			duckTarget = duckVolume
+		}
+		for i := range out {
+			if b.duck > duckTarget {
+				b.duck = max(duckTarget, b.duck-duckStep)
+			} else if b.duck < duckTarget {
+				b.duck = min(duckTarget, b.duck+duckStep)
+			}
+			for _, v := range b.voices {
+				if v.pos >= len(v.samples) || v.gain <= 0 {
+					continue
+				}
+				out[i] += v.samples[v.pos] * v.gain * b.volume * b.duck
+				v.pos++
+				if v.fade != 0 {
+					v.gain += v.fade
+				}
+			}
+		}
+		b.voices = finishVoices(b.voices)
+	}
+	duckingChanged := ducking != m.ducking
+	m.ducking = ducking
+	cfg := m.config
+	m.mu.Unlock()
+
+	if duckingChanged && cfg.MusicDuckInput != "" {
+		go OBSDuckInput(cfg.MusicDuckInput, ducking, cfg.DuckVolume)
+	}
+
+	for i, s := range out {
+		s = max(-1, min(1, s))
+		binary.LittleEndian.PutUint16(p[i*2:], uint16(int16(s*32767)))
+	}
+	return n * 2, nil
+}
+
+// finishVoices removes voices that finished playing and signals their completion.
+func finishVoices(voices []*MixerVoice) []*MixerVoice {
+	kept := voices[:0]
+	for _, v := range voices {
+		if v.pos >= len(v.samples) || v.gain <= 0 {
+			close(v.done)
+			continue
+		}
+		kept = append(kept, v)
+	}
+	clear(voices[len(kept):])
+	return kept
+}
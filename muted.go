@@ -16,12 +16,22 @@ func IsMuted(user User) bool {
 }
 
 func readMuted() *sync.Map {
-	muted := &sync.Map{}
-	path := path.Join(baseDir, "muted.txt")
+	return readUserSet("muted.txt")
+}
+
+func saveMuted(muted *sync.Map) {
+	saveUserSet("muted.txt", muted)
+}
+
+// readUserSet loads a set of users (one JSON object per line) from a file in baseDir.
+// The returned map is keyed by User.Key().
+func readUserSet(filename string) *sync.Map {
+	set := &sync.Map{}
+	path := path.Join(baseDir, filename)
 	file, err := os.Open(path)
 	if err != nil {
-		warn_color.Println("Couldn't open muted.txt:", err)
-		return muted
+		warn_color.Printf("Couldn't open %s: %s\n", filename, err)
+		return set
 	}
 	defer file.Close()
 	scanner := bufio.NewScanner(file)
@@ -29,29 +39,29 @@ func readMuted() *sync.Map {
 		var author User
 		err = json.Unmarshal([]byte(scanner.Text()), &author)
 		if err != nil {
-			warn_color.Println("Couldn't unmarshal muted user:", err)
+			warn_color.Printf("Couldn't unmarshal user from %s: %s\n", filename, err)
 			continue
 		}
-		muted.Store(author.Key(), author)
+		set.Store(author.Key(), author)
 	}
 	if err := scanner.Err(); err != nil {
-		warn_color.Println("Couldn't read muted.txt:", err)
+		warn_color.Printf("Couldn't read %s: %s\n", filename, err)
 	}
-	return muted
+	return set
 }
 
-func saveMuted(muted *sync.Map) {
-	path := path.Join(baseDir, "muted.txt")
+func saveUserSet(filename string, set *sync.Map) {
+	path := path.Join(baseDir, filename)
 	file, err := os.Create(path)
 	if err != nil {
-		warn_color.Println("Couldn't create muted.txt:", err)
+		warn_color.Printf("Couldn't create %s: %s\n", filename, err)
 		return
 	}
 	defer file.Close()
-	muted.Range(func(key, value interface{}) bool {
+	set.Range(func(key, value interface{}) bool {
 		bytes, err := json.Marshal(value)
 		if err != nil {
-			warn_color.Println("Couldn't marshal muted user:", err)
+			warn_color.Printf("Couldn't marshal user for %s: %s\n", filename, err)
 			return true
 		}
 		file.WriteString(string(bytes) + "\n")
@@ -63,40 +73,35 @@ const BOT_ICON = `<img src="bot.svg" class="emoji">`
 const MUTED_ICON = `<img src="muted.svg" class="emoji">`
 const UNMUTED_ICON = `<img src="unmuted.svg" class="emoji">`
 
-func ToggleMuted(c *WebsocketClient, args ...json.RawMessage) {
-	if !c.admin {
-		return
-	}
-	if len(args) != 1 {
-		warn_color.Println("ToggleMuted: wrong number of arguments:", args)
-		return
-	}
-	var user User
-	err := json.Unmarshal(args[0], &user)
-	if err != nil {
-		warn_color.Println("ToggleMuted: couldn't unmarshal user:", err)
-		return
-	}
+func ToggleMuted(c *WebsocketClient, user User) {
+	SetMuted(user, !IsMuted(user))
+}
 
+// SetMuted mutes or unmutes the TTS of the user.
+func SetMuted(user User, mute bool) {
 	key := user.Key()
 	username := user.DisplayName()
-	_, is_muted := muted.Load(key)
-	if is_muted {
+	if IsMuted(user) == mute {
+		return
+	}
+	if !mute {
 		chat_color.Println("Unmuting", username)
 		muted.Delete(key)
-		MainChannel <- ChatEntry{
+		Events.Publish(ModerationAction{Action: ModerationUnmute, Target: user})
+		Events.Publish(ChatMessage{Entry: ChatEntry{
 			Author: user,
 			HTML:   BOT_ICON + ` ` + UNMUTED_ICON + ` ` + user.HTML(),
-		}
+		}})
 	} else {
 		chat_color.Println("Muting", username)
 		user.BotUser = nil
 		muted.Store(key, user)
+		Events.Publish(ModerationAction{Action: ModerationMute, Target: user})
 		user.BotUser = &BotUser{}
-		MainChannel <- ChatEntry{
+		Events.Publish(ChatMessage{Entry: ChatEntry{
 			Author: user,
 			HTML:   BOT_ICON + ` ` + MUTED_ICON + ` ` + user.HTML(),
-		}
+		}})
 	}
 	saveMuted(muted)
 }
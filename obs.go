@@ -1,9 +1,7 @@
 package main
 
 import (
-	"math"
 	"net/http"
-	"os/exec"
 	"path"
 	"streambot/backoff"
 	"sync/atomic"
@@ -12,13 +10,13 @@ import (
 	"github.com/andreykaipov/goobs"
 	"github.com/andreykaipov/goobs/api/events"
 	"github.com/andreykaipov/goobs/api/events/subscriptions"
+	"github.com/andreykaipov/goobs/api/requests/inputs"
 	"github.com/andreykaipov/goobs/api/requests/scenes"
 	"github.com/andreykaipov/goobs/api/requests/ui"
 	"github.com/fatih/color"
-	"github.com/mitchellh/go-ps"
 )
 
-var OBSChannel = make(chan any)
+var OBSChannel = make(chan func(*goobs.Client) error)
 
 var MicIsSilent atomic.Bool
 var OBSScene atomic.Value
@@ -46,6 +44,40 @@ func OBSSwitchScene(targetScene string) error {
 	return <-errChan
 }
 
+// Original volumes of inputs that are currently ducked. Only accessed from the OBS thread.
+var obsDuckedVolumes = map[string]float64{}
+
+// OBSDuckInput lowers the volume of an OBS input by the given gain (or restores the original volume).
+// Gives up after a second if OBS isn't connected.
+func OBSDuckInput(inputName string, duck bool, gain float64) {
+	fn := func(obs *goobs.Client) error {
+		original, ducked := obsDuckedVolumes[inputName]
+		if duck {
+			if ducked {
+				return nil
+			}
+			resp, err := obs.Inputs.GetInputVolume(&inputs.GetInputVolumeParams{InputName: &inputName})
+			if err != nil {
+				return err
+			}
+			obsDuckedVolumes[inputName] = resp.InputVolumeMul
+			volume := resp.InputVolumeMul * gain
+			_, err = obs.Inputs.SetInputVolume(&inputs.SetInputVolumeParams{InputName: &inputName, InputVolumeMul: &volume})
+			return err
+		}
+		if !ducked {
+			return nil
+		}
+		delete(obsDuckedVolumes, inputName)
+		_, err := obs.Inputs.SetInputVolume(&inputs.SetInputVolumeParams{InputName: &inputName, InputVolumeMul: &original})
+		return err
+	}
+	select {
+	case OBSChannel <- fn:
+	case <-time.After(time.Second):
+	}
+}
+
 // Returns -1 if not found
 func FindMonitorIndex(obs *goobs.Client, displayName string) (int, error) {
 	monitorList, err := obs.Ui.GetMonitorList()
@@ -130,6 +162,16 @@ func OBS() {
 		Color:       col,
 		Description: "OBS",
 	}
+	cfg, err := LoadOBSConfig()
+	if err != nil {
+		col.Println("Using default OBS config:", err)
+		cfg = &defaultOBSConfig
+	}
+	launcher, err := NewOBSLauncher(cfg)
+	if err != nil {
+		col.Println("OBS won't be started automatically:", err)
+		launcher = noLauncher{}
+	}
 	openPreviews := false
 	for {
 		backoff.Attempt()
@@ -140,45 +182,34 @@ func OBS() {
 			continue
 		}
 
-		processes, err := ps.Processes()
+		running, err := launcher.Running()
 		if err != nil {
-			col.Println("Couldn't list processes:", err)
+			col.Println("Couldn't check whether OBS is running:", err)
 			// try to continue
-		} else {
-			found := false
-			for _, process := range processes {
-				if process.Executable() == "obs64.exe" {
-					found = true
-					break
-				}
+		} else if !running {
+			err := launcher.Start()
+			if err != nil {
+				col.Println("Couldn't start OBS:", err)
+				continue
 			}
-			if !found {
-				cmd := exec.Command("C:\\Program Files\\obs-studio\\bin\\64bit\\obs64.exe")
-				cmd.Dir = "C:\\Program Files\\obs-studio\\bin\\64bit"
-				err := cmd.Start()
+			openPreviews = true
+			col.Println("Starting OBS...")
+			// wait up to 30 seconds for OBS to start
+			for i := 0; i < 30; i++ {
+				probe, err := goobs.New(cfg.Address, goobs.WithPassword(obsPassword))
 				if err != nil {
-					col.Println("Couldn't start OBS:", err)
+					time.Sleep(time.Second)
 					continue
 				}
-				openPreviews = true
-				col.Println("Starting OBS...")
-				// wait up to 30 seconds for OBS to start
-				for i := 0; i < 30; i++ {
-					probe, err := goobs.New("localhost:4455", goobs.WithPassword(obsPassword))
-					if err != nil {
-						time.Sleep(time.Second)
-						continue
-					}
-					probe.Disconnect()
-					break
-				}
+				probe.Disconnect()
+				break
 			}
 		}
 
-		obs, err := goobs.New("localhost:4455",
+		obs, err := goobs.New(cfg.Address,
 			goobs.WithPassword(obsPassword),
 			goobs.WithRequestHeader(http.Header{"User-Agent": []string{"streambot/1.0"}}),
-			goobs.WithEventSubscriptions(subscriptions.InputVolumeMeters|subscriptions.Scenes),
+			goobs.WithEventSubscriptions(subscriptions.InputVolumeMeters|subscriptions.Scenes|subscriptions.Outputs|subscriptions.Inputs|subscriptions.SceneItems),
 		)
 		if err != nil {
 			col.Println("Couldn't connect to OBS:", err)
@@ -195,56 +226,57 @@ func OBS() {
 
 		if openPreviews {
 			openPreviews = false
-			OpenPreview(obs, "VDD Mirror", []string{`\\.\DISPLAY1`, `MPI7002`})
-			OpenPreview(obs, "Camera Clean", []string{"VDD by MTT"})
+			for _, projector := range cfg.Projectors {
+				OpenPreview(obs, projector.Scene, projector.Monitors)
+			}
 		}
 
 		backoff.Success()
+		streamStatus, err := obs.Stream.GetStreamStatus()
+		if err == nil {
+			Stream.SetOnline(StreamSourceOBS, streamStatus.OutputActive)
+		}
+		PushOBSState(obs)
 
-		lastMicActivity := time.Now()
+		var vad VoiceActivityDetector
 		connected := true
 		for connected {
 			select {
-			case msg := <-OBSChannel:
-				switch t := msg.(type) {
-				case func(*goobs.Client) error:
-					err = t(obs)
-					if err != nil {
-						break
-					}
-				default:
-					col.Println("Unknown message:", t)
-				}
+			case fn := <-OBSChannel:
+				err = fn(obs)
 			case obsEvent := <-obs.IncomingEvents:
 				if obsEvent == nil {
 					col.Println("OBS disconnected")
 					connected = false
+					Webserver.CallAdmins("SetOBSState", &OBSState{})
 					break
 				}
 				switch t := obsEvent.(type) {
 				case *events.InputVolumeMeters:
-					for _, input := range t.Inputs {
-						if input.Name != "Mic/Aux" {
-							continue
-						}
-						if len(input.Levels) == 0 {
-							continue
-						}
-						magnitude := 0.0
-						for _, levels := range input.Levels {
-							magnitude = max(magnitude, levels[0])
-						}
-						magnitudeDb := 20 * math.Log10(magnitude)
-						if magnitudeDb > -35 {
-							lastMicActivity = time.Now()
-							MicIsSilent.Store(false)
-						} else if time.Since(lastMicActivity) > 3*time.Second {
-							MicIsSilent.Store(true)
-						}
-					}
+					vad.OnVolumeMeters(t)
 				case *events.CurrentProgramSceneChanged:
 					col.Println("Scene changed to", t.SceneName)
 					OBSScene.Store(&t.SceneName)
+					PushOBSState(obs)
+					Events.Publish(SceneChanged{Scene: t.SceneName})
+				case *events.SceneListChanged, *events.SceneItemEnableStateChanged, *events.SceneItemCreated,
+					*events.SceneItemRemoved, *events.InputMuteStateChanged, *events.InputCreated,
+					*events.InputRemoved, *events.InputNameChanged, *events.RecordStateChanged:
+					PushOBSState(obs)
+				case *events.InputVolumeChanged, *events.InputSettingsChanged, *events.SceneItemListReindexed,
+					*events.SceneItemSelected, *events.InputAudioBalanceChanged, *events.InputAudioSyncOffsetChanged,
+					*events.InputAudioTracksChanged, *events.InputAudioMonitorTypeChanged:
+					// not shown in the admin panel
+				case *events.StreamStateChanged:
+					switch t.OutputState {
+					case "OBS_WEBSOCKET_OUTPUT_STARTED":
+						col.Println("Stream started")
+						Stream.SetOnline(StreamSourceOBS, true)
+					case "OBS_WEBSOCKET_OUTPUT_STOPPED":
+						col.Println("Stream stopped")
+						Stream.SetOnline(StreamSourceOBS, false)
+					}
+					PushOBSState(obs)
 				default:
 					col.Printf("Unknown OBS event: %#v\n", t)
 				}
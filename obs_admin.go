@@ -0,0 +1,205 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/andreykaipov/goobs"
+	"github.com/andreykaipov/goobs/api/requests/inputs"
+	"github.com/andreykaipov/goobs/api/requests/sceneitems"
+	"github.com/andreykaipov/goobs/api/requests/scenes"
+)
+
+// OBS remote control for the admin panel. Admins receive the full OBS state (as "SetOBSState") whenever it changes.
+
+type OBSSourceState struct {
+	ID      int    `json:"id"`
+	Name    string `json:"name"`
+	Visible bool   `json:"visible"`
+}
+
+type OBSInputState struct {
+	Name  string `json:"name"`
+	Muted bool   `json:"muted"`
+}
+
+type OBSState struct {
+	Connected    bool     `json:"connected"`
+	Scenes       []string `json:"scenes"`
+	CurrentScene string   `json:"current_scene"`
+	// Sources of the current scene
+	Sources []OBSSourceState `json:"sources"`
+	// Inputs with audio
+	Inputs    []OBSInputState `json:"inputs"`
+	Streaming bool            `json:"streaming"`
+	Recording bool            `json:"recording"`
+}
+
+func QueryOBSState(obs *goobs.Client) (*OBSState, error) {
+	state := &OBSState{Connected: true}
+	sceneList, err := obs.Scenes.GetSceneList()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get scene list: %w", err)
+	}
+	state.CurrentScene = sceneList.CurrentProgramSceneName
+	// OBS lists scenes bottom to top
+	for i := len(sceneList.Scenes) - 1; i >= 0; i-- {
+		state.Scenes = append(state.Scenes, sceneList.Scenes[i].SceneName)
+	}
+	items, err := obs.SceneItems.GetSceneItemList(&sceneitems.GetSceneItemListParams{SceneName: &state.CurrentScene})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get scene items: %w", err)
+	}
+	for i := len(items.SceneItems) - 1; i >= 0; i-- {
+		item := items.SceneItems[i]
+		state.Sources = append(state.Sources, OBSSourceState{ID: item.SceneItemID, Name: item.SourceName, Visible: item.SceneItemEnabled})
+	}
+	inputList, err := obs.Inputs.GetInputList()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get input list: %w", err)
+	}
+	for _, input := range inputList.Inputs {
+		mute, err := obs.Inputs.GetInputMute(&inputs.GetInputMuteParams{InputName: &input.InputName})
+		if err != nil {
+			continue // inputs without audio can't be muted
+		}
+		state.Inputs = append(state.Inputs, OBSInputState{Name: input.InputName, Muted: mute.InputMuted})
+	}
+	streamStatus, err := obs.Stream.GetStreamStatus()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get stream status: %w", err)
+	}
+	state.Streaming = streamStatus.OutputActive
+	recordStatus, err := obs.Record.GetRecordStatus()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get record status: %w", err)
+	}
+	state.Recording = recordStatus.OutputActive
+	return state, nil
+}
+
+// PushOBSState sends the current OBS state to all admins. Run this only on the OBS thread!
+func PushOBSState(obs *goobs.Client) {
+	state, err := QueryOBSState(obs)
+	if err != nil {
+		warn_color.Println("Couldn't query OBS state:", err)
+		return
+	}
+	Webserver.CallAdmins("SetOBSState", state)
+}
+
+// callOBS runs the function on the OBS thread. Gives up if OBS doesn't pick it up within a few seconds.
+// Errors are logged and returned.
+func callOBS(name string, fn func(obs *goobs.Client) error) error {
+	errChan := make(chan error, 1)
+	select {
+	case OBSChannel <- func(obs *goobs.Client) error {
+		err := fn(obs)
+		errChan <- err
+		return err
+	}:
+	case <-time.After(5 * time.Second):
+		warn_color.Println(name + ": OBS is not connected")
+		return errOBSNotConnected
+	}
+	err := <-errChan
+	if err != nil {
+		warn_color.Println(name+":", err)
+	}
+	return err
+}
+
+var errOBSNotConnected = errors.New("OBS is not connected")
+
+// GetOBSState returns the current state of OBS. Returns a disconnected state when OBS isn't available.
+func GetOBSState() *OBSState {
+	state, _ := TryGetOBSState()
+	if state == nil {
+		state = &OBSState{}
+	}
+	return state
+}
+
+// TryGetOBSState returns the current state of OBS or errOBSNotConnected when OBS isn't available.
+func TryGetOBSState() (*OBSState, error) {
+	var state *OBSState
+	err := callOBS("GetOBSState", func(obs *goobs.Client) (err error) {
+		state, err = QueryOBSState(obs)
+		return err
+	})
+	return state, err
+}
+
+func OBSSetScene(scene string) error {
+	return callOBS("OBSSwitchScene", func(obs *goobs.Client) error {
+		_, err := obs.Scenes.SetCurrentProgramScene(&scenes.SetCurrentProgramSceneParams{SceneName: &scene})
+		return err
+	})
+}
+
+func OBSSetSourceVisible(scene string, id int, visible bool) error {
+	return callOBS("OBSSetSourceVisible", func(obs *goobs.Client) error {
+		_, err := obs.SceneItems.SetSceneItemEnabled(&sceneitems.SetSceneItemEnabledParams{
+			SceneName:        &scene,
+			SceneItemId:      &id,
+			SceneItemEnabled: &visible,
+		})
+		return err
+	})
+}
+
+func OBSSetInputMute(input string, muted bool) error {
+	return callOBS("OBSSetInputMute", func(obs *goobs.Client) error {
+		_, err := obs.Inputs.SetInputMute(&inputs.SetInputMuteParams{InputName: &input, InputMuted: &muted})
+		return err
+	})
+}
+
+func OBSSetStreaming(active bool) error {
+	return callOBS("OBSSetStreaming", func(obs *goobs.Client) (err error) {
+		if active {
+			_, err = obs.Stream.StartStream()
+		} else {
+			_, err = obs.Stream.StopStream()
+		}
+		return err
+	})
+}
+
+func OBSSetRecording(active bool) error {
+	return callOBS("OBSSetRecording", func(obs *goobs.Client) (err error) {
+		if active {
+			_, err = obs.Record.StartRecord()
+		} else {
+			_, err = obs.Record.StopRecord()
+		}
+		return err
+	})
+}
+
+// Websocket handlers
+
+func GetOBSStateHandler(c *WebsocketClient) *OBSState {
+	return GetOBSState()
+}
+
+func OBSSwitchSceneHandler(c *WebsocketClient, scene string) error {
+	return OBSSetScene(scene)
+}
+
+func OBSSetSourceVisibleHandler(c *WebsocketClient, scene string, id int, visible bool) error {
+	return OBSSetSourceVisible(scene, id, visible)
+}
+
+func OBSSetInputMuteHandler(c *WebsocketClient, input string, muted bool) error {
+	return OBSSetInputMute(input, muted)
+}
+
+func OBSSetStreamingHandler(c *WebsocketClient, active bool) error {
+	return OBSSetStreaming(active)
+}
+
+func OBSSetRecordingHandler(c *WebsocketClient, active bool) error {
+	return OBSSetRecording(active)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+
+	"github.com/mitchellh/go-ps"
+)
+
+// OBS connection, startup and projector layout. Configured in config/obs.json, for example:
+//
+//	{
+//	  "launcher": "flatpak",
+//	  "projectors": [{"scene": "Camera Clean", "monitors": ["HDMI-1"]}]
+//	}
+
+const obsConfigFile = "obs.json"
+
+type OBSConfig struct {
+	// Address of the obs-websocket server.
+	Address string `json:"address"`
+	// How to start OBS when it isn't running: "auto", "native", "flatpak" or "none".
+	Launcher string `json:"launcher"`
+	// Path to the OBS binary. Empty uses the default location for the current OS.
+	Executable string `json:"executable,omitempty"`
+	// Projectors opened after the bot starts OBS.
+	Projectors []OBSProjectorConfig `json:"projectors"`
+}
+
+type OBSProjectorConfig struct {
+	Scene string `json:"scene"`
+	// Monitor name prefixes, in order of preference. The first connected one is used.
+	Monitors []string `json:"monitors"`
+}
+
+var defaultOBSConfig = OBSConfig{
+	Address:  "localhost:4455",
+	Launcher: "auto",
+	Projectors: []OBSProjectorConfig{
+		{Scene: "VDD Mirror", Monitors: []string{`\\.\DISPLAY1`, `MPI7002`}},
+		{Scene: "Camera Clean", Monitors: []string{"VDD by MTT"}},
+	},
+}
+
+func LoadOBSConfig() (*OBSConfig, error) {
+	cfg := defaultOBSConfig
+	if err := LoadConfig(obsConfigFile, &cfg); err != nil {
+		return nil, err
+	}
+	switch cfg.Launcher {
+	case "auto", "native", "flatpak", "none":
+	default:
+		return nil, fmt.Errorf("unknown OBS launcher %q", cfg.Launcher)
+	}
+	for _, projector := range cfg.Projectors {
+		if len(projector.Monitors) == 0 {
+			return nil, fmt.Errorf("projector for %q has no monitors", projector.Scene)
+		}
+	}
+	return &cfg, nil
+}
+
+// OBSLauncher starts OBS if it isn't running yet.
+type OBSLauncher interface {
+	Running() (bool, error)
+	Start() error
+}
+
+// processLauncher runs the OBS binary directly.
+type processLauncher struct {
+	executable string
+	// Name of the OBS process, as reported by the OS.
+	processName string
+}
+
+func (l processLauncher) Running() (bool, error) {
+	return processRunning(l.processName)
+}
+
+func (l processLauncher) Start() error {
+	cmd := exec.Command(l.executable)
+	// OBS looks for its data files relative to the working directory
+	cmd.Dir = filepath.Dir(l.executable)
+	return cmd.Start()
+}
+
+// flatpakLauncher runs OBS installed from Flathub.
+type flatpakLauncher struct{}
+
+const obsFlatpakID = "com.obsproject.Studio"
+
+func (flatpakLauncher) Running() (bool, error) {
+	// Processes inside the flatpak sandbox are visible on the host under their own name
+	return processRunning("obs")
+}
+
+func (flatpakLauncher) Start() error {
+	return exec.Command("flatpak", "run", obsFlatpakID).Start()
+}
+
+// noLauncher is used when OBS is started by someone else.
+type noLauncher struct{}
+
+func (noLauncher) Running() (bool, error) { return true, nil }
+func (noLauncher) Start() error           { return nil }
+
+func processRunning(name string) (bool, error) {
+	processes, err := ps.Processes()
+	if err != nil {
+		return false, fmt.Errorf("couldn't list processes: %w", err)
+	}
+	for _, process := range processes {
+		if process.Executable() == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func NewOBSLauncher(cfg *OBSConfig) (OBSLauncher, error) {
+	switch cfg.Launcher {
+	case "none":
+		return noLauncher{}, nil
+	case "flatpak":
+		return flatpakLauncher{}, nil
+	}
+	native := processLauncher{executable: cfg.Executable}
+	switch runtime.GOOS {
+	case "windows":
+		native.processName = "obs64.exe"
+		if native.executable == "" {
+			native.executable = `C:\Program Files\obs-studio\bin\64bit\obs64.exe`
+		}
+	case "linux":
+		native.processName = "obs"
+		if native.executable == "" {
+			path, err := exec.LookPath("obs")
+			if err != nil {
+				if _, flatpakErr := exec.LookPath("flatpak"); cfg.Launcher == "auto" && flatpakErr == nil {
+					return flatpakLauncher{}, nil
+				}
+				return nil, fmt.Errorf("couldn't find OBS: %w", err)
+			}
+			native.executable = path
+		}
+	case "darwin":
+		native.processName = "OBS"
+		if native.executable == "" {
+			native.executable = "/Applications/OBS.app/Contents/MacOS/OBS"
+		}
+	default:
+		if native.executable == "" {
+			return nil, fmt.Errorf("no default OBS location on %s", runtime.GOOS)
+		}
+		native.processName = filepath.Base(native.executable)
+	}
+	return native, nil
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andreykaipov/goobs"
+	"github.com/andreykaipov/goobs/api/requests/filters"
+	"github.com/andreykaipov/goobs/api/requests/inputs"
+	"github.com/andreykaipov/goobs/api/requests/mediainputs"
+	"github.com/andreykaipov/goobs/api/requests/sceneitems"
+	"github.com/andreykaipov/goobs/api/requests/scenes"
+	"github.com/fatih/color"
+)
+
+// OBS automation rules. Bot events trigger OBS actions. Rules are declared in config/obs_rules.json, for example:
+//
+//	[
+//	  {"event": "raid", "actions": [
+//	    {"type": "switch_scene", "scene": "Raid"},
+//	    {"type": "set_text", "input": "Raider", "text": "Welcome {user} & {viewers} raiders!"},
+//	    {"type": "wait", "millis": 15000},
+//	    {"type": "switch_scene", "scene": "Main"}
+//	  ]},
+//	  {"event": "command", "match": "hydrate", "roles": ["owner", "moderator", "vip"], "actions": [{"type": "media", "input": "Water", "media_action": "restart"}]},
+//	  {"event": "mic_silent", "minutes": 10, "actions": [{"type": "switch_scene", "scene": "BRB"}]}
+//	]
+
+const obsRulesConfigFile = "obs_rules.json"
+
+// Event kinds that can trigger OBS rules.
+const (
+	OBSEventFollow      = "follow"
+	OBSEventRaid        = "raid"
+	OBSEventRedemption  = "redemption" // Match is the reward title
+	OBSEventCommand     = "command"    // Match is the command name (without "!")
+	OBSEventMicSilent   = "mic_silent" // fires once per silence period, after Minutes
+	OBSEventStreamStart = "stream_start"
+	OBSEventStreamStop  = "stream_stop"
+)
+
+type OBSRule struct {
+	Event   string  `json:"event"`
+	Match   string  `json:"match,omitempty"`
+	Minutes float64 `json:"minutes,omitempty"`
+	// Used by command: roles (see chat_roles.go) that may trigger the rule. Only moderators & the owner when empty.
+	Roles   []string    `json:"roles,omitempty"`
+	Actions []OBSAction `json:"actions"`
+}
+
+type OBSAction struct {
+	// One of: switch_scene, set_visible, set_filter, media, set_text, wait
+	Type   string `json:"type"`
+	Scene  string `json:"scene,omitempty"`
+	Source string `json:"source,omitempty"`
+	Filter string `json:"filter,omitempty"`
+	Input  string `json:"input,omitempty"`
+	// Used by set_visible & set_filter
+	Enabled bool `json:"enabled,omitempty"`
+	// Used by media: play, pause, stop, restart, next, previous
+	MediaAction string `json:"media_action,omitempty"`
+	// Used by set_text. Supports {user}, {viewers}, {message} and {reward} placeholders.
+	Text string `json:"text,omitempty"`
+	// Used by wait
+	Millis int `json:"millis,omitempty"`
+}
+
+type OBSRuleEvent struct {
+	Kind    string
+	Match   string
+	User    string
+	Viewers int
+	Message string
+	// The chat message of a command.
+	Chat *ChatEntry
+}
+
+// OBSRuleClient is the subset of OBS functionality used by the rules. It allows the rules to run against a fake
+// client instead of a real OBS connection.
+type OBSRuleClient interface {
+	SwitchScene(scene string) error
+	SetSourceVisible(scene, source string, visible bool) error
+	SetFilterEnabled(source, filter string, enabled bool) error
+	TriggerMedia(input, action string) error
+	SetText(input, text string) error
+}
+
+type goobsRuleClient struct {
+	obs *goobs.Client
+}
+
+func (c goobsRuleClient) SwitchScene(scene string) error {
+	_, err := c.obs.Scenes.SetCurrentProgramScene(&scenes.SetCurrentProgramSceneParams{SceneName: &scene})
+	return err
+}
+
+func (c goobsRuleClient) SetSourceVisible(scene, source string, visible bool) error {
+	idResp, err := c.obs.SceneItems.GetSceneItemId(&sceneitems.GetSceneItemIdParams{
+		SceneName:  &scene,
+		SourceName: &source,
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't find %s in %s: %w", source, scene, err)
+	}
+	_, err = c.obs.SceneItems.SetSceneItemEnabled(&sceneitems.SetSceneItemEnabledParams{
+		SceneName:        &scene,
+		SceneItemId:      &idResp.SceneItemId,
+		SceneItemEnabled: &visible,
+	})
+	return err
+}
+
+func (c goobsRuleClient) SetFilterEnabled(source, filter string, enabled bool) error {
+	_, err := c.obs.Filters.SetSourceFilterEnabled(&filters.SetSourceFilterEnabledParams{
+		SourceName:    &source,
+		FilterName:    &filter,
+		FilterEnabled: &enabled,
+	})
+	return err
+}
+
+var obsMediaActions = map[string]string{
+	"play":     "OBS_WEBSOCKET_MEDIA_INPUT_ACTION_PLAY",
+	"pause":    "OBS_WEBSOCKET_MEDIA_INPUT_ACTION_PAUSE",
+	"stop":     "OBS_WEBSOCKET_MEDIA_INPUT_ACTION_STOP",
+	"restart":  "OBS_WEBSOCKET_MEDIA_INPUT_ACTION_RESTART",
+	"next":     "OBS_WEBSOCKET_MEDIA_INPUT_ACTION_NEXT",
+	"previous": "OBS_WEBSOCKET_MEDIA_INPUT_ACTION_PREVIOUS",
+}
+
+func (c goobsRuleClient) TriggerMedia(input, action string) error {
+	obsAction, ok := obsMediaActions[action]
+	if !ok {
+		return fmt.Errorf("unknown media action %q", action)
+	}
+	_, err := c.obs.MediaInputs.TriggerMediaInputAction(&mediainputs.TriggerMediaInputActionParams{
+		InputName:   &input,
+		MediaAction: &obsAction,
+	})
+	return err
+}
+
+func (c goobsRuleClient) SetText(input, text string) error {
+	overlay := true
+	_, err := c.obs.Inputs.SetInputSettings(&inputs.SetInputSettingsParams{
+		InputName:     &input,
+		InputSettings: map[string]any{"text": text},
+		Overlay:       &overlay,
+	})
+	return err
+}
+
+// Execute performs a single action. Wait actions are handled by the caller.
+func (a OBSAction) Execute(client OBSRuleClient, event OBSRuleEvent) error {
+	switch a.Type {
+	case "switch_scene":
+		return client.SwitchScene(a.Scene)
+	case "set_visible":
+		return client.SetSourceVisible(a.Scene, a.Source, a.Enabled)
+	case "set_filter":
+		return client.SetFilterEnabled(a.Source, a.Filter, a.Enabled)
+	case "media":
+		return client.TriggerMedia(a.Input, a.MediaAction)
+	case "set_text":
+		return client.SetText(a.Input, event.Expand(a.Text))
+	}
+	return fmt.Errorf("unknown action type %q", a.Type)
+}
+
+// Expand replaces placeholders in the text with the event details.
+func (e OBSRuleEvent) Expand(text string) string {
+	return strings.NewReplacer(
+		"{user}", e.User,
+		"{viewers}", strconv.Itoa(e.Viewers),
+		"{message}", e.Message,
+		"{reward}", e.Match,
+	).Replace(text)
+}
+
+// Matches checks whether the rule should fire for the given (non-mic_silent) event.
+func (r OBSRule) Matches(event OBSRuleEvent) bool {
+	if r.Event != event.Kind {
+		return false
+	}
+	if r.Event == OBSEventCommand {
+		roles := r.Roles
+		if len(roles) == 0 {
+			roles = []string{RoleOwner, RoleModerator}
+		}
+		if event.Chat == nil || !event.Chat.HasRole(roles...) {
+			return false
+		}
+	}
+	return r.Match == "" || strings.EqualFold(r.Match, event.Match)
+}
+
+type OBSRuleEngine struct {
+	mu    sync.Mutex
+	rules []OBSRule
+	// Indices of mic_silent rules that already fired during the current silence.
+	silenceFired map[int]bool
+	// Executes a single action. Replaceable for testing.
+	execute func(OBSAction, OBSRuleEvent) error
+}
+
+var obsRulesColor = color.New(color.FgHiYellow)
+
+var OBSRules = &OBSRuleEngine{
+	silenceFired: map[int]bool{},
+	execute:      executeOnOBSThread,
+}
+
+func LoadOBSRules() {
+	var rules []OBSRule
+	err := LoadConfig(obsRulesConfigFile, &rules)
+	if err != nil {
+		obsRulesColor.Println("Couldn't load OBS rules:", err)
+		return
+	}
+	OBSRules.SetRules(rules)
+	if len(rules) > 0 {
+		obsRulesColor.Println("Loaded", len(rules), "OBS rules")
+	}
+}
+
+func (e *OBSRuleEngine) SetRules(rules []OBSRule) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.rules = rules
+	e.silenceFired = map[int]bool{}
+}
+
+// Fire runs all rules that match the event. Returns immediately - the actions run in the background.
+func (e *OBSRuleEngine) Fire(event OBSRuleEvent) {
+	e.mu.Lock()
+	var matched []OBSRule
+	for _, rule := range e.rules {
+		if rule.Matches(event) {
+			matched = append(matched, rule)
+		}
+	}
+	e.mu.Unlock()
+	for _, rule := range matched {
+		go e.Run(rule, event)
+	}
+}
+
+// Subscribe fires the rules for events from the bus. Rules run in the background and the subscription drops the
+// oldest events when it falls behind, so a slow OBS never holds up the publishers.
+func (e *OBSRuleEngine) Subscribe() {
+	sub := Events.Subscribe("obs_rules", DropOldest, 32, "ChatMessage", "Follow", "Raid", "Redemption", "StreamStateChanged")
+	go func() {
+		for event := range sub.C {
+			switch event := event.(type) {
+			case ChatMessage:
+				entry := event.Entry
+				if entry.Author.BotUser != nil || IsMuted(entry.Author) {
+					break
+				}
+				command, found := strings.CutPrefix(entry.OriginalMessage, "!")
+				if !found {
+					break
+				}
+				name, message, _ := strings.Cut(command, " ")
+				if name == "login" { // the message is a secret ticket
+					break
+				}
+				e.Fire(OBSRuleEvent{Kind: OBSEventCommand, Match: name, User: entry.Author.DisplayName(), Message: message, Chat: &entry})
+			case Follow:
+				e.Fire(OBSRuleEvent{Kind: OBSEventFollow, User: event.User.DisplayName()})
+			case Raid:
+				e.Fire(OBSRuleEvent{Kind: OBSEventRaid, User: event.From.DisplayName(), Viewers: event.Viewers})
+			case Redemption:
+				e.Fire(OBSRuleEvent{Kind: OBSEventRedemption, Match: event.Reward, User: event.User.DisplayName(), Message: event.Input})
+			case StreamStateChanged:
+				if event.Source != StreamSourceOBS {
+					break
+				}
+				if event.Online {
+					e.Fire(OBSRuleEvent{Kind: OBSEventStreamStart})
+				} else {
+					e.Fire(OBSRuleEvent{Kind: OBSEventStreamStop})
+				}
+			}
+		}
+	}()
+}
+
+// OnMicSilence should be called regularly with the time since the mic became silent (0 while speaking).
+func (e *OBSRuleEngine) OnMicSilence(silentFor time.Duration) {
+	e.mu.Lock()
+	if silentFor == 0 {
+		clear(e.silenceFired)
+		e.mu.Unlock()
+		return
+	}
+	var matched []OBSRule
+	for i, rule := range e.rules {
+		if rule.Event != OBSEventMicSilent || e.silenceFired[i] {
+			continue
+		}
+		if silentFor >= time.Duration(rule.Minutes*float64(time.Minute)) {
+			e.silenceFired[i] = true
+			matched = append(matched, rule)
+		}
+	}
+	e.mu.Unlock()
+	for _, rule := range matched {
+		go e.Run(rule, OBSRuleEvent{Kind: OBSEventMicSilent})
+	}
+}
+
+// Run executes the actions of a rule in order. It blocks for the duration of wait actions.
+func (e *OBSRuleEngine) Run(rule OBSRule, event OBSRuleEvent) {
+	for _, action := range rule.Actions {
+		if action.Type == "wait" {
+			time.Sleep(time.Duration(action.Millis) * time.Millisecond)
+			continue
+		}
+		err := e.execute(action, event)
+		if err != nil {
+			obsRulesColor.Printf("OBS rule for %s: %s failed: %s\n", rule.Event, action.Type, err)
+			return
+		}
+	}
+}
+
+func executeOnOBSThread(action OBSAction, event OBSRuleEvent) error {
+	errChan := make(chan error, 1)
+	select {
+	case OBSChannel <- func(obs *goobs.Client) error {
+		err := action.Execute(goobsRuleClient{obs}, event)
+		errChan <- err
+		return err
+	}:
+	case <-time.After(10 * time.Second):
+		return fmt.Errorf("OBS is not connected")
+	}
+	return <-errChan
+}
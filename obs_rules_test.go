@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeOBS records the calls made by the rules.
+type fakeOBS struct {
+	mu    sync.Mutex
+	calls []string
+	times []time.Time
+	// Receives every call, for tests that wait for rules running in the background.
+	called chan string
+}
+
+func newFakeOBS() *fakeOBS {
+	return &fakeOBS{called: make(chan string, 16)}
+}
+
+func (f *fakeOBS) record(format string, args ...any) error {
+	call := fmt.Sprintf(format, args...)
+	f.mu.Lock()
+	f.calls = append(f.calls, call)
+	f.times = append(f.times, time.Now())
+	f.mu.Unlock()
+	f.called <- call
+	return nil
+}
+
+func (f *fakeOBS) SwitchScene(scene string) error {
+	return f.record("switch_scene %s", scene)
+}
+
+func (f *fakeOBS) SetSourceVisible(scene, source string, visible bool) error {
+	return f.record("set_visible %s/%s %v", scene, source, visible)
+}
+
+func (f *fakeOBS) SetFilterEnabled(source, filter string, enabled bool) error {
+	return f.record("set_filter %s/%s %v", source, filter, enabled)
+}
+
+func (f *fakeOBS) TriggerMedia(input, action string) error {
+	return f.record("media %s %s", input, action)
+}
+
+func (f *fakeOBS) SetText(input, text string) error {
+	return f.record("set_text %s %s", input, text)
+}
+
+func (f *fakeOBS) Calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.calls)
+}
+
+// wait returns the next call made in the background.
+func (f *fakeOBS) wait(t *testing.T) string {
+	t.Helper()
+	select {
+	case call := <-f.called:
+		return call
+	case <-time.After(time.Second):
+		t.Fatal("no OBS call")
+		return ""
+	}
+}
+
+func newTestRuleEngine(client OBSRuleClient, rules ...OBSRule) *OBSRuleEngine {
+	e := &OBSRuleEngine{
+		silenceFired: map[int]bool{},
+		execute: func(action OBSAction, event OBSRuleEvent) error {
+			return action.Execute(client, event)
+		},
+	}
+	e.SetRules(rules)
+	return e
+}
+
+func TestOBSRuleActions(t *testing.T) {
+	fake := newFakeOBS()
+	e := newTestRuleEngine(fake)
+	rule := OBSRule{Event: OBSEventRaid, Actions: []OBSAction{
+		{Type: "switch_scene", Scene: "Raid"},
+		{Type: "set_visible", Scene: "Raid", Source: "Confetti", Enabled: true},
+		{Type: "set_filter", Source: "Camera", Filter: "Blur"},
+		{Type: "media", Input: "Horn", MediaAction: "restart"},
+		{Type: "set_text", Input: "Raider", Text: "Welcome {user} & {viewers} raiders!"},
+	}}
+	e.Run(rule, OBSRuleEvent{Kind: OBSEventRaid, User: "Alice", Viewers: 42})
+	want := []string{
+		"switch_scene Raid",
+		"set_visible Raid/Confetti true",
+		"set_filter Camera/Blur false",
+		"media Horn restart",
+		"set_text Raider Welcome Alice & 42 raiders!",
+	}
+	if got := fake.Calls(); !slices.Equal(got, want) {
+		t.Errorf("calls = %q, want %q", got, want)
+	}
+}
+
+func TestOBSRuleStopsOnError(t *testing.T) {
+	fake := newFakeOBS()
+	e := newTestRuleEngine(fake)
+	e.Run(OBSRule{Event: OBSEventFollow, Actions: []OBSAction{
+		{Type: "media", Input: "Horn", MediaAction: "restart"},
+		{Type: "explode"},
+		{Type: "switch_scene", Scene: "Main"},
+	}}, OBSRuleEvent{Kind: OBSEventFollow})
+	if got, want := fake.Calls(), []string{"media Horn restart"}; !slices.Equal(got, want) {
+		t.Errorf("calls = %q, want %q", got, want)
+	}
+}
+
+func TestOBSRuleWaitOrdering(t *testing.T) {
+	fake := newFakeOBS()
+	e := newTestRuleEngine(fake)
+	const wait = 50 * time.Millisecond
+	e.Run(OBSRule{Event: OBSEventRaid, Actions: []OBSAction{
+		{Type: "switch_scene", Scene: "Raid"},
+		{Type: "wait", Millis: int(wait / time.Millisecond)},
+		{Type: "switch_scene", Scene: "Main"},
+	}}, OBSRuleEvent{Kind: OBSEventRaid})
+	if got, want := fake.Calls(), []string{"switch_scene Raid", "switch_scene Main"}; !slices.Equal(got, want) {
+		t.Fatalf("calls = %q, want %q", got, want)
+	}
+	if gap := fake.times[1].Sub(fake.times[0]); gap < wait {
+		t.Errorf("second action ran %v after the first, want at least %v", gap, wait)
+	}
+}
+
+func TestOBSRuleFireMatches(t *testing.T) {
+	fake := newFakeOBS()
+	e := newTestRuleEngine(fake,
+		OBSRule{Event: OBSEventRedemption, Match: "Hydrate", Actions: []OBSAction{{Type: "media", Input: "Water", MediaAction: "play"}}},
+		OBSRule{Event: OBSEventRedemption, Match: "Other", Actions: []OBSAction{{Type: "switch_scene", Scene: "Other"}}},
+	)
+	e.Fire(OBSRuleEvent{Kind: OBSEventRedemption, Match: "hydrate"})
+	if got := fake.wait(t); got != "media Water play" {
+		t.Errorf("call = %q", got)
+	}
+	select {
+	case call := <-fake.called:
+		t.Errorf("unexpected call %q", call)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestOBSRuleMicSilentFromStart(t *testing.T) {
+	fake := newFakeOBS()
+	e := newTestRuleEngine(fake,
+		OBSRule{Event: OBSEventMicSilent, Minutes: 10, Actions: []OBSAction{{Type: "switch_scene", Scene: "BRB"}}},
+	)
+	cfg := &VADConfig{Inputs: []string{"Mic/Aux"}, OpenThresholdDb: -35, CloseThresholdDb: -40, HangoverMillis: 3000}
+	// The streamer hasn't said anything since the bot started
+	var vad VoiceActivityDetector
+	start := time.Now()
+	for _, elapsed := range []time.Duration{0, 5 * time.Minute, 11 * time.Minute, 12 * time.Minute} {
+		e.OnMicSilence(vad.update(cfg, -60, start.Add(elapsed)))
+	}
+	if got := fake.wait(t); got != "switch_scene BRB" {
+		t.Errorf("call = %q", got)
+	}
+	// Fires once per silence period
+	select {
+	case call := <-fake.called:
+		t.Errorf("unexpected call %q", call)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Speaking resets the silence. It starts again after the hangover.
+	e.OnMicSilence(vad.update(cfg, -10, start.Add(13*time.Minute)))
+	e.OnMicSilence(vad.update(cfg, -60, start.Add(13*time.Minute+time.Second)))
+	e.OnMicSilence(vad.update(cfg, -60, start.Add(14*time.Minute)))
+	e.OnMicSilence(vad.update(cfg, -60, start.Add(20*time.Minute)))
+	select {
+	case call := <-fake.called:
+		t.Errorf("fired %q before the new silence reached 10 minutes", call)
+	case <-time.After(50 * time.Millisecond):
+	}
+	e.OnMicSilence(vad.update(cfg, -60, start.Add(25*time.Minute)))
+	if got := fake.wait(t); got != "switch_scene BRB" {
+		t.Errorf("call = %q", got)
+	}
+}
+
+func TestOBSRuleCommandRoles(t *testing.T) {
+	command := func(roles ...string) OBSRuleEvent {
+		return OBSRuleEvent{Kind: OBSEventCommand, Match: "hydrate", Chat: &ChatEntry{Roles: roles}}
+	}
+	defaultRoles := OBSRule{Event: OBSEventCommand, Match: "hydrate"}
+	vips := OBSRule{Event: OBSEventCommand, Match: "hydrate", Roles: []string{RoleVIP}}
+	tests := []struct {
+		name  string
+		rule  OBSRule
+		event OBSRuleEvent
+		want  bool
+	}{
+		{"moderator by default", defaultRoles, command(RoleModerator), true},
+		{"owner by default", defaultRoles, command(RoleOwner), true},
+		{"viewer by default", defaultRoles, command(), false},
+		{"subscriber by default", defaultRoles, command(RoleSubscriber), false},
+		{"listed role", vips, command(RoleSubscriber, RoleVIP), true},
+		{"unlisted role", vips, command(RoleModerator), false},
+		{"command without a message", defaultRoles, OBSRuleEvent{Kind: OBSEventCommand, Match: "hydrate"}, false},
+	}
+	for _, test := range tests {
+		if got := test.rule.Matches(test.event); got != test.want {
+			t.Errorf("%s: Matches = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
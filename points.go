@@ -0,0 +1,304 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/nicklaw5/helix/v2"
+)
+
+// Loyalty points, shared by all platforms. Viewers earn points for watching (Twitch chatters list, or recent chat
+// activity on YouTube & Discord), chatting, following and raiding. Points are kept in the user records (users.json &
+// user_records.json), so accounts linked with !login share them. Configured in config/points.json.
+
+const pointsConfigFile = "points.json"
+
+type PointsConfig struct {
+	// Name of the points, used in chat messages.
+	Name                 string `json:"name"`
+	WatchIntervalMinutes int    `json:"watch_interval_min"`
+	// Points for every watch interval.
+	WatchPoints int `json:"watch_points"`
+	// YouTube & Discord have no list of viewers - viewers who chatted recently are counted instead.
+	ActiveChatterMinutes   int `json:"active_chatter_min"`
+	MessagePoints          int `json:"message_points"`
+	MessageCooldownSeconds int `json:"message_cooldown_s"`
+	FollowPoints           int `json:"follow_points"`
+	RaidPoints             int `json:"raid_points"`
+	LeaderboardSize        int `json:"leaderboard_size"`
+}
+
+var defaultPointsConfig = PointsConfig{
+	Name:                   "points",
+	WatchIntervalMinutes:   5,
+	WatchPoints:            10,
+	ActiveChatterMinutes:   10,
+	MessagePoints:          2,
+	MessageCooldownSeconds: 60,
+	FollowPoints:           100,
+	RaidPoints:             250,
+	LeaderboardSize:        50,
+}
+
+var pointsConfig atomic.Pointer[PointsConfig]
+
+func LoadPointsConfig() {
+	cfg := defaultPointsConfig
+	if err := LoadConfig(pointsConfigFile, &cfg); err != nil {
+		warn_color.Println("Using default points config:", err)
+		cfg = defaultPointsConfig
+	}
+	pointsConfig.Store(&cfg)
+}
+
+func GetPointsConfig() *PointsConfig {
+	if cfg := pointsConfig.Load(); cfg != nil {
+		return cfg
+	}
+	return &defaultPointsConfig
+}
+
+// Only accessed from the main thread.
+var (
+	// Last rewarded message of each user record.
+	pointsLastMessage = map[*User]time.Time{}
+	// Last message of each user record, for the watch time on YouTube & Discord.
+	pointsLastSeen = map[*User]time.Time{}
+	pointsDirty    bool
+)
+
+// AddPoints changes the points of the user. Points never go below zero. Run this only on the main thread!
+func AddPoints(record *User, points int) {
+	if points == 0 {
+		return
+	}
+	record.Points = max(0, record.Points+points)
+	pointsDirty = true
+}
+
+// AwardPoints gives points to the author of an event (follow, raid...). Run this only on the main thread!
+func AwardPoints(author User, points int) {
+	if author.BotUser != nil {
+		return
+	}
+	AddPoints(author.Record(), points)
+}
+
+// mergePoints moves the points of an account that was linked to another user record.
+func mergePoints(to, from *User) {
+	if to == from {
+		return
+	}
+	to.Points += from.Points
+	from.Points = 0
+}
+
+// savePoints saves the users if any points changed since the last save. Run this only on the main thread!
+func savePoints() {
+	if !pointsDirty {
+		return
+	}
+	pointsDirty = false
+	if err := SaveUsers(); err != nil {
+		warn_color.Println("Couldn't save points:", err)
+	}
+}
+
+// OnChatPoints rewards chat activity. Run this only on the main thread!
+func OnChatPoints(t ChatEntry) {
+	if t.Author.BotUser != nil || t.HasRole(RoleOwner) {
+		return
+	}
+	cfg := GetPointsConfig()
+	record := t.Author.Record()
+	now := time.Now()
+	pointsLastSeen[record] = now
+	if now.Sub(pointsLastMessage[record]) < time.Duration(cfg.MessageCooldownSeconds)*time.Second {
+		return
+	}
+	pointsLastMessage[record] = now
+	AddPoints(record, cfg.MessagePoints)
+}
+
+// OnPointsCommand answers `!points` on the platform of the author. Returns true if the message was a command.
+func OnPointsCommand(t ChatEntry) bool {
+	if strings.TrimSpace(t.OriginalMessage) != "!points" {
+		return false
+	}
+	if t.Author.BotUser != nil {
+		return true
+	}
+	record := t.Author.Record()
+	cfg := GetPointsConfig()
+	message := fmt.Sprintf("@%s has %d %s", t.Author.DisplayName(), record.Points, cfg.Name)
+	if rank := PointsRank(record); rank > 0 {
+		message += fmt.Sprintf(" (#%d)", rank)
+	}
+	switch {
+	case t.Author.TwitchUser != nil:
+		SendChat(message, PlatformTwitch)
+	case t.Author.YouTubeUser != nil:
+		SendChat(message, PlatformYouTube)
+	case t.Author.DiscordUser != nil:
+		SendChat(message, PlatformDiscord)
+	}
+	return true
+}
+
+type LeaderboardEntry struct {
+	// Platform key of the user record, used for admin adjustments.
+	Key    string `json:"key"`
+	Name   string `json:"name"`
+	Points int    `json:"points"`
+}
+
+type LeaderboardView struct {
+	// Name of the points
+	Name    string             `json:"name"`
+	Entries []LeaderboardEntry `json:"entries"`
+}
+
+// Leaderboard returns the users with the most points. Run this only on the main thread!
+func Leaderboard(n int) []LeaderboardEntry {
+	var entries []LeaderboardEntry
+	for user := range AllUsers() {
+		if user.Points > 0 && user.Key() != "" {
+			entries = append(entries, LeaderboardEntry{Key: user.Key(), Name: user.DisplayName(), Points: user.Points})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Points != entries[j].Points {
+			return entries[i].Points > entries[j].Points
+		}
+		return entries[i].Name < entries[j].Name
+	})
+	if len(entries) > n {
+		entries = entries[:n]
+	}
+	return entries
+}
+
+// PointsRank returns the 1-based position of the user on the leaderboard, or 0 without points.
+// Run this only on the main thread!
+func PointsRank(record *User) int {
+	if record.Points <= 0 {
+		return 0
+	}
+	rank := 1
+	for user := range AllUsers() {
+		if user.Points > record.Points {
+			rank++
+		}
+	}
+	return rank
+}
+
+// PointsTicker rewards watch time while the stream is live and periodically saves the points.
+func PointsTicker() {
+	for {
+		interval := time.Duration(GetPointsConfig().WatchIntervalMinutes) * time.Minute
+		if interval <= 0 {
+			interval = time.Duration(defaultPointsConfig.WatchIntervalMinutes) * time.Minute
+		}
+		time.Sleep(interval)
+		if !Stream.IsLive() {
+			MainChannel <- savePoints
+			continue
+		}
+		chatters := getTwitchChatters()
+		MainChannel <- func() {
+			awardWatchTime(chatters)
+			savePoints()
+		}
+	}
+}
+
+// getTwitchChatters returns the users connected to the Twitch chat. Requires the moderator:read:chatters scope.
+func getTwitchChatters() []helix.ChatChatter {
+	result := make(chan []helix.ChatChatter, 1)
+	TwitchHelixChannel <- func(client *helix.Client) {
+		var chatters []helix.ChatChatter
+		after := ""
+		for {
+			resp, err := client.GetChannelChatChatters(&helix.GetChatChattersParams{
+				BroadcasterID: twitchBroadcasterID,
+				ModeratorID:   twitchBotID,
+				First:         "1000",
+				After:         after,
+			})
+			if err == nil && resp.ErrorMessage != "" {
+				err = fmt.Errorf("%s", resp.ErrorMessage)
+			}
+			if err != nil {
+				twitchColor.Println("Couldn't get chatters:", err)
+				break
+			}
+			chatters = append(chatters, resp.Data.Chatters...)
+			after = resp.Data.Pagination.Cursor
+			if after == "" {
+				break
+			}
+		}
+		result <- chatters
+	}
+	return <-result
+}
+
+// awardWatchTime gives watch points to the Twitch chatters and recently active viewers. Each user record is
+// rewarded once, even if several of its accounts are present. Run this only on the main thread!
+func awardWatchTime(chatters []helix.ChatChatter) {
+	cfg := GetPointsConfig()
+	rewarded := map[*User]bool{}
+	for _, chatter := range chatters {
+		if chatter.UserID == twitchBroadcasterID || chatter.UserID == twitchBotID {
+			continue
+		}
+		author := User{TwitchUser: &TwitchUser{TwitchID: chatter.UserID, Login: chatter.UserLogin, Name: chatter.Username}}
+		rewarded[author.Record()] = true
+	}
+	cutoff := time.Now().Add(-time.Duration(cfg.ActiveChatterMinutes) * time.Minute)
+	for record, lastSeen := range pointsLastSeen {
+		if lastSeen.Before(cutoff) {
+			delete(pointsLastSeen, record)
+			delete(pointsLastMessage, record)
+			continue
+		}
+		rewarded[record] = true
+	}
+	for record := range rewarded {
+		AddPoints(record, cfg.WatchPoints)
+	}
+}
+
+// Websocket handlers
+
+// leaderboardView returns the leaderboard page. Run this only on the main thread!
+func leaderboardView() LeaderboardView {
+	cfg := GetPointsConfig()
+	return LeaderboardView{Name: cfg.Name, Entries: Leaderboard(cfg.LeaderboardSize)}
+}
+
+func GetLeaderboardHandler(c *WebsocketClient) LeaderboardView {
+	return onMainThread(leaderboardView)
+}
+
+// AdjustPointsHandler adds the given (possibly negative) number of points to a user, identified by a platform key.
+// Returns the updated leaderboard.
+func AdjustPointsHandler(c *WebsocketClient, key string, delta int) (LeaderboardView, error) {
+	var err error
+	view := onMainThread(func() LeaderboardView {
+		record := FindUserByKey(key)
+		if record == nil {
+			err = fmt.Errorf("unknown user %q", key)
+			return LeaderboardView{}
+		}
+		AddPoints(record, delta)
+		fmt.Printf("Adjusted points of %s by %d (now %d)\n", record.DisplayName(), delta, record.Points)
+		savePoints()
+		return leaderboardView()
+	})
+	return view, err
+}
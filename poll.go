@@ -0,0 +1,366 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nicklaw5/helix/v2"
+)
+
+// Chat polls. Viewers on every platform vote with `!vote N`. Accounts linked with !login get a single vote.
+// Polls can be mirrored as a native Twitch poll or prediction - Twitch poll votes are added to the results when
+// the poll ends. Twitch only reports the totals of its poll, so the `!vote`s of Twitch accounts are then replaced by
+// the native votes - otherwise viewers who voted both ways would be counted twice.
+
+const (
+	pollMinOptions = 2
+	pollMaxOptions = 10
+	// Limits of Twitch polls & predictions
+	twitchPollMaxOptions       = 5
+	twitchPollMaxTitle         = 60
+	twitchPredictionMaxTitle   = 45
+	twitchChoiceMaxTitle       = 25
+	twitchPollMinDuration      = 15 * time.Second
+	twitchPollMaxDuration      = 30 * time.Minute
+	twitchPredictionMaxOptions = 10
+)
+
+// Ways of mirroring a poll on Twitch.
+const (
+	PollMirrorNone       = ""
+	PollMirrorPoll       = "poll"
+	PollMirrorPrediction = "prediction"
+)
+
+type Poll struct {
+	Question string
+	Options  []string
+	EndsAt   time.Time
+	Closed   bool
+	Mirror   string
+	// Linked user key -> option index
+	votes map[string]int
+	// Linked user keys of the voters with a Twitch account. Their votes are counted by the native Twitch poll.
+	twitchVoters map[string]bool
+	// Votes from the native Twitch poll, added when it ends.
+	twitchVotes []int
+	// IDs of the Twitch poll / prediction and its outcomes
+	twitchID         string
+	twitchOutcomeIDs []string
+	timer            *time.Timer
+}
+
+type PollOptionView struct {
+	Title string `json:"title"`
+	Votes int    `json:"votes"`
+}
+
+// PollView is sent to the overlay & chat pages.
+type PollView struct {
+	Question string           `json:"question"`
+	Options  []PollOptionView `json:"options"`
+	EndsAt   time.Time        `json:"ends_at"`
+	Closed   bool             `json:"closed"`
+}
+
+// Only accessed from the main thread.
+var activePoll *Poll
+
+func (p *Poll) Tally() []int {
+	counts := make([]int, len(p.Options))
+	for key, option := range p.votes {
+		if p.twitchVotes != nil && p.twitchVoters[key] {
+			continue
+		}
+		counts[option]++
+	}
+	for i, n := range p.twitchVotes {
+		if i < len(counts) {
+			counts[i] += n
+		}
+	}
+	return counts
+}
+
+func (p *Poll) View() PollView {
+	view := PollView{Question: p.Question, EndsAt: p.EndsAt, Closed: p.Closed}
+	for i, count := range p.Tally() {
+		view.Options = append(view.Options, PollOptionView{Title: p.Options[i], Votes: count})
+	}
+	return view
+}
+
+// Winners returns the indices of the options with the most votes.
+func (p *Poll) Winners() []int {
+	var winners []int
+	best := 0
+	for i, count := range p.Tally() {
+		if count > best {
+			best = count
+			winners = []int{i}
+		} else if count == best && count > 0 {
+			winners = append(winners, i)
+		}
+	}
+	return winners
+}
+
+func validatePoll(question string, options []string, duration time.Duration, mirror string) error {
+	if strings.TrimSpace(question) == "" {
+		return fmt.Errorf("empty question")
+	}
+	if len(options) < pollMinOptions || len(options) > pollMaxOptions {
+		return fmt.Errorf("polls need %d to %d options, got %d", pollMinOptions, pollMaxOptions, len(options))
+	}
+	for _, option := range options {
+		if strings.TrimSpace(option) == "" {
+			return fmt.Errorf("empty option")
+		}
+	}
+	if duration <= 0 {
+		return fmt.Errorf("duration must be positive")
+	}
+	switch mirror {
+	case PollMirrorNone:
+		return nil
+	case PollMirrorPoll:
+		if len(options) > twitchPollMaxOptions || len([]rune(question)) > twitchPollMaxTitle {
+			return fmt.Errorf("Twitch polls allow %d options and %d characters", twitchPollMaxOptions, twitchPollMaxTitle)
+		}
+	case PollMirrorPrediction:
+		if len(options) > twitchPredictionMaxOptions || len([]rune(question)) > twitchPredictionMaxTitle {
+			return fmt.Errorf("Twitch predictions allow %d options and %d characters", twitchPredictionMaxOptions, twitchPredictionMaxTitle)
+		}
+	default:
+		return fmt.Errorf("unknown mirror %q", mirror)
+	}
+	for _, option := range options {
+		if len([]rune(option)) > twitchChoiceMaxTitle {
+			return fmt.Errorf("Twitch choices are limited to %d characters: %q", twitchChoiceMaxTitle, option)
+		}
+	}
+	if duration < twitchPollMinDuration || duration > twitchPollMaxDuration {
+		return fmt.Errorf("Twitch polls must last between %s and %s", twitchPollMinDuration, twitchPollMaxDuration)
+	}
+	return nil
+}
+
+// StartPoll replaces the active poll. Run this only on the main thread!
+func StartPoll(question string, options []string, duration time.Duration, mirror string) error {
+	if err := validatePoll(question, options, duration, mirror); err != nil {
+		return err
+	}
+	if activePoll != nil && !activePoll.Closed {
+		EndPoll()
+	}
+	poll := &Poll{
+		Question:     question,
+		Options:      options,
+		EndsAt:       time.Now().Add(duration),
+		Mirror:       mirror,
+		votes:        map[string]int{},
+		twitchVoters: map[string]bool{},
+	}
+	poll.timer = time.AfterFunc(duration, func() {
+		MainChannel <- func() {
+			if activePoll == poll && !poll.Closed {
+				EndPoll()
+			}
+		}
+	})
+	activePoll = poll
+
+	var choices strings.Builder
+	for i, option := range options {
+		fmt.Fprintf(&choices, " %d) %s", i+1, option)
+	}
+	if mirror == PollMirrorPoll {
+		choices.WriteString(" (Twitch: vote in the poll above)")
+	}
+	SendChat(fmt.Sprintf("📊 Poll: %s Vote with !vote NUMBER:%s", question, choices.String()))
+	Webserver.CallTopic(TopicPolls, "PollUpdate", poll.View())
+
+	switch mirror {
+	case PollMirrorPoll:
+		TwitchHelixChannel <- func(client *helix.Client) {
+			params := &helix.CreatePollParams{
+				BroadcasterID: twitchBroadcasterID,
+				Title:         question,
+				Duration:      int(duration.Seconds()),
+			}
+			for _, option := range options {
+				params.Choices = append(params.Choices, helix.PollChoiceParam{Title: option})
+			}
+			resp, err := client.CreatePoll(params)
+			if err == nil && resp.ErrorMessage != "" {
+				err = fmt.Errorf("%s", resp.ErrorMessage)
+			}
+			if err != nil || len(resp.Data.Polls) == 0 {
+				twitchColor.Println("Couldn't create Twitch poll:", err)
+				return
+			}
+			id := resp.Data.Polls[0].ID
+			go func() { MainChannel <- func() { poll.twitchID = id } }()
+		}
+	case PollMirrorPrediction:
+		TwitchHelixChannel <- func(client *helix.Client) {
+			params := &helix.CreatePredictionParams{
+				BroadcasterID:    twitchBroadcasterID,
+				Title:            question,
+				PredictionWindow: int(duration.Seconds()),
+			}
+			for _, option := range options {
+				params.Outcomes = append(params.Outcomes, helix.PredictionChoiceParam{Title: option})
+			}
+			resp, err := client.CreatePrediction(params)
+			if err == nil && resp.ErrorMessage != "" {
+				err = fmt.Errorf("%s", resp.ErrorMessage)
+			}
+			if err != nil || len(resp.Data.Predictions) == 0 {
+				twitchColor.Println("Couldn't create Twitch prediction:", err)
+				return
+			}
+			prediction := resp.Data.Predictions[0]
+			var outcomeIDs []string
+			for _, outcome := range prediction.Outcomes {
+				outcomeIDs = append(outcomeIDs, outcome.ID)
+			}
+			go func() {
+				MainChannel <- func() {
+					poll.twitchID = prediction.ID
+					poll.twitchOutcomeIDs = outcomeIDs
+				}
+			}()
+		}
+	}
+	return nil
+}
+
+// EndPoll closes the active poll and announces the results. Run this only on the main thread!
+func EndPoll() {
+	poll := activePoll
+	if poll == nil || poll.Closed {
+		return
+	}
+	poll.Closed = true
+	poll.timer.Stop()
+	if poll.Mirror == PollMirrorPoll && poll.twitchID != "" {
+		// Twitch votes are merged before announcing the results
+		id := poll.twitchID
+		TwitchHelixChannel <- func(client *helix.Client) {
+			resp, err := client.EndPoll(&helix.EndPollParams{BroadcasterID: twitchBroadcasterID, ID: id, Status: "TERMINATED"})
+			if err != nil || len(resp.Data.Polls) == 0 {
+				// Polls that ended on their own can't be terminated - fetch their results instead
+				resp, err = client.GetPolls(&helix.PollsParams{BroadcasterID: twitchBroadcasterID, ID: id})
+			}
+			var votes []int
+			if err != nil || len(resp.Data.Polls) == 0 {
+				twitchColor.Println("Couldn't get Twitch poll results:", err)
+			} else {
+				for _, choice := range resp.Data.Polls[0].Choices {
+					votes = append(votes, choice.Votes)
+				}
+			}
+			go func() {
+				MainChannel <- func() {
+					poll.twitchVotes = votes
+					announcePollResults(poll)
+				}
+			}()
+		}
+		return
+	}
+	if poll.Mirror == PollMirrorPrediction && poll.twitchID != "" {
+		// The prediction is resolved by the admin with ResolvePrediction
+		id := poll.twitchID
+		TwitchHelixChannel <- func(client *helix.Client) {
+			_, err := client.EndPrediction(&helix.EndPredictionParams{BroadcasterID: twitchBroadcasterID, ID: id, Status: "LOCKED"})
+			if err != nil {
+				twitchColor.Println("Couldn't lock Twitch prediction:", err)
+			}
+		}
+	}
+	announcePollResults(poll)
+}
+
+func announcePollResults(poll *Poll) {
+	Webserver.CallTopic(TopicPolls, "PollUpdate", poll.View())
+	winners := poll.Winners()
+	if len(winners) == 0 {
+		SendChat(fmt.Sprintf("📊 Poll \"%s\" ended without votes", poll.Question))
+		return
+	}
+	var names []string
+	for _, i := range winners {
+		names = append(names, poll.Options[i])
+	}
+	tally := poll.Tally()
+	message := fmt.Sprintf("📊 Poll \"%s\" ended. Winner: %s (%d votes)", poll.Question, strings.Join(names, " & "), tally[winners[0]])
+	chat_color.Println(message)
+	SendChat(message)
+}
+
+// OnVoteCommand handles `!vote N` chat messages. Returns true if the message was a vote.
+func OnVoteCommand(t ChatEntry) bool {
+	arg, found := strings.CutPrefix(t.OriginalMessage, "!vote ")
+	if !found {
+		return false
+	}
+	poll := activePoll
+	if poll == nil || poll.Closed {
+		return true
+	}
+	option, err := strconv.Atoi(strings.TrimSpace(arg))
+	if err != nil || option < 1 || option > len(poll.Options) {
+		return true
+	}
+	settings := t.Author.LoadSettings()
+	poll.votes[settings.Key()] = option - 1
+	if settings.TwitchUser != nil {
+		poll.twitchVoters[settings.Key()] = true
+	}
+	Webserver.CallTopic(TopicPolls, "PollUpdate", poll.View())
+	return true
+}
+
+// Websocket handlers
+
+func StartPollHandler(c *WebsocketClient, question string, options []string, durationSeconds int, mirror string) error {
+	return onMainThread(func() error {
+		return StartPoll(question, options, time.Duration(durationSeconds)*time.Second, mirror)
+	})
+}
+
+func EndPollHandler(c *WebsocketClient) {
+	MainChannel <- EndPoll
+}
+
+// ResolvePredictionHandler pays out the mirrored Twitch prediction to the given option (0-based).
+func ResolvePredictionHandler(c *WebsocketClient, option int) error {
+	var id, outcomeID string
+	err := onMainThread(func() error {
+		poll := activePoll
+		if poll == nil || poll.Mirror != PollMirrorPrediction || option < 0 || option >= len(poll.twitchOutcomeIDs) {
+			return fmt.Errorf("no prediction or invalid option %d", option)
+		}
+		id, outcomeID = poll.twitchID, poll.twitchOutcomeIDs[option]
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	TwitchHelixChannel <- func(client *helix.Client) {
+		_, err := client.EndPrediction(&helix.EndPredictionParams{
+			BroadcasterID:    twitchBroadcasterID,
+			ID:               id,
+			Status:           "RESOLVED",
+			WinningOutcomeID: outcomeID,
+		})
+		if err != nil {
+			twitchColor.Println("Couldn't resolve Twitch prediction:", err)
+		}
+	}
+	return nil
+}
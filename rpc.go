@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// Websocket RPC. Pages call the methods from JavaScriptHandlers by sending
+//
+//	{"id": 7, "call": "OBSSwitchScene", "args": ["Main"]}
+//
+// and receive {"id": 7, "result": ...} or {"id": 7, "error": {"code": "...", "message": "..."}}. Calls without an
+// id are fire-and-forget - their errors are only logged. The bot calls functions on the pages with the same
+// {"call", "args"} message, without an id.
+//
+// Methods are plain Go functions - func(c *WebsocketClient, <typed arguments>) returning nothing, a result, an error
+// or (result, error). Arguments are decoded with encoding/json and checked with their Validate method (if they have
+// one). The JS client (static/rpc.js) is generated from JavaScriptHandlers at startup.
+
+// Error codes of RPCError.
+const (
+	RPCUnknownMethod = "unknown_method"
+	RPCForbidden     = "forbidden"
+	// Wrong number or type of arguments.
+	RPCBadArguments = "bad_arguments"
+	// Arguments rejected by their Validate method.
+	RPCInvalidArguments = "invalid_arguments"
+	// The method returned an error.
+	RPCFailed = "failed"
+	// The method panicked.
+	RPCInternal = "internal"
+)
+
+const rpcClientPath = "static/rpc.js"
+
+type RPCError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+func rpcErrorf(code string, format string, args ...any) *RPCError {
+	return &RPCError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+type RPCAccess int
+
+const (
+	RPCPublic RPCAccess = iota
+	// Requires a password (see the "Password" method).
+	RPCUser
+	RPCAdmin
+)
+
+type RPCMethod struct {
+	Access RPCAccess
+	// Names of the arguments, used by the generated JS client.
+	ArgNames []string
+	fn       reflect.Value
+	argTypes []reflect.Type
+	// Type of the result, nil if the method doesn't return one.
+	resultType reflect.Type
+	returnsErr bool
+}
+
+var (
+	rpcClientType = reflect.TypeFor[*WebsocketClient]()
+	rpcErrorType  = reflect.TypeFor[error]()
+)
+
+type rpcValidator interface {
+	Validate() error
+}
+
+// newRPCMethod checks the signature of the function. Panics on mistakes, so they're caught at startup.
+func newRPCMethod(access RPCAccess, fn any, argNames []string) *RPCMethod {
+	t := reflect.TypeOf(fn)
+	if t.Kind() != reflect.Func || t.NumIn() == 0 || t.In(0) != rpcClientType || t.IsVariadic() {
+		panic(fmt.Sprintf("RPC method must be func(*WebsocketClient, ...), got %s", t))
+	}
+	m := &RPCMethod{Access: access, ArgNames: argNames, fn: reflect.ValueOf(fn)}
+	for i := 1; i < t.NumIn(); i++ {
+		m.argTypes = append(m.argTypes, t.In(i))
+	}
+	if len(argNames) != len(m.argTypes) {
+		panic(fmt.Sprintf("RPC method %s has %d arguments but %d names", t, len(m.argTypes), len(argNames)))
+	}
+	switch {
+	case t.NumOut() == 0:
+	case t.NumOut() == 1 && t.Out(0) == rpcErrorType:
+		m.returnsErr = true
+	case t.NumOut() == 1:
+		m.resultType = t.Out(0)
+	case t.NumOut() == 2 && t.Out(1) == rpcErrorType:
+		m.resultType = t.Out(0)
+		m.returnsErr = true
+	default:
+		panic(fmt.Sprintf("RPC method must return nothing, a result, an error or (result, error), got %s", t))
+	}
+	return m
+}
+
+// PublicRPC registers a method that anybody can call.
+func PublicRPC(fn any, argNames ...string) *RPCMethod {
+	return newRPCMethod(RPCPublic, fn, argNames)
+}
+
+// UserRPC registers a method for viewers that logged in with a password.
+func UserRPC(fn any, argNames ...string) *RPCMethod {
+	return newRPCMethod(RPCUser, fn, argNames)
+}
+
+// AdminRPC registers a method for admins.
+func AdminRPC(fn any, argNames ...string) *RPCMethod {
+	return newRPCMethod(RPCAdmin, fn, argNames)
+}
+
+// Call decodes the arguments & runs the method. Returned errors are always *RPCError.
+func (m *RPCMethod) Call(c *WebsocketClient, args []json.RawMessage) (result any, rpcErr *RPCError) {
+	switch {
+	case m.Access == RPCAdmin && !c.admin:
+		return nil, rpcErrorf(RPCForbidden, "admins only")
+	case m.Access == RPCUser && c.user == nil:
+		return nil, rpcErrorf(RPCForbidden, "log in first")
+	}
+	if len(args) != len(m.argTypes) {
+		return nil, rpcErrorf(RPCBadArguments, "expected %d arguments, got %d", len(m.argTypes), len(args))
+	}
+	in := []reflect.Value{reflect.ValueOf(c)}
+	for i, t := range m.argTypes {
+		arg := reflect.New(t)
+		if err := json.Unmarshal(args[i], arg.Interface()); err != nil {
+			return nil, rpcErrorf(RPCBadArguments, "%s: %v", m.ArgNames[i], err)
+		}
+		if validator, ok := arg.Interface().(rpcValidator); ok {
+			if err := validator.Validate(); err != nil {
+				return nil, rpcErrorf(RPCInvalidArguments, "%s: %v", m.ArgNames[i], err)
+			}
+		}
+		in = append(in, arg.Elem())
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			result, rpcErr = nil, rpcErrorf(RPCInternal, "%v", r)
+		}
+	}()
+	out := m.fn.Call(in)
+	if m.returnsErr {
+		if err, _ := out[len(out)-1].Interface().(error); err != nil {
+			if e, ok := err.(*RPCError); ok {
+				return nil, e
+			}
+			return nil, &RPCError{Code: RPCFailed, Message: err.Error()}
+		}
+	}
+	if m.resultType != nil {
+		result = out[0].Interface()
+	}
+	return result, nil
+}
+
+type rpcResponse struct {
+	ID     json.RawMessage `json:"id"`
+	Result any             `json:"result"`
+	Error  *RPCError       `json:"error,omitempty"`
+}
+
+// handleRPC runs the requested method and sends the response if the message has an id.
+func (c *WebsocketClient) handleRPC(message JavaScriptMessage) {
+	var result any
+	var err *RPCError
+	if method, ok := JavaScriptHandlers[message.Call]; ok {
+		result, err = method.Call(c, message.Args)
+	} else {
+		err = rpcErrorf(RPCUnknownMethod, "unknown method %q", message.Call)
+	}
+	if message.ID == nil {
+		if err != nil {
+			warn_color.Printf("%s: %s\n", message.Call, err)
+		}
+		return
+	}
+	response, marshalErr := json.Marshal(rpcResponse{ID: message.ID, Result: result, Error: err})
+	if marshalErr != nil {
+		response, _ = json.Marshal(rpcResponse{ID: message.ID, Error: rpcErrorf(RPCInternal, "%v", marshalErr)})
+	}
+	if !c.trySend(response) {
+		warn_color.Printf("%s: websocket client is gone, dropping the response\n", message.Call)
+	}
+}
+
+// jsType returns the JSDoc type of the Go type.
+func jsType(t reflect.Type) string {
+	if t == reflect.TypeFor[json.RawMessage]() {
+		return "any"
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return jsType(t.Elem())
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return jsType(t.Elem()) + "[]"
+	case reflect.Map:
+		return "Object<string, " + jsType(t.Elem()) + ">"
+	case reflect.Struct:
+		if t.Name() != "" {
+			return t.Name()
+		}
+	}
+	return "any"
+}
+
+// RPCClientJS generates the JS client for the methods in JavaScriptHandlers.
+func RPCClientJS() []byte {
+	var b bytes.Buffer
+	b.WriteString(`// Code generated from JavaScriptHandlers (rpc.go). DO NOT EDIT.
+//
+// Every method sends a request over the global "ws" websocket and returns a Promise of its result. Failed calls
+// reject with an RPCError. OnMessage should pass all messages to rpc.HandleResponse and OnClose should call
+// rpc.RejectAll.
+"use strict";
+
+class RPCError extends Error {
+  constructor(method, error) {
+    super(method + ": " + error.message);
+    this.name = "RPCError";
+    this.code = error.code;
+  }
+}
+
+const rpc = (() => {
+  let nextID = 1;
+  const pending = new Map();
+  function call(method, args) {
+    return new Promise((resolve, reject) => {
+      if (typeof ws == "undefined" || ws.readyState != WebSocket.OPEN) {
+        reject(new RPCError(method, { code: "disconnected", message: "not connected" }));
+        return;
+      }
+      const id = nextID++;
+      pending.set(id, { method, resolve, reject });
+      ws.send(JSON.stringify({ id, call: method, args }));
+    });
+  }
+  return {
+    // HandleResponse settles the call that the message responds to. Returns false for other messages.
+    HandleResponse(json) {
+      if (!("id" in json) || !pending.has(json.id)) {
+        return false;
+      }
+      const { method, resolve, reject } = pending.get(json.id);
+      pending.delete(json.id);
+      if (json.error) {
+        reject(new RPCError(method, json.error));
+      } else {
+        resolve(json.result);
+      }
+      return true;
+    },
+    // RejectAll fails the calls that are still waiting for a response.
+    RejectAll() {
+      for (const [id, { method, reject }] of pending) {
+        reject(new RPCError(method, { code: "disconnected", message: "connection lost" }));
+      }
+      pending.clear();
+    },
+`)
+	names := make([]string, 0, len(JavaScriptHandlers))
+	for name := range JavaScriptHandlers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		m := JavaScriptHandlers[name]
+		b.WriteString("    /**\n")
+		switch m.Access {
+		case RPCUser:
+			b.WriteString("     * Requires a password.\n")
+		case RPCAdmin:
+			b.WriteString("     * Admins only.\n")
+		}
+		for i, t := range m.argTypes {
+			fmt.Fprintf(&b, "     * @param {%s} %s\n", jsType(t), m.ArgNames[i])
+		}
+		result := "void"
+		if m.resultType != nil {
+			result = jsType(m.resultType)
+		}
+		fmt.Fprintf(&b, "     * @returns {Promise<%s>}\n", result)
+		b.WriteString("     */\n")
+		args := strings.Join(m.ArgNames, ", ")
+		fmt.Fprintf(&b, "    %s(%s) {\n      return call(%q, [%s]);\n    },\n", name, args, name, args)
+	}
+	b.WriteString("  };\n})();\n")
+	return b.Bytes()
+}
+
+// WriteRPCClient regenerates static/rpc.js. The file is only written when it changes, so the pages aren't reloaded
+// needlessly.
+func WriteRPCClient() {
+	js := RPCClientJS()
+	if old, err := os.ReadFile(rpcClientPath); err == nil && bytes.Equal(old, js) {
+		return
+	}
+	if err := os.WriteFile(rpcClientPath, js, 0644); err != nil {
+		warn_color.Println("Couldn't write the RPC client:", err)
+	}
+}
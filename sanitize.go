@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// HTML sent to the pages (ChatEntry.HTML & alerts) goes through SanitizeHTML, no matter who built it. Everything
+// else that the pages receive is plain text and must be shown with textContent.
+//
+// The allowlist covers what the bot itself generates - icons, avatars, emotes, attachments & the markup of alerts.
+// Other tags are dropped (keeping their text), and so are the attributes that aren't listed, URLs with schemes
+// other than http(s) and CSS other than a few font properties.
+
+var sanitizerAttributes = []string{"class", "title", "alt", "style"}
+
+// Allowed tags & their attributes (in addition to sanitizerAttributes).
+var sanitizerTags = map[string][]string{
+	"a":      {"href", "target"},
+	"img":    {"src", "srcset"},
+	"video":  {"src", "autoplay", "loop", "controls", "muted"},
+	"div":    nil,
+	"span":   nil,
+	"p":      nil,
+	"br":     nil,
+	"strong": nil,
+	"b":      nil,
+	"em":     nil,
+	"i":      nil,
+	"u":      nil,
+	"s":      nil,
+	"small":  nil,
+	"sub":    nil,
+	"sup":    nil,
+}
+
+// Tags that are dropped together with their content.
+var sanitizerDropContent = []string{"script", "style", "iframe", "object", "embed", "template", "noscript", "textarea", "title", "svg", "math"}
+
+var sanitizerCSSProperties = []string{"color", "font-family", "font-size", "font-weight", "font-style", "text-decoration"}
+
+// No parentheses, so no url(...), expression(...) or var(...).
+var cssValueRegexp = regexp.MustCompile(`^[\w\s#.,%'"+-]+$`)
+
+var colorRegexp = regexp.MustCompile(`^#[0-9a-fA-F]{3,8}$`)
+
+// SanitizeHTML removes everything that's not on the allowlist and re-escapes the rest.
+func SanitizeHTML(s string) string {
+	var b strings.Builder
+	var open []string
+	skip := ""
+	z := html.NewTokenizer(strings.NewReader(s))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		token := z.Token()
+		if skip != "" {
+			if tt == html.EndTagToken && token.Data == skip {
+				skip = ""
+			}
+			continue
+		}
+		switch tt {
+		case html.TextToken:
+			b.WriteString(html.EscapeString(token.Data))
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if slices.Contains(sanitizerDropContent, token.Data) {
+				if tt == html.StartTagToken {
+					skip = token.Data
+				}
+				continue
+			}
+			attrs, allowed := sanitizerTags[token.Data]
+			if !allowed {
+				continue
+			}
+			b.WriteString("<" + token.Data)
+			for _, attr := range token.Attr {
+				if attr.Namespace != "" || !(slices.Contains(attrs, attr.Key) || slices.Contains(sanitizerAttributes, attr.Key)) {
+					continue
+				}
+				value, ok := sanitizeAttribute(attr.Key, attr.Val)
+				if !ok {
+					continue
+				}
+				b.WriteString(" " + attr.Key + `="` + html.EscapeString(value) + `"`)
+			}
+			b.WriteString(">")
+			if tt == html.StartTagToken && !isVoidTag(token.Data) {
+				open = append(open, token.Data)
+			}
+		case html.EndTagToken:
+			// Only close the tags that were opened, so the message can't close the elements of the page
+			i := len(open) - 1
+			for i >= 0 && open[i] != token.Data {
+				i--
+			}
+			if i < 0 {
+				continue
+			}
+			for j := len(open) - 1; j >= i; j-- {
+				b.WriteString("</" + open[j] + ">")
+			}
+			open = open[:i]
+		}
+	}
+	for j := len(open) - 1; j >= 0; j-- {
+		b.WriteString("</" + open[j] + ">")
+	}
+	return b.String()
+}
+
+func isVoidTag(tag string) bool {
+	return tag == "img" || tag == "br"
+}
+
+func sanitizeAttribute(key, value string) (string, bool) {
+	switch key {
+	case "href", "src":
+		return value, SafeURL(value)
+	case "srcset":
+		for _, candidate := range strings.Split(value, ",") {
+			fields := strings.Fields(candidate)
+			if len(fields) == 0 || !SafeURL(fields[0]) {
+				return "", false
+			}
+		}
+		return value, true
+	case "target":
+		return value, value == "_blank"
+	case "style":
+		return sanitizeCSS(value)
+	}
+	return value, true
+}
+
+// SafeURL returns true for http(s) and relative URLs.
+func SafeURL(s string) bool {
+	u, err := url.Parse(strings.TrimSpace(s))
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "", "http", "https":
+		return true
+	}
+	return false
+}
+
+// sanitizeCSS keeps the allowed font properties with simple values.
+func sanitizeCSS(style string) (string, bool) {
+	var kept []string
+	for _, declaration := range strings.Split(style, ";") {
+		property, value, found := strings.Cut(declaration, ":")
+		property = strings.ToLower(strings.TrimSpace(property))
+		value = strings.TrimSpace(value)
+		if !found || !slices.Contains(sanitizerCSSProperties, property) || !cssValueRegexp.MatchString(value) {
+			continue
+		}
+		kept = append(kept, property+": "+value)
+	}
+	return strings.Join(kept, "; "), len(kept) > 0
+}
+
+// SafeColor returns the color if it's a #hex color and an empty string otherwise.
+func SafeColor(color string) string {
+	if colorRegexp.MatchString(color) {
+		return color
+	}
+	return ""
+}
+
+// MarshalJSON sanitizes the HTML & the URLs of the fragments & badges, so it's safe wherever it ends up - the pages,
+// chat_log.txt, webhooks and the REST API.
+func (t ChatEntry) MarshalJSON() ([]byte, error) {
+	type entry ChatEntry // without the MarshalJSON method
+	e := entry(t)
+	e.HTML = SanitizeHTML(e.HTML)
+	e.Fragments = slices.Clone(e.Fragments)
+	for i := range e.Fragments {
+		if !SafeURL(e.Fragments[i].URL) {
+			e.Fragments[i].URL = ""
+		}
+		if _, ok := sanitizeAttribute("srcset", e.Fragments[i].SrcSet); !ok {
+			e.Fragments[i].SrcSet = ""
+		}
+	}
+	e.Badges = slices.Clone(e.Badges)
+	for i := range e.Badges {
+		if !SafeURL(e.Badges[i].URL) {
+			e.Badges[i].URL = ""
+		}
+	}
+	return json.Marshal(e)
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+func FuzzSanitizeHTML(f *testing.F) {
+	for _, seed := range []string{
+		`hello <strong>world</strong>`,
+		`<script>alert(1)</script>`,
+		`<SCRIPT SRC="https://example.com/x.js"></SCRIPT>`,
+		`<img src=x onerror=alert(1)>`,
+		`<img src="javascript:alert(1)" srcset="javascript:alert(1) 1x">`,
+		`<img srcset="https://example.com/a.png 1x, javascript:alert(1) 2x">`,
+		`<a href=" JaVaScRiPt:alert(1)">x</a>`,
+		`<a href="&#106;avascript:alert(1)">x</a>`,
+		`<a href="java&#x09;script:alert(1)">x</a>`,
+		`<a href="https://example.com" onclick="alert(1)" target="_top">x</a>`,
+		`<div style="background:url(javascript:alert(1))">x</div>`,
+		`<svg><script>alert(1)</script></svg>`,
+		`<iframe src="https://example.com"></iframe>`,
+		`<scr<script>ipt>alert(1)</script>`,
+		`<b onmouseover=alert(1)>x</b></div></body>`,
+		`<img/src="x"/onerror="alert(1)">`,
+		`<!-- <script>alert(1)</script> -->`,
+		`<video src="https://example.com/a.mp4" autoplay onplay="alert(1)"></video>`,
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		out := SanitizeHTML(s)
+		if strings.Contains(strings.ToLower(out), "<script") {
+			t.Fatalf("SanitizeHTML(%q) = %q, contains <script", s, out)
+		}
+		z := html.NewTokenizer(strings.NewReader(out))
+		for {
+			tt := z.Next()
+			if tt == html.ErrorToken {
+				break
+			}
+			if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+				continue
+			}
+			for _, attr := range z.Token().Attr {
+				if strings.HasPrefix(strings.ToLower(attr.Key), "on") {
+					t.Fatalf("SanitizeHTML(%q) = %q, contains the %s attribute", s, out, attr.Key)
+				}
+				var urls []string
+				switch attr.Key {
+				case "href", "src":
+					urls = []string{attr.Val}
+				case "srcset":
+					for _, candidate := range strings.Split(attr.Val, ",") {
+						if fields := strings.Fields(candidate); len(fields) > 0 {
+							urls = append(urls, fields[0])
+						}
+					}
+				}
+				for _, u := range urls {
+					// Browsers ignore the leading spaces & control characters and the tabs & newlines inside URLs
+					u = strings.TrimLeft(strings.ToLower(u), "\x00\x01\x02\x03\x04\x05\x06\x07\x08\t\n\x0b\x0c\r\x0e\x0f\x10\x11\x12\x13\x14\x15\x16\x17\x18\x19\x1a\x1b\x1c\x1d\x1e\x1f ")
+					u = strings.NewReplacer("\t", "", "\n", "", "\r", "").Replace(u)
+					if strings.HasPrefix(u, "javascript:") {
+						t.Fatalf("SanitizeHTML(%q) = %q, contains a javascript: URL", s, out)
+					}
+				}
+			}
+		}
+	})
+}
+
+func TestSanitizeHTML(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{`hello <strong>world</strong>`, `hello <strong>world</strong>`},
+		{`<script>alert(1)</script>hi`, `hi`},
+		{`<img src=x onerror=alert(1)>`, `<img src="x">`},
+		{`<a href="javascript:alert(1)" target="_blank">x</a>`, `<a target="_blank">x</a>`},
+		{`<a href="https://example.com" target="_top">x</a>`, `<a href="https://example.com">x</a>`},
+		{`<marquee>x</marquee>`, `x`},
+		{`<b>x</div></b>`, `<b>x</b>`},
+		{`<em>unclosed`, `<em>unclosed</em>`},
+		{`<span style="color: red; background: url(x)">x</span>`, `<span style="color: red">x</span>`},
+		{`1 < 2 & "3"`, `1 &lt; 2 &amp; &#34;3&#34;`},
+	}
+	for _, test := range tests {
+		if got := SanitizeHTML(test.in); got != test.want {
+			t.Errorf("SanitizeHTML(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestSafeURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://example.com/a.png", true},
+		{"http://example.com", true},
+		{"HTTPS://example.com", true},
+		{"/attachments/cat.png", true},
+		{"cat.png", true},
+		{"", true},
+		{"  https://example.com  ", true},
+		{"javascript:alert(1)", false},
+		{"JavaScript:alert(1)", false},
+		{" javascript:alert(1)", false},
+		{"java\tscript:alert(1)", false},
+		{"\x01javascript:alert(1)", false},
+		{"data:text/html,<script>alert(1)</script>", false},
+		{"vbscript:msgbox(1)", false},
+		{"file:///etc/passwd", false},
+	}
+	for _, test := range tests {
+		if got := SafeURL(test.url); got != test.want {
+			t.Errorf("SafeURL(%q) = %v, want %v", test.url, got, test.want)
+		}
+	}
+}
+
+func TestSanitizeCSS(t *testing.T) {
+	tests := []struct {
+		style string
+		want  string
+		ok    bool
+	}{
+		{"color: red", "color: red", true},
+		{"COLOR:#ff0000;font-size:12px;", "color: #ff0000; font-size: 12px", true},
+		{"font-family: 'Comic Sans MS', sans-serif", "font-family: 'Comic Sans MS', sans-serif", true},
+		{"color: red; background: blue", "color: red", true},
+		{"background-image: url(https://example.com/a.png)", "", false},
+		{"color: expression(alert(1))", "", false},
+		{"color: var(--x)", "", false},
+		{"color: red; position: fixed", "color: red", true},
+		{"color: red</style><script>", "", false},
+		{"color: red\\;", "", false},
+		{"", "", false},
+		{"color", "", false},
+	}
+	for _, test := range tests {
+		got, ok := sanitizeCSS(test.style)
+		if got != test.want || ok != test.ok {
+			t.Errorf("sanitizeCSS(%q) = %q, %v, want %q, %v", test.style, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestUserHTML(t *testing.T) {
+	tests := []struct {
+		name string
+		user User
+		want string
+	}{
+		{
+			name: "color",
+			user: User{TwitchUser: &TwitchUser{TwitchID: "1", Login: "alice", Name: "Alice", Color: "#1E90FF"}},
+			want: `<strong style="color:#1E90FF">Alice</strong>`,
+		},
+		{
+			name: "color breaking out of the style",
+			user: User{TwitchUser: &TwitchUser{TwitchID: "1", Login: "alice", Name: "Alice", Color: `red" onmouseover="alert(1)`}},
+			want: `<strong>Alice</strong>`,
+		},
+		{
+			name: "color with CSS",
+			user: User{TwitchUser: &TwitchUser{TwitchID: "1", Login: "alice", Name: "Alice", Color: "#fff;background:url(x)"}},
+			want: `<strong>Alice</strong>`,
+		},
+		{
+			name: "Twitch name with HTML",
+			user: User{TwitchUser: &TwitchUser{TwitchID: "1", Login: "alice", Name: `<script>alert(1)</script>`}},
+			want: `<strong>&lt;script&gt;alert(1)&lt;/script&gt;</strong>`,
+		},
+		{
+			name: "YouTube name with quotes",
+			user: User{YouTubeUser: &YouTubeUser{ChannelID: "UC1", Name: `"><img src=x onerror=alert(1)>`}},
+			want: `<strong>&#34;&gt;&lt;img src=x onerror=alert(1)&gt;</strong>`,
+		},
+		{
+			name: "YouTube avatar",
+			user: User{YouTubeUser: &YouTubeUser{ChannelID: "UC1", Name: "Bob", AvatarURL: `https://example.com/a.png?a=1&b="2"`}},
+			want: `<img src="https://example.com/a.png?a=1&amp;b=&#34;2&#34;" class="avatar"><strong>Bob</strong>`,
+		},
+		{
+			name: "YouTube avatar with a javascript: URL",
+			user: User{YouTubeUser: &YouTubeUser{ChannelID: "UC1", Name: "Bob", AvatarURL: "javascript:alert(1)"}},
+			want: `<strong>Bob</strong>`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.user.HTML(); got != test.want {
+				t.Errorf("HTML() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestChatEntryMarshalJSON(t *testing.T) {
+	entry := ChatEntry{
+		HTML: `<strong onclick="alert(1)">Alice</strong>: <script>alert(1)</script>hi`,
+		Fragments: []ChatFragment{
+			{Type: FragmentText, Text: "hi"},
+			{Type: FragmentEmote, Text: "Kappa", URL: "https://example.com/1.0", SrcSet: "https://example.com/1.0 1x,https://example.com/2.0 2x"},
+			{Type: FragmentEmote, Text: "evil", URL: "javascript:alert(1)", SrcSet: "https://example.com/1.0 1x,javascript:alert(1) 2x"},
+			{Type: FragmentLink, Text: "click", URL: " JavaScript:alert(1)"},
+			{Type: FragmentAttachment, Text: "cat.png", URL: "data:image/png;base64,AAAA", Media: MediaImage},
+		},
+		Badges: []ChatBadge{
+			{SetID: "moderator", URL: "https://example.com/mod.png"},
+			{SetID: "vip", URL: "javascript:alert(1)"},
+		},
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got ChatEntry
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if want := `<strong>Alice</strong>: hi`; got.HTML != want {
+		t.Errorf("HTML = %q, want %q", got.HTML, want)
+	}
+	wantURLs := []struct{ url, srcset string }{
+		{"", ""},
+		{"https://example.com/1.0", "https://example.com/1.0 1x,https://example.com/2.0 2x"},
+		{"", ""},
+		{"", ""},
+		{"", ""},
+	}
+	if len(got.Fragments) != len(wantURLs) {
+		t.Fatalf("got %d fragments, want %d", len(got.Fragments), len(wantURLs))
+	}
+	for i, want := range wantURLs {
+		if got.Fragments[i].URL != want.url || got.Fragments[i].SrcSet != want.srcset {
+			t.Errorf("fragment %d: URL = %q, SrcSet = %q, want %q, %q", i, got.Fragments[i].URL, got.Fragments[i].SrcSet, want.url, want.srcset)
+		}
+	}
+	if len(got.Badges) != 2 || got.Badges[0].URL != "https://example.com/mod.png" || got.Badges[1].URL != "" {
+		t.Errorf("Badges = %+v, want the javascript: URL removed", got.Badges)
+	}
+	if strings.Contains(strings.ToLower(string(data)), "javascript:") {
+		t.Errorf("JSON = %s, contains a javascript: URL", data)
+	}
+	// The entry itself isn't modified
+	if entry.Fragments[2].URL != "javascript:alert(1)" || entry.Badges[1].URL != "javascript:alert(1)" {
+		t.Error("MarshalJSON modified the entry")
+	}
+}
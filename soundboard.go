@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Sound board plays short clips from the sounds directory on the sfx bus. Clips go straight to the mixer, so they
+// play right away on top of TTS & alerts (which duck them) instead of waiting in the TTS queue.
+// Clips can be triggered by the admin, by `!sound NAME` in chat or by Twitch channel point rewards named after a clip.
+// Configured in config/sounds.json.
+
+const soundboardConfigFile = "sounds.json"
+
+type SoundboardConfig struct {
+	// Roles (see chat_roles.go) that may use `!sound`. Users allowed to use TTS markup may always use it. Empty
+	// means everybody.
+	Roles []string `json:"roles"`
+}
+
+var defaultSoundboardConfig = SoundboardConfig{
+	Roles: []string{RoleOwner, RoleModerator, RoleVIP, RoleSubscriber, RoleMember},
+}
+
+var soundboardConfig atomic.Pointer[SoundboardConfig]
+
+func LoadSoundboardConfig() {
+	cfg := defaultSoundboardConfig
+	if err := LoadConfig(soundboardConfigFile, &cfg); err != nil {
+		warn_color.Println("Using default sound board config:", err)
+		cfg = defaultSoundboardConfig
+	}
+	soundboardConfig.Store(&cfg)
+}
+
+func GetSoundboardConfig() *SoundboardConfig {
+	if cfg := soundboardConfig.Load(); cfg != nil {
+		return cfg
+	}
+	return &defaultSoundboardConfig
+}
+
+const (
+	// Minimum time between two plays of the same clip from chat or redemptions.
+	soundCooldown = 30 * time.Second
+	// Minimum time between two clips triggered by the same chat user.
+	soundUserCooldown = 2 * time.Minute
+)
+
+// Names of the clips (without extension). Filled once at startup by ScanSounds.
+var soundNames []string
+
+// Cooldown state. Only accessed from the main thread.
+var soundLastPlayed = map[string]time.Time{}
+var soundUserLastPlayed = map[string]time.Time{}
+
+func ScanSounds() error {
+	entries, err := os.ReadDir(soundsDir)
+	if err != nil {
+		return fmt.Errorf("couldn't read sounds directory: %w", err)
+	}
+	soundNames = nil
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.EqualFold(filepath.Ext(entry.Name()), ".wav") {
+			continue
+		}
+		name := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+		if !soundNameRegexp.MatchString(name) {
+			warn_color.Println("Skipping sound with unsupported name:", entry.Name())
+			continue
+		}
+		soundNames = append(soundNames, name)
+	}
+	slices.Sort(soundNames)
+	return nil
+}
+
+// FindSound returns the canonical name of a clip (case-insensitive match).
+func FindSound(name string) (string, bool) {
+	for _, sound := range soundNames {
+		if strings.EqualFold(sound, name) {
+			return sound, true
+		}
+	}
+	return "", false
+}
+
+// PlaySound plays a clip on the sfx bus. It doesn't check cooldowns.
+func PlaySound(name string) error {
+	sound, found := FindSound(name)
+	if !found {
+		return fmt.Errorf("unknown sound %q", name)
+	}
+	data, err := LoadSoundClip(sound)
+	if err != nil {
+		return err
+	}
+	wav, err := ParseWAV(data)
+	if err != nil {
+		return fmt.Errorf("couldn't parse sound %s: %w", sound, err)
+	}
+	AudioMixer.Play(BusSFX, wav)
+	return nil
+}
+
+// TryPlaySoundWithCooldown plays the clip unless it (or the user) is on a cooldown.
+// userKey may be empty to skip the per-user cooldown. Run this only on the main thread!
+func TryPlaySoundWithCooldown(name, userKey string) error {
+	sound, found := FindSound(name)
+	if !found {
+		return fmt.Errorf("unknown sound %q", name)
+	}
+	now := time.Now()
+	if since := now.Sub(soundLastPlayed[sound]); since < soundCooldown {
+		return fmt.Errorf("sound %s is on cooldown for %s", sound, (soundCooldown - since).Round(time.Second))
+	}
+	if userKey != "" {
+		if since := now.Sub(soundUserLastPlayed[userKey]); since < soundUserCooldown {
+			return fmt.Errorf("user is on cooldown for %s", (soundUserCooldown - since).Round(time.Second))
+		}
+	}
+	if err := PlaySound(sound); err != nil {
+		return err
+	}
+	soundLastPlayed[sound] = now
+	if userKey != "" {
+		soundUserLastPlayed[userKey] = now
+	}
+	return nil
+}
+
+// OnSoundCommand handles `!sound NAME` chat messages. Returns true if the message was a sound command.
+func OnSoundCommand(t ChatEntry) bool {
+	name, found := strings.CutPrefix(t.OriginalMessage, "!sound ")
+	if !found {
+		return false
+	}
+	if roles := GetSoundboardConfig().Roles; len(roles) > 0 && !t.HasRole(roles...) && !CanUseTTSMarkup(t.Author) {
+		chat_color.Printf("Not playing sound for %s: not allowed\n", t.Author.DisplayName())
+		return true
+	}
+	err := TryPlaySoundWithCooldown(strings.TrimSpace(name), t.Author.Key())
+	if err != nil {
+		chat_color.Printf("Not playing sound for %s: %s\n", t.Author.DisplayName(), err)
+	}
+	return true
+}
+
+func ListSounds(c *WebsocketClient) []string {
+	return soundNames
+}
+
+func PlaySoundHandler(c *WebsocketClient, name string) error {
+	return PlaySound(name)
+}
+
+// OnRedemption plays the sound named after a redeemed Twitch channel point reward. Run this only on the main thread!
+func OnRedemption(r Redemption) {
+	if _, found := FindSound(r.Reward); !found {
+		return
+	}
+	err := TryPlaySoundWithCooldown(r.Reward, "")
+	if err != nil {
+		twitchColor.Println("Couldn't play redeemed sound:", err)
+	}
+}
@@ -106,9 +106,9 @@ document.querySelectorAll(".tab").forEach((tab) => {
 
 var userVoice = "SMOrc";
 function LoadVoices() {
-  ws.send(JSON.stringify({ call: "ListVoices" }));
+  rpc.ListVoices().then(ShowVoices);
 }
-function ListVoicesResponse(voices) {
+function ShowVoices(voices) {
   let html = "";
   for (let i in voices) {
     html +=
@@ -140,13 +140,10 @@ function ListVoicesResponse(voices) {
 }
 function SetVoice(voice) {
   userVoice = voice.split(".")[0];
-  ws.send(JSON.stringify({ call: "SetVoice", args: [voice] }));
-  LoadVoices();
+  rpc.SetVoice(voice).then(LoadVoices, ShowRPCError);
 }
 function SetNamePronunciation(pronunciation) {
-  ws.send(
-    JSON.stringify({ call: "SetNamePronunciation", args: [pronunciation] }),
-  );
+  rpc.SetNamePronunciation(pronunciation).catch(ShowRPCError);
 }
 
 const chat = document.getElementById("chat");
@@ -167,7 +164,7 @@ if (!password) {
 var ws;
 function OnOpen() {
   chat.textContent = "";
-  ws.send(JSON.stringify({ call: "Password", args: [password] }));
+  rpc.Password(password).then(Welcome, ShowRPCError);
 }
 function Reload() {
   chat.textContent = "Reloading...";
@@ -189,44 +186,25 @@ function Welcome(user) {
     return;
   }
   loginCommand.value = "!login " + user.ticket;
-  if (user.twitch) {
-    document.getElementById("twitch-link").innerHTML =
-      '<a href="https://twitch.tv/' +
-      user.twitch.login +
-      '" target="_blank">' +
-      user.twitch.name +
-      "</a>";
-  } else {
-    document.getElementById("twitch-link").innerHTML = "Not linked";
-  }
-  if (user.youtube) {
-    document.getElementById("youtube-link").innerHTML =
-      '<a href="https://youtube.com/channel/' +
-      user.youtube.channel +
-      '" target="_blank"><img class="avatar" src="' +
-      user.youtube.avatar_url +
-      '">' +
-      user.youtube.name +
-      "</a>";
-  } else {
-    document.getElementById("youtube-link").innerHTML = "Not linked";
-  }
-  if (user.discord) {
-    let avatarURL = user.discord.avatar
-      ? "https://cdn.discordapp.com/avatars/" +
-        user.discord.id +
-        "/" +
-        user.discord.avatar +
-        ".png"
-      : "";
-    let avatarHTML = avatarURL
-      ? '<img class="avatar" src="' + avatarURL + '">'
-      : "";
-    document.getElementById("discord-link").innerHTML =
-      avatarHTML + user.discord.username;
-  } else {
-    document.getElementById("discord-link").innerHTML = "Not linked";
-  }
+  ShowAccountLink(
+    "twitch-link",
+    user.twitch && "https://twitch.tv/" + encodeURIComponent(user.twitch.login),
+    "",
+    user.twitch && user.twitch.name,
+  );
+  ShowAccountLink(
+    "youtube-link",
+    user.youtube &&
+      "https://youtube.com/channel/" + encodeURIComponent(user.youtube.channel),
+    user.youtube && user.youtube.avatar_url,
+    user.youtube && user.youtube.name,
+  );
+  ShowAccountLink(
+    "discord-link",
+    "",
+    user.discord && AuthorAvatarURL({ discord: user.discord }),
+    user.discord && user.discord.username,
+  );
   let voicesSpan = document.getElementById("voices");
   if (user.voice) {
     userVoice = user.voice.split(".")[0];
@@ -240,9 +218,149 @@ function Welcome(user) {
     namePronunciationInput.value = user.name_pronunciation;
   }
 }
+// ShowAccountLink shows the linked account without interpreting the names as HTML.
+function ShowAccountLink(id, url, avatar_url, name) {
+  let element = document.getElementById(id);
+  if (!name) {
+    element.textContent = "Not linked";
+    return;
+  }
+  let target = element;
+  element.textContent = "";
+  if (url) {
+    target = document.createElement("a");
+    target.href = url;
+    target.target = "_blank";
+    element.appendChild(target);
+  }
+  if (avatar_url) {
+    let avatar = document.createElement("img");
+    avatar.className = "avatar";
+    avatar.src = avatar_url;
+    target.appendChild(avatar);
+  }
+  target.appendChild(document.createTextNode(name));
+}
 // Makes the admin interface visible
 function AdminGranted() {
   document.body.classList.add("admin");
+  rpc.GetBusVolumes().then(SetBusVolumes, ShowRPCError);
+  rpc.ListSounds().then(ShowSounds, ShowRPCError);
+  rpc.GetVADConfig().then(SetVADConfig, ShowRPCError);
+  rpc.GetOBSState().then(SetOBSState, ShowRPCError);
+  rpc.GetStreamInfo().then(StreamInfo, ShowRPCError);
+  rpc.ListStreamPresets().then(StreamPresets, ShowRPCError);
+  rpc.GetGiveaways().then(Giveaways, ShowRPCError);
+  rpc.ListTimers().then(Timers, ShowRPCError);
+}
+function MicLevel(levelDb, active) {
+  let level = document.getElementById("mic-level");
+  if (!level) {
+    return;
+  }
+  let percent = Math.max(0, Math.min(100, levelDb + 100));
+  level.style.width = percent + "%";
+  level.style.background = active ? "#c00" : "#0a0";
+}
+function SetVADConfig(config) {
+  let open = document.getElementById("vad-open");
+  let close = document.getElementById("vad-close");
+  if (!open || !close) {
+    return;
+  }
+  open.value = config.open_threshold_db;
+  close.value = config.close_threshold_db;
+}
+function SendVADConfig() {
+  rpc
+    .SetVADConfig({
+      open_threshold_db: parseFloat(document.getElementById("vad-open").value),
+      close_threshold_db: parseFloat(document.getElementById("vad-close").value),
+    })
+    .catch(ShowRPCError);
+}
+let obs_state = {};
+function SetOBSState(state) {
+  obs_state = state;
+  let status = document.getElementById("obs-status");
+  if (!status) {
+    return;
+  }
+  status.textContent = state.connected
+    ? (state.streaming ? "🔴 live" : "⚫ offline") + (state.recording ? " ⏺️ recording" : "")
+    : "disconnected";
+  document.getElementById("obs-stream").textContent = state.streaming ? "Stop stream" : "Start stream";
+  document.getElementById("obs-record").textContent = state.recording ? "Stop recording" : "Start recording";
+  let scenes = document.getElementById("obs-scenes");
+  scenes.textContent = "";
+  for (let scene of state.scenes || []) {
+    let button = document.createElement("button");
+    button.textContent = scene;
+    if (scene == state.current_scene) {
+      button.classList.add("selected");
+    }
+    button.onclick = function () {
+      rpc.OBSSwitchScene(scene).catch(ShowRPCError);
+    };
+    scenes.appendChild(button);
+  }
+  let sources = document.getElementById("obs-sources");
+  sources.textContent = "";
+  for (let source of state.sources || []) {
+    let button = document.createElement("button");
+    button.textContent = (source.visible ? "👁️ " : "🚫 ") + source.name;
+    button.onclick = function () {
+      rpc.OBSSetSourceVisible(state.current_scene, source.id, !source.visible).catch(ShowRPCError);
+    };
+    sources.appendChild(button);
+  }
+  let inputs = document.getElementById("obs-inputs");
+  inputs.textContent = "";
+  for (let input of state.inputs || []) {
+    let button = document.createElement("button");
+    button.textContent = (input.muted ? "🔇 " : "🔊 ") + input.name;
+    button.onclick = function () {
+      rpc.OBSSetInputMute(input.name, !input.muted).catch(ShowRPCError);
+    };
+    inputs.appendChild(button);
+  }
+}
+function ShowSounds(sounds) {
+  let soundboard = document.getElementById("soundboard");
+  if (!soundboard) {
+    return;
+  }
+  soundboard.textContent = "";
+  for (let sound of sounds || []) {
+    let button = document.createElement("button");
+    button.textContent = sound;
+    button.onclick = function () {
+      rpc.PlaySound(sound).catch(ShowRPCError);
+    };
+    soundboard.appendChild(button);
+  }
+}
+function SetBusVolumes(volumes) {
+  let container = document.getElementById("bus-volumes");
+  if (!container) {
+    return;
+  }
+  container.textContent = "";
+  for (let bus of Object.keys(volumes).sort()) {
+    let label = document.createElement("label");
+    label.textContent = bus + " ";
+    let slider = document.createElement("input");
+    slider.type = "range";
+    slider.min = 0;
+    slider.max = 2;
+    slider.step = 0.05;
+    slider.value = volumes[bus];
+    slider.onchange = function () {
+      rpc.SetBusVolume(bus, parseFloat(slider.value)).then(SetBusVolumes, ShowRPCError);
+    };
+    label.appendChild(slider);
+    container.appendChild(label);
+  }
 }
 let ecg_pings = {
   Twitch: [],
@@ -474,12 +592,206 @@ function ShowAlert(html, durationMillis) {
     ShowNextAlert();
   }
 }
+let notice_timeout = null;
+// Shows a warning for the streamer (only on the overlay)
+function ShowNotice(message) {
+  let notice = document.getElementById("notice");
+  if (!notice) {
+    return;
+  }
+  notice.textContent = message;
+  notice.style.display = "block";
+  clearTimeout(notice_timeout);
+  notice_timeout = setTimeout(function () {
+    notice.style.display = "none";
+  }, 10000);
+}
+// Shows the errors of the calls made from the admin panel
+function ShowRPCError(err) {
+  console.error(err);
+  ShowNotice(err.message);
+}
+function StartPoll() {
+  let options = document
+    .getElementById("poll-options")
+    .value.split(",")
+    .map((option) => option.trim())
+    .filter((option) => option != "");
+  rpc
+    .StartPoll(
+      document.getElementById("poll-question").value,
+      options,
+      parseInt(document.getElementById("poll-duration").value),
+      document.getElementById("poll-mirror").value,
+    )
+    .catch(ShowRPCError);
+}
+let poll_hide_timeout = null;
+function PollUpdate(poll) {
+  let div = document.getElementById("poll");
+  if (!div) {
+    return;
+  }
+  let total = poll.options.reduce((sum, option) => sum + option.votes, 0);
+  div.textContent = "";
+  let question = document.createElement("div");
+  question.textContent = "📊 " + poll.question + (poll.closed ? " (ended)" : " - vote with !vote NUMBER");
+  div.appendChild(question);
+  poll.options.forEach((option, i) => {
+    let row = document.createElement("div");
+    let pct = total > 0 ? Math.round((option.votes / total) * 100) : 0;
+    row.textContent = i + 1 + ") " + option.title + " - " + option.votes + " (" + pct + "%)";
+    // Admins resolve mirrored Twitch predictions by clicking the winning option
+    if (poll.closed && document.getElementById("poll-admin")) {
+      let resolve = document.createElement("button");
+      resolve.textContent = "Resolve prediction";
+      resolve.onclick = function () {
+        rpc.ResolvePrediction(i).catch(ShowRPCError);
+      };
+      row.appendChild(resolve);
+    }
+    let bar = document.createElement("div");
+    bar.className = "poll-bar";
+    bar.style.width = pct + "%";
+    row.appendChild(bar);
+    div.appendChild(row);
+  });
+  div.style.display = "block";
+  clearTimeout(poll_hide_timeout);
+  if (poll.closed && !document.getElementById("poll-admin")) {
+    poll_hide_timeout = setTimeout(function () {
+      div.style.display = "none";
+    }, 15000);
+  }
+}
+function OpenGiveaway() {
+  rpc
+    .OpenGiveaway(
+      document.getElementById("giveaway-keyword").value,
+      document.getElementById("giveaway-prize").value,
+      {
+        followers_only: document.getElementById("giveaway-followers").checked,
+        min_messages: parseInt(document.getElementById("giveaway-messages").value) || 0,
+      },
+    )
+    .catch(ShowRPCError);
+}
+function GiveawayUpdate(giveaway) {
+  let status = document.getElementById("giveaway-status");
+  if (!status) {
+    return;
+  }
+  if (!giveaway) {
+    status.textContent = "No giveaway";
+    return;
+  }
+  let text = "🎁 " + giveaway.prize + " (" + giveaway.keyword + "): " + giveaway.entrants.length + " entrants";
+  if (giveaway.closed) {
+    text += ", " + (giveaway.eligible || []).length + " eligible";
+  }
+  if (giveaway.winners) {
+    text += " - winners: " + giveaway.winners.map((winner) => winner.name).join(", ");
+  }
+  if (giveaway.seed) {
+    text += " - seed " + giveaway.seed;
+  } else {
+    text += " - seed hash " + giveaway.seed_hash;
+  }
+  status.textContent = text;
+}
+function Giveaways(history) {
+  history = history || [];
+  let last = history[history.length - 1];
+  GiveawayUpdate(last && !last.cancelled ? last : null);
+}
+function Timers(timers) {
+  let div = document.getElementById("timers");
+  if (!div) {
+    return;
+  }
+  div.textContent = "⏰ ";
+  for (let timer of timers || []) {
+    let toggle = document.createElement("button");
+    toggle.textContent = timer.name + " (" + timer.messages + "/" + timer.min_messages + ")";
+    toggle.title = timer.message;
+    if (timer.enabled) {
+      toggle.classList.add("selected");
+    }
+    toggle.onclick = function () {
+      rpc.SetTimerEnabled(timer.name, !timer.enabled).catch(ShowRPCError);
+    };
+    div.appendChild(toggle);
+    let fire = document.createElement("button");
+    fire.textContent = "▶";
+    fire.title = "Post now";
+    fire.onclick = function () {
+      rpc.FireTimer(timer.name).catch(ShowRPCError);
+    };
+    div.appendChild(fire);
+  }
+}
 function SetAudioMessage(message) {
   document.getElementById("audio-highlight").textContent = message;
   document.getElementById("audio-fill").textContent = message;
   document.getElementById("audio-shadow").textContent = message;
 }
 
+// Themes live in static/themes/<name>/ - theme.css and (optionally) templates.html with <template> elements. Pages
+// pick one with ?theme=<name>, for example overlay.html?theme=bubbles. Templates missing from the theme come from
+// the "default" theme.
+//
+// The "chat-message" template is filled with the author & the message. Elements with data-slot="platform" and
+// data-slot="avatar" should be <img>, the "name" & "message" slots receive text.
+const static_base = new URL(".", document.currentScript.src);
+let theme_templates = {};
+async function LoadTemplates(theme) {
+  let response = await fetch(new URL("themes/" + theme + "/templates.html", static_base));
+  if (!response.ok) {
+    return;
+  }
+  let doc = new DOMParser().parseFromString(await response.text(), "text/html");
+  for (let template of doc.querySelectorAll("template[id]")) {
+    theme_templates[template.id] = template;
+  }
+}
+async function LoadTheme() {
+  let theme = new URLSearchParams(location.search).get("theme") || "default";
+  if (!/^[\w-]+$/.test(theme)) {
+    console.error("Invalid theme:", theme);
+    theme = "default";
+  }
+  document.body.dataset.theme = theme;
+  let link = document.createElement("link");
+  link.rel = "stylesheet";
+  link.href = new URL("themes/" + theme + "/theme.css", static_base);
+  document.head.appendChild(link);
+  try {
+    await LoadTemplates("default");
+    if (theme != "default") {
+      await LoadTemplates(theme);
+    }
+  } catch (err) {
+    // Pages opened from disk can't fetch the templates - they fall back to chat_entry.html
+    console.warn("Couldn't load the templates of theme", theme, err);
+  }
+}
+// FillTemplate clones the template & passes each [data-slot] element to fill(slot_name, element).
+function FillTemplate(id, fill) {
+  let fragment = theme_templates[id].content.cloneNode(true);
+  for (let element of fragment.querySelectorAll("[data-slot]")) {
+    fill(element.dataset.slot, element);
+  }
+  return fragment;
+}
+
+function AuthorPlatform(author) {
+  for (let platform of ["twitch", "youtube", "discord", "bot"]) {
+    if (platform in author) {
+      return platform;
+    }
+  }
+  return "";
+}
 function AuthorColor(author) {
   let twitch = author.twitch || {};
   let color = twitch.color || "inherit";
@@ -487,6 +799,12 @@ function AuthorColor(author) {
 }
 function AuthorAvatarURL(author) {
   let youtube = author.youtube || {};
+  let discord = author.discord || {};
+  if (discord.avatar) {
+    return (
+      "https://cdn.discordapp.com/avatars/" + discord.id + "/" + discord.avatar + ".png"
+    );
+  }
   let avatar_url = youtube.avatar_url || "";
   return avatar_url;
 }
@@ -496,15 +814,189 @@ function AuthorName(author) {
   }
   let twitch = author.twitch || {};
   let youtube = author.youtube || {};
-  return twitch.name || youtube.name || "";
+  let discord = author.discord || {};
+  return twitch.name || youtube.name || discord.username || "";
+}
+// RenderFragments builds the message from chat_entry.fragments. User text only goes through textContent.
+function RenderFragments(fragments) {
+  let container = document.createDocumentFragment();
+  for (let fragment of fragments) {
+    switch (fragment.type) {
+      case "emote": {
+        let img = document.createElement("img");
+        img.className = "emoji";
+        img.src = fragment.url;
+        if (fragment.srcset) {
+          img.srcset = fragment.srcset;
+        }
+        img.alt = img.title = fragment.text;
+        container.appendChild(img);
+        break;
+      }
+      case "mention": {
+        let mention = document.createElement("strong");
+        mention.className = "mention";
+        mention.textContent = fragment.text;
+        if (fragment.user) {
+          mention.style.color = AuthorColor(fragment.user);
+        }
+        container.appendChild(mention);
+        break;
+      }
+      case "link": {
+        let link = document.createElement("a");
+        link.href = fragment.url;
+        link.target = "_blank";
+        link.textContent = fragment.text;
+        container.appendChild(link);
+        break;
+      }
+      case "cheermote": {
+        let img = document.createElement("img");
+        img.className = "emoji";
+        img.src = fragment.url;
+        img.alt = img.title = fragment.text;
+        container.appendChild(img);
+        let bits = document.createElement("strong");
+        bits.className = "cheermote";
+        bits.textContent = fragment.bits;
+        container.appendChild(bits);
+        break;
+      }
+      case "attachment": {
+        let link = document.createElement("a");
+        link.href = fragment.url;
+        link.className = "attachment";
+        if (fragment.media == "image") {
+          let img = document.createElement("img");
+          img.className = "attachment";
+          img.src = fragment.url;
+          img.title = fragment.text;
+          link.appendChild(img);
+        } else if (fragment.media == "video") {
+          let video = document.createElement("video");
+          video.className = "attachment";
+          video.src = fragment.url;
+          video.autoplay = video.loop = video.muted = true;
+          link.appendChild(video);
+        } else {
+          link.textContent = fragment.text;
+        }
+        container.appendChild(link);
+        break;
+      }
+      default:
+        container.appendChild(document.createTextNode(fragment.text));
+    }
+  }
+  return container;
+}
+// Shown in place of the badges without images. Keep in sync with badgeSymbols in chat_roles.go.
+const BADGE_SYMBOLS = {
+  broadcaster: "👑",
+  owner: "👑",
+  moderator: "⚔️",
+  vip: "💎",
+  subscriber: "⭐",
+  founder: "⭐",
+  member: "⭐",
+  verified: "✔️",
+};
+function RenderBadges(badges) {
+  let container = document.createDocumentFragment();
+  for (let badge of badges || []) {
+    let element;
+    if (badge.url) {
+      element = document.createElement("img");
+      element.className = "emoji badge";
+      element.src = badge.url;
+      element.alt = badge.set_id;
+    } else if (badge.set_id in BADGE_SYMBOLS) {
+      element = document.createElement("span");
+      element.className = "badge";
+      element.textContent = BADGE_SYMBOLS[badge.set_id];
+    } else {
+      continue;
+    }
+    element.title = badge.set_id;
+    container.appendChild(element);
+  }
+  return container;
+}
+// RenderReply fills the quote of the message that chat_entry.reply_to points to.
+function RenderReply(reply, element) {
+  element.append("↪ ");
+  if (reply.author) {
+    let name = document.createElement("strong");
+    name.textContent = AuthorName(reply.author);
+    name.style.color = AuthorColor(reply.author);
+    element.append(name, ": ");
+  }
+  element.append(reply.text || "");
+}
+// RenderChatEntry fills the "chat-message" template of the theme. Entries without fragments (bot messages, older
+// chat logs) use the HTML made by the bot.
+function RenderChatEntry(chat_entry, element) {
+  if (!chat_entry.fragments || !theme_templates["chat-message"]) {
+    element.innerHTML = chat_entry.html || "";
+    return;
+  }
+  let author = chat_entry.author || {};
+  let platform = chat_entry.platform || AuthorPlatform(author);
+  element.dataset.platform = platform;
+  element.appendChild(
+    FillTemplate("chat-message", function (slot, el) {
+      switch (slot) {
+        case "platform":
+          if (platform) {
+            el.src = new URL(platform + ".svg", static_base);
+            el.alt = platform;
+          } else {
+            el.remove();
+          }
+          break;
+        case "avatar": {
+          let avatar_url = AuthorAvatarURL(author);
+          if (avatar_url) {
+            el.src = avatar_url;
+          } else {
+            el.remove();
+          }
+          break;
+        }
+        case "reply":
+          if (chat_entry.reply_to) {
+            RenderReply(chat_entry.reply_to, el);
+          } else {
+            el.remove();
+          }
+          break;
+        case "badges":
+          el.appendChild(RenderBadges(chat_entry.badges));
+          break;
+        case "name":
+          el.textContent = AuthorName(author);
+          el.style.color = AuthorColor(author);
+          break;
+        case "message":
+          el.appendChild(RenderFragments(chat_entry.fragments));
+          break;
+      }
+    })
+  );
 }
 function OnChatMessage(chat_entry) {
   let chat_log = document.createElement("div");
   chat_log.dataset.author = JSON.stringify(chat_entry.author);
   chat_log.classList.add("chat_log");
+  for (let role of chat_entry.roles || []) {
+    chat_log.classList.add("role-" + role);
+  }
+  chat_log.classList.toggle("first-message", !!chat_entry.first_message);
+  chat_log.classList.toggle("returning", !!chat_entry.returning);
   let text_span = document.createElement("span");
   let author_name = AuthorName(chat_entry.author);
-  text_span.innerHTML = chat_entry.html;
+  RenderChatEntry(chat_entry, text_span);
 
   if (document.body.classList.contains("admin")) {
     let control_panel = document.createElement("div");
@@ -517,22 +1009,31 @@ function OnChatMessage(chat_entry) {
       mute_button.textContent = "🤫";
       mute_button.title = "Mute " + author_name;
       mute_button.onclick = function () {
-        ws.send(
-          JSON.stringify({ call: "ToggleMuted", args: [chat_entry.author] }),
-        );
+        rpc.ToggleMuted(chat_entry.author).catch(ShowRPCError);
       };
       control_panel.appendChild(mute_button);
     }
+    if (can_mute) {
+      let markup_button = document.createElement("button");
+      markup_button.textContent = "🎭";
+      markup_button.title = "Toggle TTS markup for " + author_name;
+      markup_button.onclick = function () {
+        rpc.ToggleTTSMarkup(chat_entry.author).catch(ShowRPCError);
+      };
+      control_panel.appendChild(markup_button);
+    }
     let can_ban = "twitch" in chat_entry.author;
     if (can_ban) {
       let ban_button = document.createElement("button");
       ban_button.textContent = "💀";
       ban_button.title = "Ban " + author_name;
       ban_button.onclick = function () {
-        ban_button.innerHTML = "Ban <strong>" + author_name + "</strong>? ✅";
+        let name = document.createElement("strong");
+        name.textContent = author_name;
+        ban_button.replaceChildren("Ban ", name, "? ✅");
         ban_button.title = "Are you sure you want to ban " + author_name + "?";
         ban_button.onclick = function () {
-          ws.send(JSON.stringify({ call: "Ban", args: [chat_entry.author] }));
+          rpc.Ban(chat_entry.author).catch(ShowRPCError);
         };
       };
       control_panel.appendChild(ban_button);
@@ -548,7 +1049,8 @@ function OnChatMessage(chat_entry) {
       delete_button.onclick = function () {
         chat_entry.original_message = "";
         chat_entry.html = "";
-        ws.send(JSON.stringify({ call: "DeleteMessage", args: [chat_entry] }));
+        chat_entry.fragments = [];
+        rpc.DeleteMessage(chat_entry).catch(ShowRPCError);
       };
       control_panel.appendChild(delete_button);
     }
@@ -563,6 +1065,9 @@ function OnChatMessage(chat_entry) {
 }
 function OnMessage(event) {
   let json = JSON.parse(event.data);
+  if (rpc.HandleResponse(json)) {
+    return;
+  }
   if ("call" in json) {
     let call = json.call;
     let args = json.args || [];
@@ -580,22 +1085,96 @@ function SetStreamTitle(title) {
     titleElement.value = title;
   }
 }
+let stream_info = {};
+function StreamInfo(info) {
+  stream_info = info;
+  SetStreamTitle(info.title);
+  if (!document.getElementById("category-input")) {
+    return;
+  }
+  document.getElementById("category-input").value = info.category || "";
+  document.getElementById("language-input").value = info.language || "";
+  document.getElementById("tags-input").value = (info.tags || []).join(", ");
+  document.getElementById("description-input").value = info.description || "";
+}
+function ReadStreamInfo() {
+  let info = Object.assign({}, stream_info);
+  info.title = document.getElementById("title-input").value;
+  let category = document.getElementById("category-input").value;
+  if (category != info.category) {
+    info.category = category;
+    info.category_id = ""; // looked up by the bot
+  }
+  info.language = document.getElementById("language-input").value;
+  info.tags = document
+    .getElementById("tags-input")
+    .value.split(",")
+    .map((tag) => tag.trim())
+    .filter((tag) => tag != "");
+  info.description = document.getElementById("description-input").value;
+  return info;
+}
+function SendStreamInfo() {
+  rpc.SetStreamInfo(ReadStreamInfo()).catch(ShowRPCError);
+}
+let category_search_timeout = null;
+function SearchCategories(query) {
+  clearTimeout(category_search_timeout);
+  category_search_timeout = setTimeout(function () {
+    rpc.SearchCategories(query).then(ShowCategories, ShowRPCError);
+  }, 300);
+}
+function ShowCategories(categories) {
+  let datalist = document.getElementById("categories");
+  datalist.textContent = "";
+  for (let category of categories || []) {
+    let option = document.createElement("option");
+    option.value = category.name;
+    datalist.appendChild(option);
+  }
+}
+function StreamPresets(presets) {
+  let select = document.getElementById("stream-presets");
+  let selected = select.value;
+  select.textContent = "";
+  for (let name of Object.keys(presets || {}).sort()) {
+    let option = document.createElement("option");
+    option.value = name;
+    option.textContent = name;
+    select.appendChild(option);
+  }
+  select.value = selected;
+}
+function SaveStreamPreset() {
+  let name = prompt("Preset name", document.getElementById("stream-presets").value);
+  if (!name) {
+    return;
+  }
+  rpc.SaveStreamPreset(name, ReadStreamInfo()).catch(ShowRPCError);
+}
 function Connect() {
   let protocol = location.protocol == "https:" ? "wss:" : "ws:";
   let domain =
     location.host == "" || location.host == "absolute"
       ? "localhost:3447"
       : location.host;
-  ws = new WebSocket(protocol + "//" + domain + "/live/ws");
+  // Widgets (separate OBS browser sources) can limit the broadcasts, for example overlay.html?topics=alerts. Pages
+  // in static/widgets/ choose their default topics with <body data-topics="...">.
+  let topics =
+    new URLSearchParams(location.search).get("topics") ||
+    document.body.dataset.topics;
+  let query = topics ? "?topics=" + encodeURIComponent(topics) : "";
+  ws = new WebSocket(protocol + "//" + domain + "/live/ws" + query);
   ws.onopen = OnOpen;
   ws.onmessage = OnMessage;
   ws.onclose = OnClose;
 }
 function OnClose() {
+  rpc.RejectAll();
   for (let component of Object.keys(ecg_pings)) {
     Ping(component);
   }
   chat.textContent = "Connection lost. Reconnecting...";
   setTimeout(Connect, 1000);
 }
-Connect();
+LoadTheme().finally(Connect);
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/nicklaw5/helix/v2"
+	"google.golang.org/api/youtube/v3"
+)
+
+// Stream metadata shared by Twitch & YouTube. The current info is kept in config/stream_info.json and named presets
+// (one per project) in config/stream_presets.json.
+
+const (
+	streamInfoConfigFile    = "stream_info.json"
+	streamPresetsConfigFile = "stream_presets.json"
+)
+
+const (
+	youtubeMaxTitleLength       = 100
+	youtubeMaxDescriptionLength = 5000
+	youtubeMaxTagsLength        = 500
+	twitchMaxTags               = 10
+	twitchMaxTagLength          = 25
+	// How long SetStreamInfo waits for the YouTube bot.
+	streamInfoYouTubeTimeout = 10 * time.Second
+)
+
+type StreamInfo struct {
+	Title string `json:"title"`
+	// Twitch category (game). CategoryID is looked up with SearchCategories when empty.
+	Category   string `json:"category"`
+	CategoryID string `json:"category_id"`
+	// YouTube category ID, for example "20" (Gaming) or "28" (Science & Technology). Empty keeps the current one.
+	YouTubeCategoryID string   `json:"youtube_category_id,omitempty"`
+	Tags              []string `json:"tags"`
+	// YouTube only - Twitch has no per-stream description.
+	Description string `json:"description"`
+	// ISO 639-1 code
+	Language string `json:"language"`
+}
+
+var defaultStreamInfo = StreamInfo{
+	Language: "en",
+	Tags: []string{
+		"Twitch",
+		"Game Making",
+		"Game Development",
+		"Game Programming",
+		"Game Art",
+		"Indie Game",
+		"Game Developer",
+		"Indie Developer",
+		"Chatting",
+		"Memes",
+		"English",
+		"GameDevelopmemt",
+		"Programming",
+		"ASMR",
+		"Coding",
+		"Cpp",
+		"C++",
+		"Design",
+		"CoWorking",
+		"OpenSource",
+		"LiveCoding",
+		"Live",
+		"LiveStream",
+		"Automat",
+		"Automation",
+		"Vulkan",
+	},
+}
+
+var streamInfoMu sync.Mutex
+var streamInfo StreamInfo
+var streamPresets = map[string]StreamInfo{}
+
+func LoadStreamInfo() {
+	streamInfoMu.Lock()
+	defer streamInfoMu.Unlock()
+	streamInfo = defaultStreamInfo
+	if err := LoadConfig(streamInfoConfigFile, &streamInfo); err != nil {
+		warn_color.Println("Using default stream info:", err)
+		streamInfo = defaultStreamInfo
+	}
+	if err := LoadConfig(streamPresetsConfigFile, &streamPresets); err != nil {
+		warn_color.Println("Couldn't load stream presets:", err)
+	}
+}
+
+func GetStreamInfo() StreamInfo {
+	streamInfoMu.Lock()
+	defer streamInfoMu.Unlock()
+	return streamInfo
+}
+
+func (info *StreamInfo) Validate() error {
+	if info.Title == "" {
+		return fmt.Errorf("empty title")
+	}
+	if len([]rune(info.Title)) > youtubeMaxTitleLength {
+		return fmt.Errorf("title longer than %d characters", youtubeMaxTitleLength)
+	}
+	if len([]rune(info.Description)) > youtubeMaxDescriptionLength {
+		return fmt.Errorf("description longer than %d characters", youtubeMaxDescriptionLength)
+	}
+	if strings.ContainsAny(info.Title+info.Description, "<>") {
+		return fmt.Errorf("YouTube doesn't allow < or > in the title or description")
+	}
+	tagsLength := 0
+	for _, tag := range info.Tags {
+		tagsLength += len([]rune(tag))
+		if strings.Contains(tag, " ") {
+			tagsLength += 2 // YouTube counts the quotes around tags with spaces
+		}
+	}
+	if tagsLength > youtubeMaxTagsLength {
+		return fmt.Errorf("tags longer than %d characters", youtubeMaxTagsLength)
+	}
+	return nil
+}
+
+// twitchTags converts the tags to the Twitch format - at most 10 alphanumeric tags, up to 25 characters each.
+func twitchTags(tags []string) []string {
+	var result []string
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		tag = strings.Map(func(r rune) rune {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				return r
+			}
+			return -1
+		}, tag)
+		key := strings.ToLower(tag)
+		if tag == "" || len([]rune(tag)) > twitchMaxTagLength || seen[key] {
+			continue
+		}
+		seen[key] = true
+		result = append(result, tag)
+		if len(result) == twitchMaxTags {
+			break
+		}
+	}
+	return result
+}
+
+// UpdateStreamInfoFromTwitch fills the title, category & language missing from the stored info with the ones from the
+// Twitch channel, without pushing them to the platforms. Stored values are kept.
+func UpdateStreamInfoFromTwitch(channel helix.ChannelInformation) {
+	streamInfoMu.Lock()
+	if streamInfo.Title == "" {
+		streamInfo.Title = channel.Title
+	}
+	if streamInfo.Category == "" {
+		streamInfo.Category = channel.GameName
+		streamInfo.CategoryID = channel.GameID
+	}
+	if streamInfo.Language == "" {
+		streamInfo.Language = channel.BroadcasterLanguage
+	}
+	info := streamInfo
+	streamInfoMu.Unlock()
+	Webserver.CallAdmins("StreamInfo", info)
+}
+
+// streamInfoChanges marks the fields of StreamInfo that changed. Clearing a field is a change too.
+type streamInfoChanges struct {
+	Title, Category, YouTubeCategoryID, Tags, Description, Language bool
+}
+
+func (c streamInfoChanges) twitch() bool {
+	return c.Title || c.Category || c.Tags || c.Language
+}
+
+func (c streamInfoChanges) youtube() bool {
+	return c.Title || c.YouTubeCategoryID || c.Tags || c.Description || c.Language
+}
+
+// changesFrom returns the fields of info that differ from old.
+func (info StreamInfo) changesFrom(old StreamInfo) streamInfoChanges {
+	return streamInfoChanges{
+		Title:             info.Title != old.Title,
+		Category:          info.Category != old.Category || info.CategoryID != old.CategoryID,
+		YouTubeCategoryID: info.YouTubeCategoryID != old.YouTubeCategoryID,
+		Tags:              !slices.Equal(info.Tags, old.Tags),
+		Description:       info.Description != old.Description,
+		Language:          info.Language != old.Language,
+	}
+}
+
+// SetStreamInfo updates the stream metadata on Twitch & YouTube. Only the fields that changed are sent to the
+// platforms.
+func SetStreamInfo(info StreamInfo) error {
+	if err := info.Validate(); err != nil {
+		return err
+	}
+	streamInfoMu.Lock()
+	changed := info.changesFrom(streamInfo)
+	streamInfo = info
+	streamInfoMu.Unlock()
+	if err := SaveConfig(streamInfoConfigFile, &info); err != nil {
+		warn_color.Println("Couldn't save stream info:", err)
+	}
+	Webserver.CallAdmins("StreamInfo", info)
+	if changed.Title {
+		fmt.Printf("Changing stream title to \"%s\"\n", info.Title)
+		Webserver.CallTopic(TopicNowPlaying, "SetStreamTitle", info.Title)
+	}
+	if changed.twitch() {
+		TwitchHelixChannel <- func(client *helix.Client) {
+			err := setTwitchStreamInfo(client, info, changed)
+			if err != nil {
+				twitchColor.Println("Couldn't edit Twitch channel information:", err)
+			}
+		}
+	}
+	if changed.youtube() {
+		select {
+		case YouTubeBotChannel <- func(youtube *youtube.Service) error {
+			return setYouTubeStreamInfo(youtube, info, changed)
+		}:
+		case <-time.After(streamInfoYouTubeTimeout):
+			return fmt.Errorf("stream info saved, but the YouTube bot is busy - YouTube wasn't updated")
+		}
+	}
+	return nil
+}
+
+// setTwitchStreamInfo sends the changed fields to Twitch.
+func setTwitchStreamInfo(client *helix.Client, info StreamInfo, changed streamInfoChanges) error {
+	params := &helix.EditChannelInformationParams{BroadcasterID: twitchBroadcasterID}
+	if changed.Title {
+		params.Title = info.Title
+	}
+	if changed.Category {
+		switch {
+		case info.CategoryID != "":
+			params.GameID = info.CategoryID
+		case info.Category != "":
+			category, err := findTwitchCategory(client, info.Category)
+			if err != nil {
+				return err
+			}
+			params.GameID = category.ID
+		default:
+			params.GameID = "0" // unsets the category
+		}
+	}
+	if changed.Language {
+		params.BroadcasterLanguage = cmp.Or(info.Language, "other") // Twitch has no empty language
+	}
+	clearTags := false
+	if changed.Tags {
+		params.Tags = twitchTags(info.Tags)
+		clearTags = len(params.Tags) == 0
+	}
+	if params.Title != "" || params.GameID != "" || params.BroadcasterLanguage != "" || len(params.Tags) > 0 {
+		resp, err := client.EditChannelInformation(params)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != 204 {
+			return fmt.Errorf("%s", resp.ErrorMessage)
+		}
+		if changed.Title {
+			twitchTitle = info.Title
+		}
+	}
+	if clearTags {
+		return clearTwitchTags(client)
+	}
+	return nil
+}
+
+// clearTwitchTags removes all tags from the channel. EditChannelInformationParams can't do that, because it omits
+// empty tags.
+func clearTwitchTags(client *helix.Client) error {
+	clientID, err := ReadStringFromFile(secretsPath("twitch_client_id.txt"))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPatch, "https://api.twitch.tv/helix/channels?broadcaster_id="+twitchBroadcasterID, strings.NewReader(`{"tags":[]}`))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+client.GetUserAccessToken())
+	req.Header.Set("Client-Id", clientID)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("couldn't clear the tags: %s", resp.Status)
+	}
+	return nil
+}
+
+func findTwitchCategory(client *helix.Client, name string) (*helix.Category, error) {
+	resp, err := client.SearchCategories(&helix.SearchCategoriesParams{Query: name})
+	if err != nil {
+		return nil, err
+	}
+	for _, category := range resp.Data.Categories {
+		if strings.EqualFold(category.Name, name) {
+			return &category, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown Twitch category %q", name)
+}
+
+// setYouTubeStreamInfo sends the changed fields to YouTube.
+func setYouTubeStreamInfo(youtube *youtube.Service, info StreamInfo, changed streamInfoChanges) error {
+	if youtubeVideoId == "" {
+		return nil
+	}
+	listCall := youtube.Videos.List([]string{"id", "snippet"})
+	listCall.Id(youtubeVideoId)
+	resp, err := listCall.Do()
+	if err != nil {
+		return err
+	}
+	if len(resp.Items) == 0 {
+		fmt.Println("Couldn't find YouTube video with ID:", youtubeVideoId)
+		return nil
+	}
+	video := resp.Items[0]
+	video.Id = youtubeVideoId // this is not returned because we specify fields
+	// Note that we need to have the video.Snippet.CategoryId set. That's the entire reason for the first request.
+	// Unchanged fields keep the values that are already on YouTube.
+	if changed.Title {
+		video.Snippet.Title = info.Title
+	}
+	if changed.Description {
+		video.Snippet.Description = info.Description
+	}
+	if changed.Tags {
+		video.Snippet.Tags = info.Tags
+	}
+	// YouTube requires a category - an empty one keeps the current category
+	if changed.YouTubeCategoryID && info.YouTubeCategoryID != "" {
+		video.Snippet.CategoryId = info.YouTubeCategoryID
+	}
+	if changed.Language {
+		video.Snippet.DefaultLanguage = info.Language
+		video.Snippet.DefaultAudioLanguage = info.Language
+	}
+	_, err = youtube.Videos.Update([]string{"id", "snippet"}, video).Do()
+	return err
+}
+
+func saveStreamPresets() {
+	if err := SaveConfig(streamPresetsConfigFile, streamPresets); err != nil {
+		warn_color.Println("Couldn't save stream presets:", err)
+	}
+}
+
+// Websocket handlers
+
+func GetStreamInfoHandler(c *WebsocketClient) StreamInfo {
+	return GetStreamInfo()
+}
+
+func SetStreamInfoHandler(c *WebsocketClient, info StreamInfo) error {
+	return SetStreamInfo(info)
+}
+
+func SetTitleHandler(c *WebsocketClient, title string) error {
+	info := GetStreamInfo()
+	info.Title = title
+	return SetStreamInfo(info)
+}
+
+func SearchCategoriesHandler(c *WebsocketClient, query string) ([]helix.Category, error) {
+	if query == "" {
+		return nil, nil
+	}
+	type searchResult struct {
+		categories []helix.Category
+		err        error
+	}
+	result := make(chan searchResult, 1)
+	TwitchHelixChannel <- func(client *helix.Client) {
+		resp, err := client.SearchCategories(&helix.SearchCategoriesParams{Query: query})
+		if err != nil {
+			result <- searchResult{err: fmt.Errorf("couldn't search categories: %w", err)}
+			return
+		}
+		result <- searchResult{categories: resp.Data.Categories}
+	}
+	r := <-result
+	return r.categories, r.err
+}
+
+func ListStreamPresetsHandler(c *WebsocketClient) map[string]StreamInfo {
+	streamInfoMu.Lock()
+	defer streamInfoMu.Unlock()
+	return maps.Clone(streamPresets)
+}
+
+func SaveStreamPresetHandler(c *WebsocketClient, name string, info StreamInfo) error {
+	if name == "" {
+		return fmt.Errorf("empty preset name")
+	}
+	streamInfoMu.Lock()
+	defer streamInfoMu.Unlock()
+	streamPresets[name] = info
+	saveStreamPresets()
+	Webserver.CallAdmins("StreamPresets", streamPresets)
+	return nil
+}
+
+func DeleteStreamPresetHandler(c *WebsocketClient, name string) {
+	streamInfoMu.Lock()
+	defer streamInfoMu.Unlock()
+	delete(streamPresets, name)
+	saveStreamPresets()
+	Webserver.CallAdmins("StreamPresets", streamPresets)
+}
+
+func ApplyStreamPresetHandler(c *WebsocketClient, name string) error {
+	streamInfoMu.Lock()
+	info, found := streamPresets[name]
+	streamInfoMu.Unlock()
+	if !found {
+		return fmt.Errorf("unknown preset %q", name)
+	}
+	return SetStreamInfo(info)
+}
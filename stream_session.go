@@ -0,0 +1,316 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/nicklaw5/helix/v2"
+	"google.golang.org/api/youtube/v3"
+)
+
+// Stream lifecycle tracking. OBS, Twitch and YouTube report independently whether the stream is live.
+// A session starts when the first of them goes live and ends once all of them are offline for a while.
+// Finished sessions are appended to stream_sessions.json.
+
+// Sources of the online/offline signal.
+const (
+	StreamSourceOBS     = "obs"
+	StreamSourceTwitch  = "twitch"
+	StreamSourceYouTube = "youtube"
+)
+
+const (
+	// How long all sources must stay offline before the session ends. Protects against short disconnects.
+	streamOfflineGrace = 5 * time.Minute
+	// Delay between going live and the automatic announcement, so that the stream is watchable when people click.
+	announceDelay = 30 * time.Second
+	// How often YouTube is polled for the broadcast status.
+	streamPollInterval = time.Minute
+	// How long a state pushed by an event (Twitch EventSub, OBS) wins over polling. Twitch caches GetStreams, so a
+	// poll can report the stream as offline for a few minutes after stream.online.
+	streamPushPrecedence = 3 * time.Minute
+	// How long the stream & viewer polls wait for the Twitch & YouTube bots.
+	streamPollTimeout = 10 * time.Second
+)
+
+var streamSessionsPath = path.Join(baseDir, "stream_sessions.json")
+
+var streamColor = color.New(color.FgHiMagenta)
+
+type StreamSession struct {
+	Start       time.Time  `json:"start"`
+	End         *time.Time `json:"end,omitempty"`
+	Title       string     `json:"title"`
+	PeakViewers int        `json:"peak_viewers"`
+	// Sources that reported the stream as live during the session.
+	Sources   []string `json:"sources"`
+	Announced bool     `json:"announced"`
+}
+
+type StreamTracker struct {
+	mu      sync.Mutex
+	online  map[string]bool
+	session *StreamSession
+	// Viewer counts per platform. The session peak is the peak of their sum.
+	viewers  map[string]int
+	endTimer *time.Timer
+	// Incremented when the end timer is started or stopped. A timer that fired while it was being stopped sees a
+	// different generation and leaves the session alone.
+	endGeneration int
+	// When the state of each source was last pushed by an event.
+	pushed map[string]time.Time
+}
+
+var Stream = &StreamTracker{
+	online:  map[string]bool{},
+	viewers: map[string]int{},
+	pushed:  map[string]time.Time{},
+}
+
+// IsLive returns true while a stream session is in progress.
+func (t *StreamTracker) IsLive() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.session != nil
+}
+
+// Session returns a copy of the current session or nil when offline.
+func (t *StreamTracker) Session() *StreamSession {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.session == nil {
+		return nil
+	}
+	session := *t.session
+	session.Sources = append([]string(nil), t.session.Sources...)
+	return &session
+}
+
+// SetOnline records the state pushed by an event. It takes precedence over polling for streamPushPrecedence.
+func (t *StreamTracker) SetOnline(source string, online bool) {
+	t.mu.Lock()
+	t.pushed[source] = time.Now()
+	changed := t.setOnline(source, online)
+	t.mu.Unlock()
+	if changed {
+		Events.Publish(StreamStateChanged{Source: source, Online: online})
+	}
+}
+
+// SetPolledOnline records the state returned by polling. Ignored shortly after an event pushed the state.
+func (t *StreamTracker) SetPolledOnline(source string, online bool) {
+	t.mu.Lock()
+	changed := false
+	if t.online[source] != online && time.Since(t.pushed[source]) < streamPushPrecedence {
+		streamColor.Printf("Ignoring stale %s status (online: %v)\n", source, online)
+	} else {
+		changed = t.setOnline(source, online)
+	}
+	t.mu.Unlock()
+	if changed {
+		Events.Publish(StreamStateChanged{Source: source, Online: online})
+	}
+}
+
+// setOnline returns true if the state of the source changed. Must be called with the lock held.
+func (t *StreamTracker) setOnline(source string, online bool) bool {
+	if t.online[source] == online {
+		return false
+	}
+	t.online[source] = online
+	if !online {
+		delete(t.viewers, source)
+	}
+	if online {
+		if t.endTimer != nil {
+			t.endTimer.Stop()
+			t.endTimer = nil
+			t.endGeneration++
+		}
+		if t.session == nil {
+			t.startSession()
+		}
+		if !slices.Contains(t.session.Sources, source) {
+			t.session.Sources = append(t.session.Sources, source)
+		}
+		streamColor.Println("Stream is live on", source)
+		return true
+	}
+	streamColor.Println("Stream went offline on", source)
+	for _, live := range t.online {
+		if live {
+			return true
+		}
+	}
+	if t.session != nil && t.endTimer == nil {
+		t.endGeneration++
+		generation := t.endGeneration
+		t.endTimer = time.AfterFunc(streamOfflineGrace, func() { t.endSession(generation) })
+	}
+	return true
+}
+
+// SetViewers records the current number of viewers on a platform.
+func (t *StreamTracker) SetViewers(source string, viewers int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.viewers[source] = viewers
+	if t.session == nil {
+		return
+	}
+	total := 0
+	for _, v := range t.viewers {
+		total += v
+	}
+	t.session.PeakViewers = max(t.session.PeakViewers, total)
+}
+
+// startSession must be called with the lock held.
+func (t *StreamTracker) startSession() {
+	t.session = &StreamSession{
+		Start: time.Now(),
+		Title: twitchTitle,
+	}
+	streamColor.Println("Stream session started:", t.session.Title)
+	if GetAnnounceConfig().Auto {
+		go func() {
+			time.Sleep(announceDelay)
+			if !t.markAnnounced() {
+				return
+			}
+			err := AnnounceGoLive()
+			if err != nil {
+				streamColor.Println("Couldn't announce the stream:", err)
+			}
+		}()
+	}
+}
+
+// markAnnounced returns true if the current session hasn't been announced yet (and marks it as announced).
+func (t *StreamTracker) markAnnounced() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.session == nil || t.session.Announced {
+		return false
+	}
+	t.session.Announced = true
+	return true
+}
+
+// endSession ends the session, unless the end timer of this generation was stopped in the meantime.
+func (t *StreamTracker) endSession(generation int) {
+	t.mu.Lock()
+	if generation != t.endGeneration {
+		t.mu.Unlock()
+		return
+	}
+	session := t.session
+	t.session = nil
+	t.endTimer = nil
+	clear(t.viewers)
+	t.mu.Unlock()
+	if session == nil {
+		return
+	}
+	end := time.Now()
+	session.End = &end
+	streamColor.Printf("Stream session ended after %s (peak viewers: %d)\n", end.Sub(session.Start).Round(time.Minute), session.PeakViewers)
+	if err := appendStreamSession(session); err != nil {
+		streamColor.Println("Couldn't save stream session:", err)
+	}
+}
+
+func appendStreamSession(session *StreamSession) error {
+	var sessions []*StreamSession
+	bytes, err := os.ReadFile(streamSessionsPath)
+	if err == nil {
+		if err := json.Unmarshal(bytes, &sessions); err != nil {
+			return fmt.Errorf("couldn't parse %s: %w", streamSessionsPath, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	sessions = append(sessions, session)
+	bytes, err = json.MarshalIndent(sessions, "", "\t")
+	if err != nil {
+		return err
+	}
+	return WriteStringToFile(streamSessionsPath, string(bytes))
+}
+
+// StreamStatusPoller polls YouTube for the broadcast status. Twitch status is polled by the stats collector
+// (see pollTwitchStream).
+func StreamStatusPoller() {
+	for {
+		pollYouTubeBroadcast()
+		time.Sleep(streamPollInterval)
+	}
+}
+
+// pollTwitchStream updates the Twitch stream status and returns the number of viewers. Twitch EventSub also
+// delivers stream.online/stream.offline, but polling catches the transitions missed while disconnected. Recent EventSub
+// notifications take precedence over the (cached) GetStreams response.
+func pollTwitchStream() int {
+	viewers := make(chan int, 1)
+	select {
+	case TwitchHelixChannel <- func(client *helix.Client) {
+		if twitchBroadcasterID == "" {
+			viewers <- 0
+			return
+		}
+		resp, err := client.GetStreams(&helix.StreamsParams{UserIDs: []string{twitchBroadcasterID}})
+		if err != nil {
+			twitchColor.Println("Couldn't get stream status:", err)
+			viewers <- 0
+			return
+		}
+		live := len(resp.Data.Streams) > 0 && resp.Data.Streams[0].Type == "live"
+		Stream.SetPolledOnline(StreamSourceTwitch, live)
+		if !live {
+			viewers <- 0
+			return
+		}
+		Stream.SetViewers(StreamSourceTwitch, resp.Data.Streams[0].ViewerCount)
+		viewers <- resp.Data.Streams[0].ViewerCount
+	}:
+	case <-time.After(streamPollTimeout):
+		twitchColor.Println("Couldn't get stream status: Twitch bot is busy")
+		return 0
+	}
+	select {
+	case n := <-viewers:
+		return n
+	case <-time.After(streamPollTimeout):
+		twitchColor.Println("Couldn't get stream status: timed out")
+		return 0
+	}
+}
+
+func pollYouTubeBroadcast() {
+	select {
+	case YouTubeBotChannel <- func(yt *youtube.Service) error {
+		call := yt.LiveBroadcasts.List([]string{"id", "status"})
+		call.Mine(true)
+		resp, err := call.Do()
+		if err != nil {
+			return err
+		}
+		live := false
+		for _, broadcast := range resp.Items {
+			if broadcast.Status.LifeCycleStatus == "live" {
+				live = true
+			}
+		}
+		Stream.SetPolledOnline(StreamSourceYouTube, live)
+		return nil
+	}:
+	case <-time.After(streamPollTimeout):
+		youtubeColor.Println("Couldn't get broadcast status: YouTube bot is busy")
+	}
+}
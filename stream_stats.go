@@ -0,0 +1,268 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/andreykaipov/goobs"
+	"google.golang.org/api/youtube/v3"
+)
+
+// Stream health collector. Samples viewer counts and OBS performance, keeps a few hours of history for the
+// dashboard (dashboard.html) and shows notices on the overlay when something goes wrong during a stream.
+// Thresholds are configured in config/stats.json.
+
+const statsConfigFile = "stats.json"
+
+const (
+	statsInterval = 10 * time.Second
+	// Viewer counts are polled less often to save the API quota.
+	statsViewersInterval = time.Minute
+	statsHistoryLength   = 6 * time.Hour
+)
+
+type StatsConfig struct {
+	// Percentage of frames dropped by the network (stream output) that triggers a warning.
+	DroppedFramesPct float64 `json:"dropped_frames_pct"`
+	// Percentage of frames missed due to rendering lag.
+	RenderLagPct float64 `json:"render_lag_pct"`
+	// Percentage of frames skipped due to encoding lag.
+	EncodingLagPct float64 `json:"encoding_lag_pct"`
+	CPUPct         float64 `json:"cpu_pct"`
+	// Minimum time between two warnings about the same problem.
+	WarnCooldownSeconds int `json:"warn_cooldown_s"`
+}
+
+var defaultStatsConfig = StatsConfig{
+	DroppedFramesPct:    5,
+	RenderLagPct:        5,
+	EncodingLagPct:      5,
+	CPUPct:              90,
+	WarnCooldownSeconds: 120,
+}
+
+type StatsSample struct {
+	Time           time.Time `json:"time"`
+	TwitchViewers  int       `json:"twitch_viewers"`
+	YouTubeViewers int       `json:"youtube_viewers"`
+	// False when OBS isn't connected. OBS fields are zero then.
+	OBS       bool    `json:"obs"`
+	Streaming bool    `json:"streaming"`
+	CPU       float64 `json:"cpu"`
+	FPS       float64 `json:"fps"`
+	// Frame percentages over the last interval.
+	DroppedFramesPct float64 `json:"dropped_frames_pct"`
+	RenderLagPct     float64 `json:"render_lag_pct"`
+	EncodingLagPct   float64 `json:"encoding_lag_pct"`
+	BitrateKbps      float64 `json:"bitrate_kbps"`
+	Congestion       float64 `json:"congestion"`
+}
+
+// Cumulative OBS counters, used to compute per-interval values.
+type obsCounters struct {
+	renderSkipped, renderTotal   float64
+	outputSkipped, outputTotal   float64
+	networkSkipped, networkTotal float64
+	bytes                        float64
+	time                         time.Time
+}
+
+type StatsCollector struct {
+	cfg     StatsConfig
+	mu      sync.Mutex
+	history []StatsSample
+	// Latest viewer counts, updated by pollViewers.
+	twitchViewers, youtubeViewers int
+	// Only accessed from the Run goroutine.
+	counters *obsCounters
+	lastWarn map[string]time.Time
+}
+
+var Stats = &StatsCollector{lastWarn: map[string]time.Time{}}
+
+func (s *StatsCollector) Run() {
+	s.cfg = defaultStatsConfig
+	if err := LoadConfig(statsConfigFile, &s.cfg); err != nil {
+		warn_color.Println("Using default stats config:", err)
+		s.cfg = defaultStatsConfig
+	}
+	go s.pollViewers()
+	for {
+		sample := StatsSample{Time: time.Now()}
+		s.sampleOBS(&sample)
+		s.mu.Lock()
+		sample.TwitchViewers = s.twitchViewers
+		sample.YouTubeViewers = s.youtubeViewers
+		s.history = append(s.history, sample)
+		if cutoff := time.Now().Add(-statsHistoryLength); s.history[0].Time.Before(cutoff) {
+			s.history = s.history[1:]
+		}
+		s.mu.Unlock()
+		Webserver.CallAdmins("StatsSample", sample)
+		s.checkThresholds(sample)
+		time.Sleep(statsInterval)
+	}
+}
+
+func (s *StatsCollector) History() []StatsSample {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]StatsSample(nil), s.history...)
+}
+
+// pollViewers runs separately from the main loop because the Twitch & YouTube bots may be unavailable for a long time.
+// Each platform is polled in its own goroutine, so one of them being down doesn't stop the other.
+func (s *StatsCollector) pollViewers() {
+	go s.pollPlatformViewers(pollYouTubeViewers, &s.youtubeViewers)
+	s.pollPlatformViewers(pollTwitchStream, &s.twitchViewers)
+}
+
+func (s *StatsCollector) pollPlatformViewers(poll func() int, viewers *int) {
+	for {
+		n := poll()
+		s.mu.Lock()
+		*viewers = n
+		s.mu.Unlock()
+		time.Sleep(statsViewersInterval)
+	}
+}
+
+func pollYouTubeViewers() int {
+	viewers := make(chan int, 1)
+	select {
+	case YouTubeBotChannel <- func(yt *youtube.Service) error {
+		if youtubeVideoId == "" {
+			viewers <- 0
+			return nil
+		}
+		resp, err := yt.Videos.List([]string{"liveStreamingDetails"}).Id(youtubeVideoId).Do()
+		if err != nil {
+			viewers <- 0
+			return err
+		}
+		if len(resp.Items) == 0 || resp.Items[0].LiveStreamingDetails == nil {
+			viewers <- 0
+			return nil
+		}
+		viewers <- int(resp.Items[0].LiveStreamingDetails.ConcurrentViewers)
+		return nil
+	}:
+	case <-time.After(streamPollTimeout):
+		youtubeColor.Println("Couldn't get viewer count: YouTube bot is busy")
+		return 0
+	}
+	select {
+	case n := <-viewers:
+		if n > 0 {
+			Stream.SetViewers(StreamSourceYouTube, n)
+		}
+		return n
+	case <-time.After(streamPollTimeout):
+		youtubeColor.Println("Couldn't get viewer count: timed out")
+		return 0
+	}
+}
+
+// sampleOBS fills the OBS part of the sample. Does nothing if OBS doesn't respond quickly.
+func (s *StatsCollector) sampleOBS(sample *StatsSample) {
+	type result struct {
+		counters  obsCounters
+		cpu, fps  float64
+		streaming bool
+		congest   float64
+	}
+	results := make(chan result, 1)
+	fn := func(obs *goobs.Client) error {
+		stats, err := obs.General.GetStats()
+		if err != nil {
+			return err
+		}
+		stream, err := obs.Stream.GetStreamStatus()
+		if err != nil {
+			return err
+		}
+		results <- result{
+			counters: obsCounters{
+				renderSkipped:  stats.RenderSkippedFrames,
+				renderTotal:    stats.RenderTotalFrames,
+				outputSkipped:  stats.OutputSkippedFrames,
+				outputTotal:    stats.OutputTotalFrames,
+				networkSkipped: stream.OutputSkippedFrames,
+				networkTotal:   stream.OutputTotalFrames,
+				bytes:          stream.OutputBytes,
+				time:           time.Now(),
+			},
+			cpu:       stats.CpuUsage,
+			fps:       stats.ActiveFps,
+			streaming: stream.OutputActive,
+			congest:   stream.OutputCongestion,
+		}
+		return nil
+	}
+	select {
+	case OBSChannel <- fn:
+	case <-time.After(2 * time.Second):
+		s.counters = nil
+		return
+	}
+	var r result
+	select {
+	case r = <-results:
+	case <-time.After(2 * time.Second):
+		s.counters = nil
+		return
+	}
+	sample.OBS = true
+	sample.Streaming = r.streaming
+	sample.CPU = r.cpu
+	sample.FPS = r.fps
+	sample.Congestion = r.congest
+	if prev := s.counters; prev != nil {
+		sample.RenderLagPct = framePct(r.counters.renderSkipped-prev.renderSkipped, r.counters.renderTotal-prev.renderTotal)
+		sample.EncodingLagPct = framePct(r.counters.outputSkipped-prev.outputSkipped, r.counters.outputTotal-prev.outputTotal)
+		sample.DroppedFramesPct = framePct(r.counters.networkSkipped-prev.networkSkipped, r.counters.networkTotal-prev.networkTotal)
+		if seconds := r.counters.time.Sub(prev.time).Seconds(); seconds > 0 && r.counters.bytes >= prev.bytes {
+			sample.BitrateKbps = (r.counters.bytes - prev.bytes) * 8 / 1000 / seconds
+		}
+	}
+	s.counters = &r.counters
+}
+
+func framePct(skipped, total float64) float64 {
+	if total <= 0 || skipped < 0 {
+		return 0
+	}
+	return skipped / total * 100
+}
+
+func (s *StatsCollector) checkThresholds(sample StatsSample) {
+	if !sample.Streaming {
+		return
+	}
+	s.warnIf("dropped", sample.DroppedFramesPct > s.cfg.DroppedFramesPct,
+		fmt.Sprintf("⚠️ Dropping %.1f%% of frames - check the network", sample.DroppedFramesPct))
+	s.warnIf("render", sample.RenderLagPct > s.cfg.RenderLagPct,
+		fmt.Sprintf("⚠️ Rendering lag: %.1f%% of frames missed", sample.RenderLagPct))
+	s.warnIf("encoding", sample.EncodingLagPct > s.cfg.EncodingLagPct,
+		fmt.Sprintf("⚠️ Encoding lag: %.1f%% of frames skipped", sample.EncodingLagPct))
+	s.warnIf("cpu", sample.CPU > s.cfg.CPUPct,
+		fmt.Sprintf("⚠️ OBS CPU usage at %.0f%%", sample.CPU))
+}
+
+func (s *StatsCollector) warnIf(key string, condition bool, message string) {
+	if !condition {
+		return
+	}
+	cooldown := time.Duration(s.cfg.WarnCooldownSeconds) * time.Second
+	if time.Since(s.lastWarn[key]) < cooldown {
+		return
+	}
+	s.lastWarn[key] = time.Now()
+	warn_color.Println(message)
+	Webserver.CallTopic(TopicNotices, "ShowNotice", message)
+}
+
+func GetStatsHistoryHandler(c *WebsocketClient) []StatsSample {
+	return Stats.History()
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Recurring chat announcements. A timer fires every IntervalMinutes while the stream is live, but only if the chat
+// received at least MinMessages since its last announcement - so the bot doesn't talk to an empty room.
+// Configured in config/timers.json. Timers can be enabled & disabled from the control panel.
+
+const timersConfigFile = "timers.json"
+
+const timersCheckInterval = 30 * time.Second
+
+type TimerConfig struct {
+	Name            string `json:"name"`
+	Message         string `json:"message"`
+	IntervalMinutes int    `json:"interval_min"`
+	MinMessages     int    `json:"min_messages"`
+	// Platforms from ChatPlatforms. Empty means all of them.
+	Platforms []string `json:"platforms,omitempty"`
+	// Read the message with the narrator voice.
+	TTS     bool `json:"tts"`
+	Enabled bool `json:"enabled"`
+}
+
+var defaultTimers = []TimerConfig{
+	{
+		Name:            "discord",
+		Message:         "👾 Join the Discord: https://discord.com/invite/MRfuBBvdjV",
+		IntervalMinutes: 30,
+		MinMessages:     10,
+		Enabled:         true,
+	},
+	{
+		Name:            "english",
+		Message:         "🗣️ TTS reads chat messages, but only in English. Please use English in chat!",
+		IntervalMinutes: 45,
+		MinMessages:     15,
+		Platforms:       []string{PlatformTwitch, PlatformYouTube},
+		Enabled:         true,
+	},
+	{
+		Name:            "github",
+		Message:         "🌟 Star Automat on GitHub: https://github.com/mafik/automat",
+		IntervalMinutes: 40,
+		MinMessages:     10,
+		Enabled:         true,
+	},
+}
+
+type Timer struct {
+	TimerConfig
+	// Chat messages since the last announcement.
+	Messages  int       `json:"messages"`
+	LastFired time.Time `json:"last_fired"`
+}
+
+// Only accessed from the main thread.
+var timers []*Timer
+
+// LoadTimers reads the timers config. Run this only on the main thread!
+func LoadTimers() {
+	configs := defaultTimers
+	if err := LoadConfig(timersConfigFile, &configs); err != nil {
+		warn_color.Println("Using default timers:", err)
+		configs = defaultTimers
+	}
+	timers = nil
+	for _, cfg := range configs {
+		// Timers don't fire right after the start of the bot
+		timers = append(timers, &Timer{TimerConfig: cfg, LastFired: time.Now()})
+	}
+}
+
+func saveTimers() {
+	var configs []TimerConfig
+	for _, timer := range timers {
+		configs = append(configs, timer.TimerConfig)
+	}
+	if err := SaveConfig(timersConfigFile, configs); err != nil {
+		warn_color.Println("Couldn't save timers:", err)
+	}
+}
+
+// OnTimersChatMessage counts the chat messages for the timers. Run this only on the main thread!
+func OnTimersChatMessage(t ChatEntry) {
+	if t.Author.BotUser != nil {
+		return
+	}
+	for _, timer := range timers {
+		timer.Messages++
+	}
+}
+
+func (timer *Timer) Due() bool {
+	interval := time.Duration(timer.IntervalMinutes) * time.Minute
+	return timer.Enabled && interval > 0 && time.Since(timer.LastFired) >= interval && timer.Messages >= timer.MinMessages
+}
+
+func (timer *Timer) Fire() {
+	timer.Messages = 0
+	timer.LastFired = time.Now()
+	fmt.Printf("Timer %q: %s\n", timer.Name, timer.Message)
+	SendChat(timer.Message, timer.Platforms...)
+	if timer.TTS && !Narrate(timer.Message, "") {
+		fmt.Println("TTS channel is full, dropping message")
+	}
+}
+
+// runTimers fires the timers that are due. Only one timer fires at a time. Run this only on the main thread!
+func runTimers() {
+	if !Stream.IsLive() {
+		return
+	}
+	for _, timer := range timers {
+		if timer.Due() {
+			timer.Fire()
+			Webserver.CallAdmins("Timers", timers)
+			return
+		}
+	}
+}
+
+func TimersTicker() {
+	for {
+		time.Sleep(timersCheckInterval)
+		MainChannel <- runTimers
+	}
+}
+
+// Websocket handlers
+
+func ListTimersHandler(c *WebsocketClient) []Timer {
+	return onMainThread(func() []Timer {
+		// Copies, because the result is marshalled outside of the main thread
+		var list []Timer
+		for _, timer := range timers {
+			list = append(list, *timer)
+		}
+		return list
+	})
+}
+
+func SetTimerEnabledHandler(c *WebsocketClient, name string, enabled bool) error {
+	return onMainThread(func() error {
+		for _, timer := range timers {
+			if timer.Name == name {
+				timer.Enabled = enabled
+				if enabled {
+					// Start counting from now, instead of firing immediately
+					timer.LastFired = time.Now()
+					timer.Messages = 0
+				}
+				saveTimers()
+				Webserver.CallAdmins("Timers", timers)
+				return nil
+			}
+		}
+		return fmt.Errorf("unknown timer %q", name)
+	})
+}
+
+func FireTimerHandler(c *WebsocketClient, name string) error {
+	return onMainThread(func() error {
+		for _, timer := range timers {
+			if timer.Name == name {
+				timer.Fire()
+				Webserver.CallAdmins("Timers", timers)
+				return nil
+			}
+		}
+		return fmt.Errorf("unknown timer %q", name)
+	})
+}
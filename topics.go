@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Topics of the broadcasts. Pages that only show one widget (for example a separate OBS browser source for alerts)
+// connect to /ws?topics=alerts,polls and only receive those broadcasts - and the initial state of those topics.
+// Clients that don't choose any topics receive everything.
+//
+// Calls made directly on a client (c.Call) and Webserver.Call (for example "Reload") ignore the topics.
+const (
+	// OnChatMessage
+	TopicChat = "chat"
+	// ShowAlert
+	TopicAlerts = "alerts"
+	// SetAudioMessage & SetStreamTitle
+	TopicNowPlaying = "now-playing"
+	// PollUpdate
+	TopicPolls = "polls"
+	// Ping & Pong of the platform connections
+	TopicHealth = "health"
+	// ShowNotice - warnings for the streamer, for example about dropped frames
+	TopicNotices = "notices"
+	// Everything sent with CallAdmins. Only delivered to admins.
+	TopicAdmin = "admin"
+)
+
+var Topics = []string{TopicChat, TopicAlerts, TopicNowPlaying, TopicPolls, TopicHealth, TopicNotices, TopicAdmin}
+
+// ParseTopics parses a comma-separated list of topics. An empty list means all topics.
+func ParseTopics(list string) ([]string, error) {
+	var topics []string
+	for _, topic := range strings.Split(list, ",") {
+		topic = strings.TrimSpace(topic)
+		if topic != "" {
+			topics = append(topics, topic)
+		}
+	}
+	if err := checkTopics(topics); err != nil {
+		return nil, err
+	}
+	return topics, nil
+}
+
+func checkTopics(topics []string) error {
+	for _, topic := range topics {
+		if !slices.Contains(Topics, topic) {
+			return fmt.Errorf("unknown topic %q", topic)
+		}
+	}
+	return nil
+}
+
+// SetTopics limits the broadcasts received by the client. No topics means all of them.
+func (c *WebsocketClient) SetTopics(topics []string) {
+	if len(topics) == 0 {
+		c.topics.Store(nil)
+		return
+	}
+	set := map[string]bool{}
+	for _, topic := range topics {
+		set[topic] = true
+	}
+	c.topics.Store(&set)
+}
+
+// Wants returns true if the client receives the broadcasts of the topic.
+func (c *WebsocketClient) Wants(topic string) bool {
+	if topic == TopicAdmin && !c.admin {
+		return false
+	}
+	set := c.topics.Load()
+	return set == nil || (*set)[topic]
+}
+
+// CallTopic calls the function on the client, if it's subscribed to the topic. Used to send the initial state.
+func (c *WebsocketClient) CallTopic(topic string, function_name string, args ...interface{}) {
+	if c.Wants(topic) {
+		c.Call(function_name, args...)
+	}
+}
+
+// Websocket handlers
+
+// SubscribeHandler replaces the topics of the client. Unlike ?topics=, it doesn't resend the initial state.
+func SubscribeHandler(c *WebsocketClient, topics []string) error {
+	if err := checkTopics(topics); err != nil {
+		return err
+	}
+	c.SetTopics(topics)
+	return nil
+}
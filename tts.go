@@ -1,6 +1,7 @@
 package main
 
 import (
+	"cmp"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -69,7 +70,42 @@ func helloWorldRequest() *http.Request {
 
 var ttsColor = color.New(color.FgBlue)
 
-var TTSChannel = make(chan interface{}, 10)
+// TTSJob is a ChatEntry to read, an Alert to narrate or a TTSFunc to run on the TTS thread.
+type TTSJob interface {
+	isTTSJob()
+}
+
+type TTSFunc func()
+
+func (ChatEntry) isTTSJob() {}
+func (Alert) isTTSJob()     {}
+func (TTSFunc) isTTSJob()   {}
+
+var TTSChannel = make(chan TTSJob, 10)
+
+// Narrate queues the text to be read without introducing an author, in the narrator voice unless another voice is
+// given. Returns false when the TTS queue is full.
+func Narrate(text, voice string) bool {
+	entry := ChatEntry{
+		Author: User{Voice: cmp.Or(voice, narratorVoiceCfg)},
+		ttsMsg: text,
+	}
+	select {
+	case TTSChannel <- entry:
+		return true
+	default:
+		return false
+	}
+}
+
+// TTSVoices returns the voices available in AllTalk. Waits for the TTS thread.
+func TTSVoices() []string {
+	voicesChan := make(chan []string, 1)
+	TTSChannel <- TTSFunc(func() {
+		voicesChan <- voices
+	})
+	return <-voicesChan
+}
 
 var htmlTagRegexp = regexp.MustCompile(`<[^>]*>`)
 var urlRegexp = regexp.MustCompile(`(https?://[^\s]+)`)
@@ -190,6 +226,7 @@ func TTS() {
 			Description: "TTS",
 		}
 		muted = readMuted()
+		ttsMarkupAllowed = readUserSet("tts_markup.txt")
 		var kittyPid string
 		defer func() {
 			if kittyPid != "" {
@@ -263,19 +300,33 @@ func TTS() {
 						userVoice = author.Voice
 					}
 
-					message := VocalizeHTML(t.ttsMsg)
-					var r *http.Request
-					if lastAuthor == authorKey {
-						r = ttsGenerateRequest(fmt.Sprintf("\"%s\"", message), userVoice, narratorVoiceCfg)
-					} else {
-						r = ttsGenerateRequest(fmt.Sprintf("* %s says: * \" %s \"", author.GetNamePronunciation(), message), userVoice, narratorVoiceCfg)
-						lastAuthor = authorKey
+					ttsMsg := t.ttsMsg
+					if !CanUseTTSMarkup(t.Author) {
+						ttsMsg = StripTTSMarkup(ttsMsg)
 					}
-					wav, err := SynthesizeAllTalk(r)
+					replyingTo := ""
+					if t.ReplyTo != nil && t.ReplyTo.Author != nil {
+						replyingTo = t.ReplyTo.Author.LoadSettings().GetNamePronunciation()
+					}
+					intro := ""
+					// Narrated messages (timers, API) have no author to introduce
+					if authorKey != "" && lastAuthor != authorKey {
+						intro = fmt.Sprintf("%s says:", author.GetNamePronunciation())
+						if replyingTo != "" {
+							intro = fmt.Sprintf("%s, replying to %s, says:", author.GetNamePronunciation(), replyingTo)
+						}
+					} else if replyingTo != "" {
+						intro = fmt.Sprintf("Replying to %s:", replyingTo)
+					}
+					lastAuthor = authorKey
+					wav, err := synthesizeSegments(intro, ParseTTSMarkup(ttsMsg), userVoice)
 					if err != nil {
 						ttsColor.Println("AllTalk error:", err)
 						break
 					}
+					if wav == nil {
+						break
+					}
 					select {
 					case AudioPlayerChannel <- PlayMessage{
 						wavData: wav,
@@ -295,12 +346,13 @@ func TTS() {
 					select {
 					case AudioPlayerChannel <- PlayMessage{
 						wavData: wav,
+						bus:     BusAlerts,
 						prePlay: func() {
 							durationMillis := WAVDuration(wav).Milliseconds()
 							if t.onPlay != nil {
 								t.onPlay()
 							}
-							Webserver.Call("ShowAlert", t.HTML, durationMillis)
+							Webserver.CallTopic(TopicAlerts, "ShowAlert", SanitizeHTML(t.HTML), durationMillis)
 							// block audio playback for 1 second (until alert window opens)
 							time.Sleep(time.Second)
 						},
@@ -311,7 +363,7 @@ func TTS() {
 					default:
 						ttsColor.Println("Player is busy, dropping TTS message")
 					}
-				case func():
+				case TTSFunc:
 					t()
 				}
 			}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Chat messages may contain inline TTS markup:
+//
+//	(voice:narrator) Once upon a time... (voice:SMOrc) Zug zug! [sfx:airhorn]
+//
+// `(voice:NAME)` switches the voice for the rest of the message (or until the next voice tag).
+// `[sfx:NAME]` plays a sound clip from the sounds directory at that point.
+// Only users with the markup permission can use it. For everyone else the tags are stripped.
+
+var ttsMarkupRegexp = regexp.MustCompile(`\(voice:([^)\s]+)\)|\[sfx:([^\]\s]+)\]`)
+var soundNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+const maxTTSSegments = 8
+
+var soundsDir = path.Join(baseDir, "static", "sounds")
+
+// TTSSegment is a piece of a chat message that is synthesized with a single voice or
+// replaced by a sound clip. Exactly one of Text or Sound is set.
+type TTSSegment struct {
+	Voice string // voice tag as written by the user (empty means the author's voice)
+	Text  string
+	Sound string
+}
+
+// ParseTTSMarkup splits a message into segments. Empty text segments are skipped.
+func ParseTTSMarkup(message string) []TTSSegment {
+	var segments []TTSSegment
+	voice := ""
+	appendText := func(text string) {
+		if strings.TrimSpace(text) == "" {
+			return
+		}
+		segments = append(segments, TTSSegment{Voice: voice, Text: text})
+	}
+	last := 0
+	for _, match := range ttsMarkupRegexp.FindAllStringSubmatchIndex(message, -1) {
+		appendText(message[last:match[0]])
+		last = match[1]
+		if match[2] != -1 {
+			voice = message[match[2]:match[3]]
+		} else {
+			segments = append(segments, TTSSegment{Sound: message[match[4]:match[5]]})
+		}
+	}
+	appendText(message[last:])
+	return segments
+}
+
+// StripTTSMarkup removes all markup tags from the message.
+func StripTTSMarkup(message string) string {
+	return ttsMarkupRegexp.ReplaceAllString(message, "")
+}
+
+// FindVoice resolves a voice tag to one of the AllTalk voices. Should only be called from the TTS thread.
+func FindVoice(name string) (string, bool) {
+	if strings.EqualFold(name, "narrator") {
+		return narratorVoiceCfg, true
+	}
+	for _, voice := range voices {
+		if voice == name || strings.EqualFold(strings.TrimSuffix(voice, filepath.Ext(voice)), name) {
+			return voice, true
+		}
+	}
+	return "", false
+}
+
+// LoadSoundClip reads a WAV file from the sounds directory.
+func LoadSoundClip(name string) ([]byte, error) {
+	if !soundNameRegexp.MatchString(name) {
+		return nil, fmt.Errorf("invalid sound name %q", name)
+	}
+	wav, err := os.ReadFile(path.Join(soundsDir, name+".wav"))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read sound %s: %w", name, err)
+	}
+	return wav, nil
+}
+
+// Users who are allowed to use TTS markup. Keyed by User.Key().
+var ttsMarkupAllowed *sync.Map
+
+func CanUseTTSMarkup(user User) bool {
+	if ttsMarkupAllowed == nil {
+		return false
+	}
+	_, allowed := ttsMarkupAllowed.Load(user.Key())
+	return allowed
+}
+
+func ToggleTTSMarkup(c *WebsocketClient, user User) {
+	key := user.Key()
+	if _, allowed := ttsMarkupAllowed.Load(key); allowed {
+		chat_color.Println("Disallowing TTS markup for", user.DisplayName())
+		ttsMarkupAllowed.Delete(key)
+	} else {
+		chat_color.Println("Allowing TTS markup for", user.DisplayName())
+		user.BotUser = nil
+		ttsMarkupAllowed.Store(key, user)
+	}
+	saveUserSet("tts_markup.txt", ttsMarkupAllowed)
+}
+
+// synthesizeSegments turns the segments of a chat message into a single WAV file.
+// The intro (e.g. "X says:") is spoken by the narrator before the first segment.
+// Returns nil without an error if there's nothing to say (for example the message had only unknown sound tags).
+// Should only be called from the TTS thread.
+func synthesizeSegments(intro string, segments []TTSSegment, authorVoice string) ([]byte, error) {
+	if len(segments) == 0 {
+		return nil, nil
+	}
+	if len(segments) > maxTTSSegments {
+		segments = segments[:maxTTSSegments]
+	}
+	var wavs [][]byte
+	if intro != "" && segments[0].Sound != "" {
+		wav, err := SynthesizeAllTalk(ttsGenerateRequest(fmt.Sprintf("* %s *", intro), authorVoice, narratorVoiceCfg))
+		if err != nil {
+			return nil, err
+		}
+		wavs = append(wavs, wav)
+	}
+	for i, segment := range segments {
+		if segment.Sound != "" {
+			wav, err := LoadSoundClip(segment.Sound)
+			if err != nil {
+				ttsColor.Println(err)
+				continue
+			}
+			wavs = append(wavs, wav)
+			continue
+		}
+		voice := authorVoice
+		narrated := false
+		if segment.Voice != "" {
+			if found, ok := FindVoice(segment.Voice); ok {
+				voice = found
+				narrated = found == narratorVoiceCfg
+			}
+		}
+		message := VocalizeHTML(segment.Text)
+		var text string
+		if narrated {
+			text = fmt.Sprintf("* %s *", message)
+		} else {
+			text = fmt.Sprintf("\"%s\"", message)
+		}
+		if i == 0 && intro != "" {
+			text = fmt.Sprintf("* %s * %s", intro, text)
+		}
+		wav, err := SynthesizeAllTalk(ttsGenerateRequest(text, voice, narratorVoiceCfg))
+		if err != nil {
+			return nil, err
+		}
+		wavs = append(wavs, wav)
+	}
+	if len(wavs) == 0 {
+		return nil, nil
+	}
+	return ConcatWAV(wavs), nil
+}
@@ -5,7 +5,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"html"
+	"strconv"
 	"streambot/backoff"
+	"strings"
 
 	"github.com/gorilla/websocket"
 	"github.com/nicklaw5/helix/v2"
@@ -66,6 +68,36 @@ type TwitchRaidNotification struct {
 	} `json:"payload"`
 }
 
+// https://dev.twitch.tv/docs/eventsub/eventsub-subscription-types/#channelchannel_points_custom_reward_redemptionadd
+type TwitchRedemptionNotification struct {
+	Payload struct {
+		Event struct {
+			ID        string `json:"id"`
+			UserID    string `json:"user_id"`
+			UserLogin string `json:"user_login"`
+			UserName  string `json:"user_name"`
+			UserInput string `json:"user_input"`
+			Status    string `json:"status"`
+			Reward    struct {
+				ID     string `json:"id"`
+				Title  string `json:"title"`
+				Cost   int    `json:"cost"`
+				Prompt string `json:"prompt"`
+			} `json:"reward"`
+		} `json:"event"`
+	} `json:"payload"`
+}
+
+// https://dev.twitch.tv/docs/eventsub/eventsub-subscription-types/#streamonline
+type TwitchStreamOnlineNotification struct {
+	Payload struct {
+		Event struct {
+			Type      string `json:"type"` // "live", "playlist", "watch_party", "premiere" or "rerun"
+			StartedAt string `json:"started_at"`
+		} `json:"event"`
+	} `json:"payload"`
+}
+
 // https://dev.twitch.tv/docs/eventsub/eventsub-subscription-types/#channelchatmessage
 type TwitchChatMessageNotification struct {
 	Payload struct {
@@ -134,7 +166,7 @@ func TwitchEventSub() {
 	}
 	for {
 		backoff.Attempt()
-		Webserver.Call("Ping", "Twitch")
+		Webserver.CallTopic(TopicHealth, "Ping", "Twitch")
 		c, _, err := websocket.DefaultDialer.Dial("wss://eventsub.wss.twitch.tv/ws", nil)
 		if err != nil {
 			twitchColor.Println("dial:", err)
@@ -167,7 +199,7 @@ func TwitchEventSub() {
 						twitchColor.Println("Twitch EventSub configure ERROR:", err)
 						return
 					}
-					Webserver.Call("Pong", "Twitch")
+					Webserver.CallTopic(TopicHealth, "Pong", "Twitch")
 				case "notification":
 					var generic_notification TwitchNotification
 					err = json.Unmarshal(bytes, &generic_notification)
@@ -184,15 +216,16 @@ func TwitchEventSub() {
 							return
 						}
 						event := notification.Payload.Event
+						Events.Publish(Follow{User: User{TwitchUser: &TwitchUser{TwitchID: event.UserID, Login: event.UserLogin, Name: event.UserName}}})
 						TTSChannel <- Alert{
-							HTML: fmt.Sprintf(`<div class="big">%s</div>Just followed on Twitch!`, event.UserName),
+							HTML: fmt.Sprintf(`<div class="big">%s</div>Just followed on Twitch!`, html.EscapeString(event.UserName)),
 							onPlay: func() {
 								author := User{TwitchUser: &TwitchUser{TwitchID: event.UserID, Login: event.UserLogin, Name: event.UserName}, BotUser: &BotUser{}}
-								MainChannel <- ChatEntry{
+								Events.Publish(ChatMessage{Entry: ChatEntry{
 									HTML:        fmt.Sprintf("%s 💜 just followed on Twitch!", author.HTML()),
 									terminalMsg: fmt.Sprintf("  %s 💜 just followed on Twitch!\n", author.DisplayName()),
 									Author:      author,
-								}
+								}})
 							},
 						}
 					case "channel.raid":
@@ -203,17 +236,47 @@ func TwitchEventSub() {
 							return
 						}
 						event := notification.Payload.Event
+						Events.Publish(Raid{
+							From:    User{TwitchUser: &TwitchUser{TwitchID: event.FromBroadcasterUserID, Login: event.FromBroadcasterUserLogin, Name: event.FromBroadcasterUserName}},
+							Viewers: event.Viewers,
+						})
 						TTSChannel <- Alert{
-							HTML: fmt.Sprintf(`<div class="big">%s</div>is raiding with %d viewers!`, event.FromBroadcasterUserName, event.Viewers),
+							HTML: fmt.Sprintf(`<div class="big">%s</div>is raiding with %d viewers!`, html.EscapeString(event.FromBroadcasterUserName), event.Viewers),
 							onPlay: func() {
 								author := User{TwitchUser: &TwitchUser{TwitchID: event.FromBroadcasterUserID, Login: event.FromBroadcasterUserLogin, Name: event.FromBroadcasterUserName}, BotUser: &BotUser{}}
-								MainChannel <- ChatEntry{
+								Events.Publish(ChatMessage{Entry: ChatEntry{
 									HTML:        fmt.Sprintf(TWITCH_ICON+" %s 🚨 is raiding with %d viewers!", author.HTML(), event.Viewers),
 									terminalMsg: fmt.Sprintf("  %s 🚨 is raiding with %d viewers!\n", author.DisplayName(), event.Viewers),
 									Author:      author,
-								}
+								}})
 							},
 						}
+					case "channel.channel_points_custom_reward_redemption.add":
+						var notification TwitchRedemptionNotification
+						err = json.Unmarshal(bytes, &notification)
+						if err != nil {
+							twitchColor.Println("Twitch EventSub cannot unmarshal redemption:", err, string(bytes))
+							return
+						}
+						event := notification.Payload.Event
+						twitchColor.Printf("%s redeemed \"%s\"\n", event.UserName, event.Reward.Title)
+						Events.Publish(Redemption{
+							User:   User{TwitchUser: &TwitchUser{TwitchID: event.UserID, Login: event.UserLogin, Name: event.UserName}},
+							Reward: event.Reward.Title,
+							Input:  event.UserInput,
+						})
+					case "stream.online":
+						var notification TwitchStreamOnlineNotification
+						err = json.Unmarshal(bytes, &notification)
+						if err != nil {
+							twitchColor.Println("Twitch EventSub cannot unmarshal stream.online:", err, string(bytes))
+							return
+						}
+						if notification.Payload.Event.Type == "live" {
+							Stream.SetOnline(StreamSourceTwitch, true)
+						}
+					case "stream.offline":
+						Stream.SetOnline(StreamSourceTwitch, false)
 					case "channel.chat.message":
 						var chat_message_notification TwitchChatMessageNotification
 						err = json.Unmarshal(bytes, &chat_message_notification)
@@ -238,26 +301,44 @@ func TwitchEventSub() {
 							},
 							OriginalMessage: event.Message.Text,
 							TwitchMessageID: event.MessageID,
+							Platform:        PlatformTwitch,
+						}
+						for _, badge := range event.Badges {
+							addTwitchBadge(&entry, badge.SetID, badge.ID, badge.Info)
+						}
+						if reply := event.Reply; reply != nil {
+							entry.ReplyTo = &ChatReply{
+								MessageID: reply.ParentMessageID,
+								Author: &User{
+									TwitchUser: &TwitchUser{
+										TwitchID: reply.ParentUserID,
+										Login:    reply.ParentUserLogin,
+										Name:     reply.ParentUserName,
+									},
+								},
+								Text: replySnippet(reply.ParentMessageBody),
+							}
 						}
-
-						entry.terminalMsg = fmt.Sprintf("  %s: ", entry.Author.DisplayName())
-						entry.HTML = fmt.Sprintf(TWITCH_ICON+` %s: `, entry.Author.HTML())
-						entry.ttsMsg = ""
 
 						for _, fragment := range event.Message.Fragments {
 							switch fragment.Type {
 							case "text":
-								entry.terminalMsg += fragment.Text
-								entry.HTML += html.EscapeString(fragment.Text)
-								entry.ttsMsg += fragment.Text
-								entry.textOnly += fragment.Text
+								entry.AppendText(fragment.Text)
 							case "cheermote":
-								entry.terminalMsg += fmt.Sprintf("CHEER(prefix=%s, bits=%d tier=%d)", fragment.Cheermote.Prefix, fragment.Cheermote.Bits, fragment.Cheermote.Tier)
-								entry.HTML += fmt.Sprintf("TODO: support cheermotes (prefix=%s, bits=%d tier=%d)", fragment.Cheermote.Prefix, fragment.Cheermote.Bits, fragment.Cheermote.Tier)
-								entry.ttsMsg += fmt.Sprintf("* Cheered %d bits *", fragment.Cheermote.Bits)
+								entry.AppendFragment(ChatFragment{
+									Type: FragmentCheermote,
+									Text: fragment.Text,
+									URL:  fmt.Sprintf("https://d3aqoihi2n8ty8.cloudfront.net/actions/%s/dark/animated/%d/2.gif", strings.ToLower(fragment.Cheermote.Prefix), fragment.Cheermote.Tier),
+									Bits: fragment.Cheermote.Bits,
+								})
 							case "emote":
-								entry.terminalMsg += fmt.Sprintf("[%s]", fragment.Text)
-								entry.HTML += fmt.Sprintf("<img title=\"%s\" class=\"emoji\" src=\"https://static-cdn.jtvnw.net/emoticons/v2/%s/default/light/1.0\" srcset=\"https://static-cdn.jtvnw.net/emoticons/v2/%s/default/light/1.0 1x,https://static-cdn.jtvnw.net/emoticons/v2/%s/default/light/2.0 2x,https://static-cdn.jtvnw.net/emoticons/v2/%s/default/light/3.0 4x\">", fragment.Text, fragment.Emote.ID, fragment.Emote.ID, fragment.Emote.ID, fragment.Emote.ID)
+								emoteURL := "https://static-cdn.jtvnw.net/emoticons/v2/" + fragment.Emote.ID + "/default/light/"
+								entry.AppendFragment(ChatFragment{
+									Type:   FragmentEmote,
+									Text:   fragment.Text,
+									URL:    emoteURL + "1.0",
+									SrcSet: emoteURL + "1.0 1x," + emoteURL + "2.0 2x," + emoteURL + "3.0 4x",
+								})
 							case "mention":
 								mention := User{
 									TwitchUser: &TwitchUser{
@@ -266,53 +347,27 @@ func TwitchEventSub() {
 										Name:     fragment.Mention.UserName,
 									},
 								}
-								entry.terminalMsg += fragment.Text
-								entry.HTML += "@" + mention.HTML()
-								entry.ttsMsg += mention.DisplayName()
+								entry.AppendFragment(ChatFragment{Type: FragmentMention, Text: fragment.Text, User: &mention})
 							}
 						}
-						entry.terminalMsg += "\n"
+						entry.Render()
 
-						// Keeping the string-replacement code for the eventual possibility that custom (non-Twitch) emotes will be added
-						/*
-							i := 0
-							for i < len(entry.HTML) {
-								replaced := false
-								if i == 0 || entry.HTML[i-1] == ' ' {
-									for emote, url := range *emotes {
-										if i+len(emote) >= len(entry.HTML)-1 || entry.HTML[i+len(emote)] == ' ' {
-											if strings.HasPrefix(entry.HTML[i:], emote) {
-												tag := `<img src="` + url + `" class="emoji" />`
-												entry.HTML = entry.HTML[:i] + tag + entry.HTML[i+len(emote):]
-												i += len(tag)
-												replaced = true
-												break
-											}
-										}
-									}
-								}
-								if !replaced {
-									i++
-								}
-							}
-						*/
-
-						MainChannel <- entry
+						Events.Publish(ChatMessage{Entry: entry})
 
 					default:
 						twitchColor.Println("Twitch EventSub unknown notification type:", generic_notification.Payload.Subscription.Type)
 					}
 
 				case "session_keepalive":
-					Webserver.Call("Ping", "Twitch")
-					Webserver.Call("Pong", "Twitch")
+					Webserver.CallTopic(TopicHealth, "Ping", "Twitch")
+					Webserver.CallTopic(TopicHealth, "Pong", "Twitch")
 					// nothing to do
 				default:
 					twitchColor.Println("Twitch EventSub unknown message: ", string(bytes))
 				}
 			}
 		}()
-		Webserver.Call("Ping", "Twitch")
+		Webserver.CallTopic(TopicHealth, "Ping", "Twitch")
 	}
 }
 
@@ -378,6 +433,21 @@ func TwitchEventsSubscribeKnown(sessionID string) error {
 					ToBroadcasterUserID: twitchBroadcasterID,
 				},
 			},
+			{"channel.channel_points_custom_reward_redemption.add", "1",
+				helix.EventSubCondition{
+					BroadcasterUserID: twitchBroadcasterID,
+				},
+			},
+			{"stream.online", "1",
+				helix.EventSubCondition{
+					BroadcasterUserID: twitchBroadcasterID,
+				},
+			},
+			{"stream.offline", "1",
+				helix.EventSubCondition{
+					BroadcasterUserID: twitchBroadcasterID,
+				},
+			},
 			{"channel.chat.message", "1",
 				helix.EventSubCondition{
 					BroadcasterUserID: twitchBroadcasterID,
@@ -397,3 +467,27 @@ func TwitchEventsSubscribeKnown(sessionID string) error {
 	}
 	return <-errChan
 }
+
+// addTwitchBadge shows the badge next to the name of the author and adds the role that comes with it.
+func addTwitchBadge(entry *ChatEntry, setID, id, info string) {
+	badge := ChatBadge{SetID: setID, ID: id, Info: info}
+	if url, ok := twitchBadgeImages.Load(setID + "/" + id); ok {
+		badge.URL = url.(string)
+	}
+	entry.Badges = append(entry.Badges, badge)
+	switch setID {
+	case "broadcaster":
+		entry.AddRole(RoleOwner)
+	case "moderator", "lead_moderator":
+		entry.AddRole(RoleModerator)
+	case "vip":
+		entry.AddRole(RoleVIP)
+	case "subscriber", "founder":
+		entry.AddRole(RoleSubscriber)
+		// Version IDs of the subscriber badges are the months, or 2000+ & 3000+ for tier 2 & 3.
+		entry.SubscriberTier = 1
+		if n, err := strconv.Atoi(id); err == nil && n >= 2000 {
+			entry.SubscriberTier = n / 1000
+		}
+	}
+}
@@ -1,12 +1,12 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"net"
 	"net/http"
 	"path"
 	"streambot/backoff"
+	"sync"
 	"time"
 
 	"github.com/fatih/color"
@@ -64,16 +64,16 @@ func OnTwitchAuth(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func Ban(c *WebsocketClient, args ...json.RawMessage) {
-	if !c.admin {
-		return
-	}
-	var user User
-	err := json.Unmarshal(args[0], &user)
-	if err != nil {
-		twitchColor.Println("Couldn't unmarshal user:", err)
-		return
+func Ban(c *WebsocketClient, user User) error {
+	if user.TwitchUser == nil {
+		return fmt.Errorf("only Twitch users can be banned")
 	}
+	BanUser(user)
+	return nil
+}
+
+// BanUser bans the user on Twitch. Users from other platforms are ignored.
+func BanUser(user User) {
 	if user.TwitchUser != nil {
 		TwitchHelixChannel <- func(client *helix.Client) {
 			_, err := client.BanUser(&helix.BanUserParams{
@@ -89,17 +89,22 @@ func Ban(c *WebsocketClient, args ...json.RawMessage) {
 				return
 			}
 			twitchColor.Println("Banned", user.DisplayName())
+			banned := user
 			user.BotUser = &BotUser{}
-			MainChannel <- ChatEntry{
-				Author: user,
-				HTML:   fmt.Sprintf(BOT_ICON+` 💀 %s`, user.HTML()),
-			}
+			// The main thread may be waiting for this thread (SendChat), so don't wait for it here
+			Events.PublishAsync(
+				ModerationAction{Action: ModerationBan, Target: banned},
+				ChatMessage{Entry: ChatEntry{
+					Author: user,
+					HTML:   fmt.Sprintf(BOT_ICON+` 💀 %s`, user.HTML()),
+				}},
+			)
 		}
 	}
 }
 
 var twitchTitle string
-var TwitchHelixChannel = make(chan interface{}, 100)
+var TwitchHelixChannel = make(chan func(*helix.Client), 100)
 
 func TwitchHelixBot() {
 	backoff := backoff.Backoff{
@@ -164,7 +169,7 @@ func TwitchHelixBot() {
 		client.OnUserAccessTokenRefreshed(OnUserAccessTokenRefreshed)
 		twitchAuthUrl = client.GetAuthorizationURL(&helix.AuthorizationURLParams{
 			ResponseType: "code",
-			Scopes:       []string{"channel:manage:broadcast", "moderator:manage:banned_users", "moderator:read:followers", "user:read:chat", "channel:bot", "moderator:manage:chat_messages"},
+			Scopes:       []string{"channel:manage:broadcast", "moderator:manage:banned_users", "moderator:read:followers", "user:read:chat", "channel:bot", "moderator:manage:chat_messages", "channel:read:redemptions", "user:write:chat", "channel:manage:polls", "channel:manage:predictions", "moderator:read:chatters"},
 		})
 		WriteStringToFile(path.Join(baseDir, "twitch_auth_url.txt"), twitchAuthUrl)
 		getUsersResp, err := client.GetUsers(&helix.UsersParams{Logins: []string{twitchBroadcasterUsername, twitchBotUsername}})
@@ -189,12 +194,35 @@ func TwitchHelixBot() {
 			continue
 		}
 		twitchTitle = getChannelInfoResp.Data.Channels[0].Title
-		Webserver.Call("SetStreamTitle", twitchTitle)
+		UpdateStreamInfoFromTwitch(getChannelInfoResp.Data.Channels[0])
+		Webserver.CallTopic(TopicNowPlaying, "SetStreamTitle", twitchTitle)
+		loadTwitchBadges(client)
+
+		for fn := range TwitchHelixChannel {
+			fn(client)
+		}
+	}
+}
+
+// Images of the Twitch chat badges, "set_id/id" => URL.
+var twitchBadgeImages sync.Map
 
-		for msg := range TwitchHelixChannel {
-			switch t := msg.(type) {
-			case func(*helix.Client):
-				t(client)
+// loadTwitchBadges fills twitchBadgeImages. Channel badges (custom subscriber badges...) replace the global ones.
+func loadTwitchBadges(client *helix.Client) {
+	global, err := client.GetGlobalChatBadges()
+	if err != nil {
+		twitchColor.Println("Couldn't get global chat badges: ", err)
+		return
+	}
+	channel, err := client.GetChannelChatBadges(&helix.GetChatBadgeParams{BroadcasterID: twitchBroadcasterID})
+	if err != nil {
+		twitchColor.Println("Couldn't get channel chat badges: ", err)
+		return
+	}
+	for _, sets := range [][]helix.ChatBadge{global.Data.Badges, channel.Data.Badges} {
+		for _, set := range sets {
+			for _, version := range set.Versions {
+				twitchBadgeImages.Store(set.SetID+"/"+version.ID, version.ImageUrl2x)
 			}
 		}
 	}
@@ -5,7 +5,12 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"html"
+	"iter"
+	"os"
 	"path"
+	"strings"
+	"time"
 )
 
 type User struct {
@@ -16,7 +21,11 @@ type User struct {
 	Ticket            string       `json:"ticket,omitempty"`
 	Voice             string       `json:"voice,omitempty"`
 	NamePronunciation string       `json:"name_pronunciation,omitempty"`
+	Points            int          `json:"points,omitempty"`
 	websockets        []*WebsocketClient
+	// First & last chat message of the user record (see markChatHistory).
+	FirstChat time.Time `json:"first_chat,omitzero"`
+	LastChat  time.Time `json:"last_chat,omitzero"`
 }
 
 var TwitchIndex = map[string]*User{}
@@ -25,31 +34,72 @@ var DiscordIndex = map[string]*User{}
 var PasswordIndex = map[string]*User{}
 var TicketIndex = map[string]*User{}
 
+// Records of the chatters who never logged in to the viewer panel (points & chat history), by platform key.
+var RecordIndex = map[string]*User{}
+
+// All the indices are only accessed from the main thread.
+
 var usersPath = path.Join(baseDir, "secrets", "users.json")
+var userRecordsPath = path.Join(baseDir, "secrets", "user_records.json")
 
+// SaveUsers writes users.json & user_records.json. Run this only on the main thread!
 func SaveUsers() error {
 	var usersToSave map[string]User = map[string]User{}
 	for password, user := range PasswordIndex {
-		worthSaving := user.TwitchUser != nil || user.YouTubeUser != nil || user.DiscordUser != nil || user.Voice != "" || user.NamePronunciation != ""
+		worthSaving := user.TwitchUser != nil || user.YouTubeUser != nil || user.DiscordUser != nil || user.Voice != "" || user.NamePronunciation != "" || user.Points != 0 || !user.FirstChat.IsZero()
 		if worthSaving {
-			// skip non-essential data
-			userToSave := *user
-			userToSave.websockets = nil
-			userToSave.Ticket = ""
-			usersToSave[password] = userToSave
+			usersToSave[password] = user.essentials()
+		}
+	}
+	if err := writeUsers(usersPath, usersToSave); err != nil {
+		return err
+	}
+	recordsToSave := map[string]User{}
+	for key, record := range RecordIndex {
+		// Records of the accounts linked with !login are merged into the logged in users
+		if record.Key() == key {
+			recordsToSave[key] = record.essentials()
 		}
 	}
-	bytes, err := json.MarshalIndent(usersToSave, "", "\t")
+	return writeUsers(userRecordsPath, recordsToSave)
+}
+
+// essentials returns a copy without the session data.
+func (u *User) essentials() User {
+	user := *u
+	user.websockets = nil
+	user.Ticket = ""
+	return user
+}
+
+func writeUsers(path string, users map[string]User) error {
+	bytes, err := json.MarshalIndent(users, "", "\t")
 	if err != nil {
 		return fmt.Errorf("couldn't marshal users to save: %w", err)
 	}
-	err = WriteStringToFile(usersPath, string(bytes))
+	err = WriteStringToFile(path, string(bytes))
 	if err != nil {
 		return fmt.Errorf("couldn't write users to file: %w", err)
 	}
 	return nil
 }
 
+// AllUsers iterates over the logged in users and the records of the other chatters. Run this only on the main thread!
+func AllUsers() iter.Seq[*User] {
+	return func(yield func(*User) bool) {
+		for _, user := range PasswordIndex {
+			if !yield(user) {
+				return
+			}
+		}
+		for _, record := range RecordIndex {
+			if !yield(record) {
+				return
+			}
+		}
+	}
+}
+
 func LoadUsers() error {
 	usersStr, err := ReadStringFromFile(usersPath)
 	if err != nil {
@@ -83,6 +133,28 @@ func LoadUsers() error {
 			}
 		}
 	}
+	return loadUserRecords()
+}
+
+func loadUserRecords() error {
+	recordsStr, err := ReadStringFromFile(userRecordsPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't read user records file: %w", err)
+	}
+	if err := json.Unmarshal([]byte(recordsStr), &RecordIndex); err != nil {
+		return fmt.Errorf("couldn't unmarshal user records: %w", err)
+	}
+	for key, record := range RecordIndex {
+		if record.Key() != key || FindUserByKey(key) != nil {
+			// Linked with !login in the meantime
+			delete(RecordIndex, key)
+			continue
+		}
+		record.index()
+	}
 	return nil
 }
 
@@ -124,6 +196,47 @@ func (u User) LoadSettings() *User {
 	return &u
 }
 
+// Record returns the stored settings of this user, creating them if needed. Unlike LoadSettings, changes to the
+// returned user are saved by SaveUsers. Run this only on the main thread!
+func (u User) Record() *User {
+	if settings := FindUserByKey(u.Key()); settings != nil {
+		return settings
+	}
+	if u.TwitchUser == nil && u.YouTubeUser == nil && u.DiscordUser == nil {
+		return &u // bots are not stored
+	}
+	// The user can still link the account to their viewer panel with !login.
+	record := &User{TwitchUser: u.TwitchUser, YouTubeUser: u.YouTubeUser, DiscordUser: u.DiscordUser}
+	RecordIndex[record.Key()] = record
+	record.index()
+	return record
+}
+
+// index adds the record to the index of its platform.
+func (u *User) index() {
+	if u.TwitchUser != nil {
+		TwitchIndex[u.TwitchUser.Key()] = u
+	} else if u.YouTubeUser != nil {
+		YouTubeIndex[u.YouTubeUser.Key()] = u
+	} else if u.DiscordUser != nil {
+		DiscordIndex[u.DiscordUser.Key()] = u
+	}
+}
+
+// FindUserByKey returns the stored settings of the user with the given platform key, or nil.
+// Run this only on the main thread!
+func FindUserByKey(key string) *User {
+	switch {
+	case strings.HasPrefix(key, TWITCH_KEY_PREFIX):
+		return TwitchIndex[key]
+	case strings.HasPrefix(key, YOUTUBE_KEY_PREFIX):
+		return YouTubeIndex[key]
+	case strings.HasPrefix(key, DISCORD_KEY_PREFIX):
+		return DiscordIndex[key]
+	}
+	return nil
+}
+
 func (u User) DisplayName() string {
 	if u.TwitchUser != nil {
 		return u.TwitchUser.DisplayName()
@@ -157,6 +270,12 @@ func (u User) Key() string {
 	return ""
 }
 
+// LinkedKey identifies the person behind the account. Accounts linked with !login share the same key.
+// Run this only on the main thread!
+func (u User) LinkedKey() string {
+	return u.LoadSettings().Key()
+}
+
 func (u User) HTML() string {
 	ret := ""
 	avatar_url := ""
@@ -165,19 +284,19 @@ func (u User) HTML() string {
 	} else if u.DiscordUser != nil && u.DiscordUser.Avatar != "" {
 		avatar_url = fmt.Sprintf("https://cdn.discordapp.com/avatars/%s/%s.png", u.DiscordUser.ID, u.DiscordUser.Avatar)
 	}
-	if avatar_url != "" {
-		ret += `<img src="` + avatar_url + `" class="avatar">`
+	if avatar_url != "" && SafeURL(avatar_url) {
+		ret += `<img src="` + html.EscapeString(avatar_url) + `" class="avatar">`
 	}
 	color := ""
 	if u.TwitchUser != nil {
-		color = u.TwitchUser.Color
+		color = SafeColor(u.TwitchUser.Color)
 	}
 	if color != "" {
 		ret += `<strong style="color:` + color + `">`
 	} else {
 		ret += `<strong>`
 	}
-	ret += u.DisplayName()
+	ret += html.EscapeString(u.DisplayName())
 	ret += `</strong>`
 	return ret
 }
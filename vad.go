@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/andreykaipov/goobs/api/events"
+)
+
+// Voice activity detection decides when the streamer is speaking so that TTS doesn't talk over them.
+// It's driven by the OBS InputVolumeMeters events and configured in config/vad.json.
+
+const vadConfigFile = "vad.json"
+
+type VADConfig struct {
+	// OBS inputs that carry the streamer's voice. The loudest one is used.
+	Inputs []string `json:"inputs"`
+	// Level (dB) above which the mic becomes active.
+	OpenThresholdDb float64 `json:"open_threshold_db"`
+	// Level (dB) above which an already active mic stays active. Lower than OpenThresholdDb for hysteresis.
+	CloseThresholdDb float64 `json:"close_threshold_db"`
+	// How long the level must stay below CloseThresholdDb before the mic is considered silent.
+	HangoverMillis int `json:"hangover_ms"`
+	// Maximum time that TTS waits for silence. 0 waits forever.
+	MaxWaitSeconds int `json:"max_wait_s"`
+	// What to do after MaxWaitSeconds: "play" or "drop".
+	OnTimeout string `json:"on_timeout"`
+}
+
+var defaultVADConfig = VADConfig{
+	Inputs:           []string{"Mic/Aux"},
+	OpenThresholdDb:  -35,
+	CloseThresholdDb: -40,
+	HangoverMillis:   3000,
+	MaxWaitSeconds:   60,
+	OnTimeout:        "play",
+}
+
+var vadConfig atomic.Pointer[VADConfig]
+
+// How often the mic level is pushed to admin clients.
+const micLevelInterval = 250 * time.Millisecond
+
+func (cfg *VADConfig) Validate() error {
+	if len(cfg.Inputs) == 0 {
+		return fmt.Errorf("no inputs")
+	}
+	if cfg.CloseThresholdDb > cfg.OpenThresholdDb {
+		return fmt.Errorf("close threshold (%f dB) is above open threshold (%f dB)", cfg.CloseThresholdDb, cfg.OpenThresholdDb)
+	}
+	if cfg.HangoverMillis < 0 || cfg.MaxWaitSeconds < 0 {
+		return fmt.Errorf("negative durations")
+	}
+	if cfg.OnTimeout != "play" && cfg.OnTimeout != "drop" {
+		return fmt.Errorf("on_timeout must be \"play\" or \"drop\", got %q", cfg.OnTimeout)
+	}
+	return nil
+}
+
+func LoadVADConfig() {
+	cfg := defaultVADConfig
+	err := LoadConfig(vadConfigFile, &cfg)
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err != nil {
+		warn_color.Println("Using default VAD config:", err)
+		cfg = defaultVADConfig
+	}
+	vadConfig.Store(&cfg)
+}
+
+func GetVADConfig() *VADConfig {
+	if cfg := vadConfig.Load(); cfg != nil {
+		return cfg
+	}
+	return &defaultVADConfig
+}
+
+// micState wakes up the goroutines that wait for silence.
+var micState struct {
+	sync.Mutex
+	silent bool
+	wake   chan struct{} // closed (and replaced) whenever the mic becomes silent
+}
+
+func init() {
+	micState.wake = make(chan struct{})
+}
+
+func setMicSilent(silent bool) {
+	MicIsSilent.Store(silent)
+	micState.Lock()
+	defer micState.Unlock()
+	if silent == micState.silent {
+		return
+	}
+	micState.silent = silent
+	if silent {
+		close(micState.wake)
+		micState.wake = make(chan struct{})
+	}
+}
+
+// WaitForMicSilence blocks until the streamer stops speaking. Returns false if the sound should be dropped
+// because the mic didn't become silent within MaxWaitSeconds.
+func WaitForMicSilence() bool {
+	cfg := GetVADConfig()
+	micState.Lock()
+	if micState.silent {
+		micState.Unlock()
+		return true
+	}
+	wake := micState.wake
+	micState.Unlock()
+
+	audioPlayerColor.Println("Audio Player waiting for mic silence...")
+	waitStart := time.Now()
+	var timeout <-chan time.Time
+	if cfg.MaxWaitSeconds > 0 {
+		timeout = time.After(time.Duration(cfg.MaxWaitSeconds) * time.Second)
+	}
+	select {
+	case <-wake:
+		audioPlayerColor.Println("Resuming playback after", time.Since(waitStart))
+		return true
+	case <-timeout:
+		if cfg.OnTimeout == "drop" {
+			audioPlayerColor.Println("Mic wasn't silent for", time.Since(waitStart), "- dropping sound")
+			return false
+		}
+		audioPlayerColor.Println("Mic wasn't silent for", time.Since(waitStart), "- playing anyway")
+		return true
+	}
+}
+
+// VoiceActivityDetector tracks the mic state. Only used from the OBS thread.
+type VoiceActivityDetector struct {
+	active       bool
+	lastActivity time.Time
+	lastMeter    time.Time
+	silentSince  time.Time
+}
+
+func (vad *VoiceActivityDetector) OnVolumeMeters(t *events.InputVolumeMeters) {
+	cfg := GetVADConfig()
+	magnitude := 0.0
+	found := false
+	for _, input := range t.Inputs {
+		if !slices.Contains(cfg.Inputs, input.Name) {
+			continue
+		}
+		for _, levels := range input.Levels {
+			if len(levels) == 0 {
+				continue
+			}
+			found = true
+			magnitude = max(magnitude, levels[0])
+		}
+	}
+	if !found {
+		return
+	}
+	levelDb := 20 * math.Log10(magnitude)
+	now := time.Now()
+	silentFor := vad.update(cfg, levelDb, now)
+	setMicSilent(!vad.active)
+
+	if now.Sub(vad.lastMeter) >= micLevelInterval {
+		vad.lastMeter = now
+		if math.IsInf(levelDb, -1) {
+			levelDb = -100
+		}
+		// Runs on the OBS thread - a busy hub drops the update instead of stalling OBS requests
+		Webserver.TryCallTopic(TopicAdmin, "MicLevel", levelDb, vad.active)
+		OBSRules.OnMicSilence(silentFor)
+	}
+}
+
+// update tracks the mic state with a new level reading. Returns how long the mic has been silent (0 while active).
+func (vad *VoiceActivityDetector) update(cfg *VADConfig, levelDb float64, now time.Time) time.Duration {
+	threshold := cfg.OpenThresholdDb
+	if vad.active {
+		threshold = cfg.CloseThresholdDb
+	}
+	if levelDb > threshold {
+		vad.active = true
+		vad.lastActivity = now
+	} else if vad.active && now.Sub(vad.lastActivity) > time.Duration(cfg.HangoverMillis)*time.Millisecond {
+		vad.active = false
+		vad.silentSince = now
+	} else if !vad.active && vad.silentSince.IsZero() {
+		// The mic was silent since the first reading
+		vad.silentSince = now
+	}
+	if vad.active {
+		return 0
+	}
+	return now.Sub(vad.silentSince)
+}
+
+func GetVADConfigHandler(c *WebsocketClient) *VADConfig {
+	return GetVADConfig()
+}
+
+// SetVADConfigHandler updates the fields present in the patch. Other fields keep their current values.
+func SetVADConfigHandler(c *WebsocketClient, patch json.RawMessage) error {
+	cfg := *GetVADConfig()
+	cfg.Inputs = slices.Clone(cfg.Inputs) // json.Unmarshal would reuse the backing array
+	if err := json.Unmarshal(patch, &cfg); err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	vadConfig.Store(&cfg)
+	if err := SaveConfig(vadConfigFile, &cfg); err != nil {
+		warn_color.Println("SetVADConfig:", err)
+	}
+	Webserver.CallAdmins("SetVADConfig", &cfg)
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build !windows
+
+package main
+
+import "github.com/fatih/color"
+
+// VlcMonitor reads the current track from the title of the VLC window, which is only possible on Windows.
+func VlcMonitor(audioMessages chan string) {
+	color.New(color.FgCyan).Println("VLC Monitor is only available on Windows")
+	audioMessages <- "No song playing"
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	wavFormatPCM        = 1
+	wavFormatFloat      = 3
+	wavFormatExtensible = 0xFFFE
+)
+
+// WAV holds decoded audio as interleaved samples in the [-1, 1] range.
+type WAV struct {
+	SampleRate int
+	Channels   int
+	Samples    []float32
+}
+
+// wavFormat describes the samples of a RIFF/WAVE file.
+type wavFormat struct {
+	format        int
+	channels      int
+	sampleRate    int
+	bitsPerSample int
+}
+
+// frameSize returns the number of bytes per sample of every channel.
+func (f wavFormat) frameSize() int {
+	return f.bitsPerSample / 8 * f.channels
+}
+
+// parseWAVChunks reads the fmt chunk and finds the data chunk, without decoding the samples. Unknown chunks
+// (LIST, fact, cue, ...) are skipped.
+func parseWAVChunks(data []byte) (wavFormat, []byte, error) {
+	var f wavFormat
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		return f, nil, fmt.Errorf("not a RIFF/WAVE file")
+	}
+	var fmtFound bool
+	var samples []byte
+	var dataFound bool
+	pos := 12
+	for pos+8 <= len(data) {
+		chunkID := string(data[pos : pos+4])
+		chunkSize := int(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+		pos += 8
+		// Streaming encoders write 0 or 0xFFFFFFFF as the size of the last chunk
+		streamed := chunkID == "data" && chunkSize == 0 && !isWAVChunk(data[pos:])
+		if chunkSize < 0 || pos+chunkSize > len(data) || streamed {
+			chunkSize = len(data) - pos
+		}
+		chunk := data[pos : pos+chunkSize]
+		switch chunkID {
+		case "fmt ":
+			if len(chunk) < 16 {
+				return f, nil, fmt.Errorf("fmt chunk too short (%d bytes)", len(chunk))
+			}
+			f.format = int(binary.LittleEndian.Uint16(chunk[0:2]))
+			f.channels = int(binary.LittleEndian.Uint16(chunk[2:4]))
+			f.sampleRate = int(binary.LittleEndian.Uint32(chunk[4:8]))
+			f.bitsPerSample = int(binary.LittleEndian.Uint16(chunk[14:16]))
+			if f.format == wavFormatExtensible {
+				if len(chunk) < 26 {
+					return f, nil, fmt.Errorf("extensible fmt chunk too short (%d bytes)", len(chunk))
+				}
+				// First two bytes of the SubFormat GUID hold the actual format code
+				f.format = int(binary.LittleEndian.Uint16(chunk[24:26]))
+			}
+			fmtFound = true
+		case "data":
+			samples = chunk
+			dataFound = true
+		}
+		pos += chunkSize
+		if chunkSize%2 == 1 {
+			pos++ // chunks are word-aligned
+		}
+	}
+	if !fmtFound {
+		return f, nil, fmt.Errorf("missing fmt chunk")
+	}
+	if !dataFound {
+		return f, nil, fmt.Errorf("missing data chunk")
+	}
+	if f.channels <= 0 || f.sampleRate <= 0 || f.bitsPerSample <= 0 || f.bitsPerSample%8 != 0 {
+		return f, nil, fmt.Errorf("invalid format: %d channels of %d bits at %d Hz", f.channels, f.bitsPerSample, f.sampleRate)
+	}
+	return f, samples, nil
+}
+
+// isWAVChunk returns true if the data starts with a complete chunk: a printable ID and a size that fits.
+func isWAVChunk(data []byte) bool {
+	if len(data) < 8 {
+		return false
+	}
+	for _, c := range data[0:4] {
+		if c < ' ' || c > '~' {
+			return false
+		}
+	}
+	return int(binary.LittleEndian.Uint32(data[4:8])) <= len(data)-8
+}
+
+// ParseWAV decodes a RIFF/WAVE file. It supports 8/16/24/32-bit integer PCM and 32/64-bit float
+// samples.
+func ParseWAV(data []byte) (*WAV, error) {
+	f, samples, err := parseWAVChunks(data)
+	if err != nil {
+		return nil, err
+	}
+	decode, err := wavSampleDecoder(f.format, f.bitsPerSample)
+	if err != nil {
+		return nil, err
+	}
+	bytesPerSample := f.bitsPerSample / 8
+	n := len(samples) / f.frameSize() * f.channels
+	wav := &WAV{
+		SampleRate: f.sampleRate,
+		Channels:   f.channels,
+		Samples:    make([]float32, n),
+	}
+	for i := 0; i < n; i++ {
+		wav.Samples[i] = decode(samples[i*bytesPerSample:])
+	}
+	return wav, nil
+}
+
+// WAVDuration returns the length of a WAV file, computed from its headers.
+func WAVDuration(data []byte) time.Duration {
+	f, samples, err := parseWAVChunks(data)
+	if err != nil {
+		audioPlayerColor.Println("Couldn't parse WAV:", err)
+		return 0
+	}
+	frames := len(samples) / f.frameSize()
+	return time.Duration(frames) * time.Second / time.Duration(f.sampleRate)
+}
+
+func wavSampleDecoder(format, bitsPerSample int) (func([]byte) float32, error) {
+	switch {
+	case format == wavFormatPCM && bitsPerSample == 8:
+		return func(b []byte) float32 { return (float32(b[0]) - 128) / 128 }, nil
+	case format == wavFormatPCM && bitsPerSample == 16:
+		return func(b []byte) float32 { return float32(int16(binary.LittleEndian.Uint16(b))) / 32768 }, nil
+	case format == wavFormatPCM && bitsPerSample == 24:
+		return func(b []byte) float32 {
+			v := int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24) >> 8
+			return float32(v) / (1 << 23)
+		}, nil
+	case format == wavFormatPCM && bitsPerSample == 32:
+		return func(b []byte) float32 { return float32(int32(binary.LittleEndian.Uint32(b))) / (1 << 31) }, nil
+	case format == wavFormatFloat && bitsPerSample == 32:
+		return func(b []byte) float32 { return math.Float32frombits(binary.LittleEndian.Uint32(b)) }, nil
+	case format == wavFormatFloat && bitsPerSample == 64:
+		return func(b []byte) float32 { return float32(math.Float64frombits(binary.LittleEndian.Uint64(b))) }, nil
+	}
+	return nil, fmt.Errorf("unsupported WAV format %d with %d bits per sample", format, bitsPerSample)
+}
+
+// Frames returns the number of samples per channel.
+func (w *WAV) Frames() int {
+	return len(w.Samples) / w.Channels
+}
+
+func (w *WAV) Duration() time.Duration {
+	return time.Duration(w.Frames()) * time.Second / time.Duration(w.SampleRate)
+}
+
+// Convert returns audio resampled (linear interpolation) and mixed to the given format.
+// Downmixing averages all channels. Upmixing copies the mono mix into every channel.
+func (w *WAV) Convert(sampleRate, channels int) *WAV {
+	return w.mixChannels(channels).resample(sampleRate)
+}
+
+func (w *WAV) mixChannels(channels int) *WAV {
+	if w.Channels == channels {
+		return w
+	}
+	frames := w.Frames()
+	out := &WAV{
+		SampleRate: w.SampleRate,
+		Channels:   channels,
+		Samples:    make([]float32, frames*channels),
+	}
+	for i := 0; i < frames; i++ {
+		sum := float32(0)
+		for _, s := range w.Samples[i*w.Channels : (i+1)*w.Channels] {
+			sum += s
+		}
+		mono := sum / float32(w.Channels)
+		for c := 0; c < channels; c++ {
+			out.Samples[i*channels+c] = mono
+		}
+	}
+	return out
+}
+
+func (w *WAV) resample(sampleRate int) *WAV {
+	if w.SampleRate == sampleRate {
+		return w
+	}
+	frames := w.Frames()
+	outFrames := int(int64(frames) * int64(sampleRate) / int64(w.SampleRate))
+	out := &WAV{
+		SampleRate: sampleRate,
+		Channels:   w.Channels,
+		Samples:    make([]float32, outFrames*w.Channels),
+	}
+	for i := 0; i < outFrames; i++ {
+		srcPos := float64(i) * float64(w.SampleRate) / float64(sampleRate)
+		i0 := min(int(srcPos), frames-1)
+		i1 := min(i0+1, frames-1)
+		frac := float32(srcPos - float64(i0))
+		for c := 0; c < w.Channels; c++ {
+			a := w.Samples[i0*w.Channels+c]
+			b := w.Samples[i1*w.Channels+c]
+			out.Samples[i*w.Channels+c] = a + (b-a)*frac
+		}
+	}
+	return out
+}
+
+// PCM16 encodes the samples as signed 16-bit little-endian integers (no header).
+func (w *WAV) PCM16() []byte {
+	out := make([]byte, len(w.Samples)*2)
+	for i, s := range w.Samples {
+		s = max(-1, min(1, s))
+		binary.LittleEndian.PutUint16(out[i*2:], uint16(int16(s*32767)))
+	}
+	return out
+}
+
+// Encode returns a complete 16-bit PCM WAV file.
+func (w *WAV) Encode() []byte {
+	samples := w.PCM16()
+	return append(wavHeader(w.SampleRate, w.Channels, len(samples)), samples...)
+}
+
+// wavHeader returns a 44 byte header for s16le samples.
+func wavHeader(sampleRate, channels, dataLen int) []byte {
+	header := make([]byte, 44)
+	copy(header[0:], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], uint32(36+dataLen))
+	copy(header[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(header[16:], 16) // fmt chunk size
+	binary.LittleEndian.PutUint16(header[20:], wavFormatPCM)
+	binary.LittleEndian.PutUint16(header[22:], uint16(channels))
+	binary.LittleEndian.PutUint32(header[24:], uint32(sampleRate))
+	binary.LittleEndian.PutUint32(header[28:], uint32(sampleRate*channels*2)) // byte rate
+	binary.LittleEndian.PutUint16(header[32:], uint16(channels*2))            // block align
+	binary.LittleEndian.PutUint16(header[34:], 16)                            // bits per sample
+	copy(header[36:], "data")
+	binary.LittleEndian.PutUint32(header[40:], uint32(dataLen))
+	return header
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+type testChunk struct {
+	id   string
+	data []byte
+	// Written instead of len(data) when set.
+	size *uint32
+}
+
+// buildWAV assembles a RIFF/WAVE file from the chunks, padding odd-length chunks.
+func buildWAV(chunks ...testChunk) []byte {
+	out := []byte("RIFF\x00\x00\x00\x00WAVE")
+	for _, c := range chunks {
+		out = append(out, c.id...)
+		size := uint32(len(c.data))
+		if c.size != nil {
+			size = *c.size
+		}
+		out = binary.LittleEndian.AppendUint32(out, size)
+		out = append(out, c.data...)
+		if len(c.data)%2 == 1 {
+			out = append(out, 0)
+		}
+	}
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out
+}
+
+func fmtChunk(format, channels, sampleRate, bits int) testChunk {
+	data := binary.LittleEndian.AppendUint16(nil, uint16(format))
+	data = binary.LittleEndian.AppendUint16(data, uint16(channels))
+	data = binary.LittleEndian.AppendUint32(data, uint32(sampleRate))
+	data = binary.LittleEndian.AppendUint32(data, uint32(sampleRate*channels*bits/8))
+	data = binary.LittleEndian.AppendUint16(data, uint16(channels*bits/8))
+	data = binary.LittleEndian.AppendUint16(data, uint16(bits))
+	return testChunk{id: "fmt ", data: data}
+}
+
+func pcm16(samples ...int16) []byte {
+	var out []byte
+	for _, s := range samples {
+		out = binary.LittleEndian.AppendUint16(out, uint16(s))
+	}
+	return out
+}
+
+func uint32Ptr(v uint32) *uint32 { return &v }
+
+func TestParseWAV(t *testing.T) {
+	second := pcm16(make([]int16, 8000)...)
+	tests := []struct {
+		name       string
+		wav        []byte
+		rate       int
+		channels   int
+		samples    int
+		duration   time.Duration
+		firstValue float32
+	}{
+		{
+			name:     "plain header",
+			wav:      buildWAV(fmtChunk(wavFormatPCM, 1, 8000, 16), testChunk{id: "data", data: second}),
+			rate:     8000,
+			channels: 1,
+			samples:  8000,
+			duration: time.Second,
+		},
+		{
+			name: "LIST and fact chunks before data",
+			wav: buildWAV(
+				fmtChunk(wavFormatPCM, 2, 8000, 16),
+				testChunk{id: "LIST", data: []byte("INFOISFT\x04\x00\x00\x00test")},
+				testChunk{id: "fact", data: []byte{0, 0, 0, 0}},
+				testChunk{id: "data", data: pcm16(16384, -16384, 0, 0)},
+			),
+			rate:       8000,
+			channels:   2,
+			samples:    4,
+			duration:   2 * time.Second / 8000,
+			firstValue: 0.5,
+		},
+		{
+			name: "odd-length chunk is padded",
+			wav: buildWAV(
+				fmtChunk(wavFormatPCM, 1, 8000, 16),
+				testChunk{id: "junk", data: []byte{1, 2, 3}},
+				testChunk{id: "data", data: pcm16(-32768, 0)},
+			),
+			rate:       8000,
+			channels:   1,
+			samples:    2,
+			duration:   2 * time.Second / 8000,
+			firstValue: -1,
+		},
+		{
+			name: "streamed data chunk runs to the end",
+			wav: buildWAV(
+				fmtChunk(wavFormatPCM, 1, 8000, 16),
+				testChunk{id: "data", data: second, size: uint32Ptr(0)},
+			),
+			rate:     8000,
+			channels: 1,
+			samples:  8000,
+			duration: time.Second,
+		},
+		{
+			name: "streamed data chunk with 0xFFFFFFFF size",
+			wav: buildWAV(
+				fmtChunk(wavFormatPCM, 1, 8000, 16),
+				testChunk{id: "data", data: second, size: uint32Ptr(0xFFFFFFFF)},
+			),
+			rate:     8000,
+			channels: 1,
+			samples:  8000,
+			duration: time.Second,
+		},
+		{
+			name: "empty data chunk followed by other chunks",
+			wav: buildWAV(
+				fmtChunk(wavFormatPCM, 1, 8000, 16),
+				testChunk{id: "data"},
+				testChunk{id: "LIST", data: []byte("INFO")},
+			),
+			rate:     8000,
+			channels: 1,
+		},
+		{
+			name: "8-bit",
+			wav: buildWAV(
+				fmtChunk(wavFormatPCM, 1, 11025, 8),
+				testChunk{id: "data", data: []byte{0, 128, 255}},
+			),
+			rate:       11025,
+			channels:   1,
+			samples:    3,
+			duration:   3 * time.Second / 11025,
+			firstValue: -1,
+		},
+		{
+			name: "24-bit",
+			wav: buildWAV(
+				fmtChunk(wavFormatPCM, 1, 48000, 24),
+				testChunk{id: "data", data: []byte{0, 0, 0xC0, 0, 0, 0x40}},
+			),
+			rate:       48000,
+			channels:   1,
+			samples:    2,
+			duration:   2 * time.Second / 48000,
+			firstValue: -0.5,
+		},
+		{
+			name: "32-bit float",
+			wav: buildWAV(
+				fmtChunk(wavFormatFloat, 1, 24000, 32),
+				testChunk{id: "data", data: binary.LittleEndian.AppendUint32(nil, 0x3F000000)},
+			),
+			rate:       24000,
+			channels:   1,
+			samples:    1,
+			duration:   time.Second / 24000,
+			firstValue: 0.5,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			wav, err := ParseWAV(test.wav)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if wav.SampleRate != test.rate || wav.Channels != test.channels || len(wav.Samples) != test.samples {
+				t.Errorf("got %d Hz, %d channels, %d samples; want %d Hz, %d channels, %d samples",
+					wav.SampleRate, wav.Channels, len(wav.Samples), test.rate, test.channels, test.samples)
+			}
+			if len(wav.Samples) > 0 && wav.Samples[0] != test.firstValue {
+				t.Errorf("first sample = %v, want %v", wav.Samples[0], test.firstValue)
+			}
+			if got := WAVDuration(test.wav); got != test.duration {
+				t.Errorf("WAVDuration = %v, want %v", got, test.duration)
+			}
+			if got := wav.Duration(); got != test.duration {
+				t.Errorf("Duration = %v, want %v", got, test.duration)
+			}
+		})
+	}
+}
+
+func TestParseWAVErrors(t *testing.T) {
+	tests := map[string][]byte{
+		"not RIFF":          []byte("RIFX\x00\x00\x00\x00WAVE"),
+		"missing fmt":       buildWAV(testChunk{id: "data", data: pcm16(0)}),
+		"missing data":      buildWAV(fmtChunk(wavFormatPCM, 1, 8000, 16)),
+		"short fmt":         buildWAV(testChunk{id: "fmt ", data: []byte{1, 0}}, testChunk{id: "data", data: pcm16(0)}),
+		"zero channels":     buildWAV(fmtChunk(wavFormatPCM, 0, 8000, 16), testChunk{id: "data", data: pcm16(0)}),
+		"12-bit":            buildWAV(fmtChunk(wavFormatPCM, 1, 8000, 12), testChunk{id: "data", data: pcm16(0)}),
+		"unsupported codec": buildWAV(fmtChunk(2, 1, 8000, 16), testChunk{id: "data", data: pcm16(0)}),
+	}
+	for name, data := range tests {
+		if _, err := ParseWAV(data); err == nil {
+			t.Errorf("%s: ParseWAV succeeded", name)
+		}
+	}
+}
+
+func TestWAVConvert(t *testing.T) {
+	stereo := &WAV{SampleRate: 22050, Channels: 2, Samples: []float32{1, 0, 0.5, 0.5}}
+	converted := stereo.Convert(44100, 1)
+	if converted.SampleRate != 44100 || converted.Channels != 1 || len(converted.Samples) != 4 {
+		t.Fatalf("got %d Hz, %d channels, %d samples", converted.SampleRate, converted.Channels, len(converted.Samples))
+	}
+	if converted.Samples[0] != 0.5 {
+		t.Errorf("first sample = %v, want 0.5", converted.Samples[0])
+	}
+	decoded, err := ParseWAV(converted.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Duration() != converted.Duration() {
+		t.Errorf("encoded duration = %v, want %v", decoded.Duration(), converted.Duration())
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"streambot/backoff"
+
+	"github.com/fatih/color"
+)
+
+// Outgoing webhooks. Every webhook subscribes to some kinds of bus events (see events.go) and POSTs them as JSON to
+// its URL. Chat commands (messages starting with "!") are delivered as the "Command" kind. Configured in
+// config/webhooks.json. Deliveries are appended to webhooks.log.
+//
+// Secrets are kept in secrets/webhook_secrets.json, which maps the webhook names to their secrets. When a webhook has a
+// secret, the body is signed with HMAC-SHA256 and the signature is sent in the X-Streambot-Signature header as
+// "sha256=<hex>".
+//
+// Each webhook delivers its events one by one. Failed deliveries are retried with backoff, but an event is abandoned
+// after webhookMaxDeliveryTime, so a dead endpoint holds up the events queued behind it for at most a minute each
+// (and the oldest ones are dropped when the queue is full).
+
+const webhooksConfigFile = "webhooks.json"
+
+// Kind of the webhook events for chat commands. Not a bus event - it's derived from ChatMessage.
+const WebhookCommand = "Command"
+
+const (
+	webhookTimeout         = 10 * time.Second
+	webhookMaxDeliveryTime = time.Minute
+	webhookBuffer          = 64
+	webhookDefaultAttempts = 5
+	webhookRecentLength    = 100
+)
+
+var webhooksLogPath = path.Join(baseDir, "webhooks.log")
+var webhookSecretsPath = secretsPath("webhook_secrets.json")
+
+var webhookColor = color.New(color.FgHiMagenta)
+
+type WebhookConfig struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// Event kinds, for example "Follow", "Raid", "SceneChanged" or "Command".
+	Events []string `json:"events"`
+	// Only these commands (without "!") are delivered. Empty means all commands.
+	Commands []string `json:"commands,omitempty"`
+	// Only commands from authors with one of these roles (for example "moderator") are delivered. Empty means everybody.
+	Roles []string `json:"roles,omitempty"`
+	// Loaded from webhook_secrets.json.
+	Secret string `json:"-"`
+	// Maximum number of delivery attempts (5 by default).
+	MaxAttempts int `json:"max_attempts,omitempty"`
+}
+
+type WebhookCommandEvent struct {
+	Command string    `json:"command"`
+	Args    string    `json:"args"`
+	Author  User      `json:"author"`
+	Entry   ChatEntry `json:"entry"`
+}
+
+type WebhookPayload struct {
+	ID    string    `json:"id"`
+	Event string    `json:"event"`
+	Time  time.Time `json:"time"`
+	Data  any       `json:"data"`
+}
+
+type WebhookDelivery struct {
+	Time       time.Time `json:"time"`
+	Webhook    string    `json:"webhook"`
+	Event      string    `json:"event"`
+	ID         string    `json:"id"`
+	Attempt    int       `json:"attempt"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs int64     `json:"duration_ms"`
+}
+
+var webhookDeliveriesMu sync.Mutex
+var webhookDeliveries []WebhookDelivery
+
+func logWebhookDelivery(delivery WebhookDelivery) {
+	webhookDeliveriesMu.Lock()
+	webhookDeliveries = append(webhookDeliveries, delivery)
+	if len(webhookDeliveries) > webhookRecentLength {
+		webhookDeliveries = webhookDeliveries[1:]
+	}
+	webhookDeliveriesMu.Unlock()
+	line, err := json.Marshal(delivery)
+	if err != nil {
+		return
+	}
+	if err := AppendToFile(webhooksLogPath, string(line)+"\n"); err != nil {
+		webhookColor.Println("Couldn't append to webhooks.log:", err)
+	}
+	Webserver.CallAdmins("WebhookDelivery", delivery)
+}
+
+func RecentWebhookDeliveries() []WebhookDelivery {
+	webhookDeliveriesMu.Lock()
+	defer webhookDeliveriesMu.Unlock()
+	return slices.Clone(webhookDeliveries)
+}
+
+// StartWebhooks subscribes the configured webhooks to the bus. Call it before the publishers start.
+func StartWebhooks() {
+	var webhooks []WebhookConfig
+	if err := LoadConfig(webhooksConfigFile, &webhooks); err != nil {
+		webhookColor.Println("Webhooks disabled:", err)
+		return
+	}
+	secrets, err := loadWebhookSecrets()
+	if err != nil {
+		webhookColor.Println("Webhooks disabled:", err)
+		return
+	}
+	client := &http.Client{Timeout: webhookTimeout}
+	for _, hook := range webhooks {
+		if hook.URL == "" || len(hook.Events) == 0 {
+			webhookColor.Printf("Webhook %q needs an URL and events\n", hook.Name)
+			continue
+		}
+		hook.Secret = secrets[hook.Name]
+		kinds := slices.Clone(hook.Events)
+		if i := slices.Index(kinds, WebhookCommand); i >= 0 {
+			kinds[i] = ChatMessage{}.EventKind()
+		}
+		// Each webhook has its own queue, so a slow endpoint doesn't delay the others.
+		sub := Events.Subscribe("webhook "+hook.Name, DropOldest, webhookBuffer, kinds...)
+		go runWebhook(client, hook, sub)
+	}
+}
+
+// loadWebhookSecrets returns the secrets of the webhooks by name. The file is optional.
+func loadWebhookSecrets() (map[string]string, error) {
+	secrets := map[string]string{}
+	bytes, err := os.ReadFile(webhookSecretsPath)
+	if os.IsNotExist(err) {
+		return secrets, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(bytes, &secrets); err != nil {
+		return nil, fmt.Errorf("couldn't parse %s: %w", webhookSecretsPath, err)
+	}
+	return secrets, nil
+}
+
+func runWebhook(client *http.Client, hook WebhookConfig, sub *Subscription) {
+	for event := range sub.C {
+		kind, data, ok := webhookEvent(hook, event)
+		if !ok {
+			continue
+		}
+		var id [8]byte
+		rand.Read(id[:])
+		payload := WebhookPayload{ID: hex.EncodeToString(id[:]), Event: kind, Time: time.Now(), Data: data}
+		body, err := json.Marshal(payload)
+		if err != nil {
+			webhookColor.Println("Couldn't marshal webhook payload:", err)
+			continue
+		}
+		deliverWebhook(client, hook, payload, body)
+	}
+}
+
+// webhookEvent returns the kind & data delivered to the webhook. Chat commands become WebhookCommandEvents.
+func webhookEvent(hook WebhookConfig, event Event) (kind string, data any, ok bool) {
+	kind = event.EventKind()
+	chat, isChat := event.(ChatMessage)
+	if !isChat {
+		return kind, event, true
+	}
+	if command, found := strings.CutPrefix(chat.Entry.OriginalMessage, "!"); found && slices.Contains(hook.Events, WebhookCommand) {
+		name, args, _ := strings.Cut(command, " ")
+		allowed := len(hook.Roles) == 0 || chat.Entry.HasRole(hook.Roles...)
+		if allowed && (len(hook.Commands) == 0 || slices.Contains(hook.Commands, name)) {
+			return WebhookCommand, WebhookCommandEvent{Command: name, Args: args, Author: chat.Entry.Author, Entry: chat.Entry}, true
+		}
+	}
+	// The webhook may be subscribed to ChatMessage only because of the commands
+	return kind, event, slices.Contains(hook.Events, kind)
+}
+
+// deliverWebhook POSTs the body, retrying with backoff on network errors, 429 and 5xx responses for up to
+// webhookMaxDeliveryTime.
+func deliverWebhook(client *http.Client, hook WebhookConfig, payload WebhookPayload, body []byte) {
+	maxAttempts := hook.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = webhookDefaultAttempts
+	}
+	deadline := time.Now().Add(webhookMaxDeliveryTime)
+	b := backoff.Backoff{Color: webhookColor, Description: "webhook " + hook.Name}
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if !b.AttemptBefore(deadline) {
+			webhookColor.Printf("Webhook %q gave up on %s after %d attempts\n", hook.Name, payload.Event, attempt-1)
+			return
+		}
+		start := time.Now()
+		ctx, cancel := context.WithDeadline(context.Background(), deadline)
+		statusCode, err := postWebhook(ctx, client, hook, payload, body)
+		cancel()
+		delivery := WebhookDelivery{
+			Time:       start,
+			Webhook:    hook.Name,
+			Event:      payload.Event,
+			ID:         payload.ID,
+			Attempt:    attempt,
+			StatusCode: statusCode,
+			DurationMs: time.Since(start).Milliseconds(),
+		}
+		if err != nil {
+			delivery.Error = err.Error()
+		}
+		logWebhookDelivery(delivery)
+		if err == nil && statusCode >= 200 && statusCode < 300 {
+			b.Success()
+			return
+		}
+		if err == nil && statusCode != http.StatusTooManyRequests && statusCode < 500 {
+			webhookColor.Printf("Webhook %q rejected %s with status %d\n", hook.Name, payload.Event, statusCode)
+			return
+		}
+	}
+	webhookColor.Printf("Webhook %q failed to deliver %s after %d attempts\n", hook.Name, payload.Event, maxAttempts)
+}
+
+func postWebhook(ctx context.Context, client *http.Client, hook WebhookConfig, payload WebhookPayload, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "streambot")
+	req.Header.Set("X-Streambot-Event", payload.Event)
+	req.Header.Set("X-Streambot-Delivery", payload.ID)
+	if hook.Secret != "" {
+		req.Header.Set("X-Streambot-Signature", "sha256="+WebhookSignature(hook.Secret, body))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// WebhookSignature returns the hex HMAC-SHA256 of the body. Receivers should compare it with hmac.Equal.
+func WebhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Websocket handlers
+
+func GetWebhookDeliveriesHandler(c *WebsocketClient) []WebhookDelivery {
+	return RecentWebhookDeliveries()
+}
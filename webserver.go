@@ -7,12 +7,12 @@ import (
 	"net/http"
 	"os"
 	"slices"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/gorilla/websocket"
-	"github.com/nicklaw5/helix/v2"
-	"google.golang.org/api/youtube/v3"
 )
 
 const (
@@ -38,7 +38,10 @@ type WebsocketHub struct {
 	// Unregister requests from clients.
 	unregister chan *WebsocketClient
 
-	broadcast chan []byte
+	broadcast chan topicMessage
+
+	// Messages sent only to clients that tap the event bus.
+	tapBroadcast chan []byte
 }
 
 type WebsocketClient struct {
@@ -47,6 +50,19 @@ type WebsocketClient struct {
 	send  chan []byte
 	admin bool
 	user  *User
+	// Receives all events from the bus (see TapEventsHandler).
+	tap atomic.Bool
+	// Topics of the broadcasts received by the client. Nil means all topics. See topics.go.
+	topics atomic.Pointer[map[string]bool]
+	// Guards send, which is closed by the hub while other threads may still send to it.
+	sendMu sync.Mutex
+	closed bool
+}
+
+// topicMessage is a broadcast. Messages without a topic go to all clients.
+type topicMessage struct {
+	topic string
+	data  []byte
 }
 
 type callRequest struct {
@@ -68,11 +84,63 @@ func jsonCallRequest(function_name string, args ...interface{}) []byte {
 }
 
 func (c *WebsocketClient) Call(function_name string, args ...interface{}) {
-	c.send <- jsonCallRequest(function_name, args...)
+	if !c.trySend(jsonCallRequest(function_name, args...)) {
+		fmt.Println("Websocket client is gone, dropping call to", function_name)
+	}
+}
+
+// trySend queues the message without waiting. Returns false if the client is closed or its buffer is full.
+func (c *WebsocketClient) trySend(message []byte) bool {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	if c.closed {
+		return false
+	}
+	select {
+	case c.send <- message:
+		return true
+	default:
+		return false
+	}
 }
 
+// close makes the writePump close the connection. Only the hub calls it.
+func (c *WebsocketClient) close() {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	if !c.closed {
+		c.closed = true
+		close(c.send)
+	}
+}
+
+// Call calls the function on all clients, regardless of their topics.
 func (c *WebsocketHub) Call(function_name string, args ...interface{}) {
-	c.broadcast <- jsonCallRequest(function_name, args...)
+	c.broadcast <- topicMessage{data: jsonCallRequest(function_name, args...)}
+}
+
+// CallTopic calls the function on the clients subscribed to the topic.
+func (c *WebsocketHub) CallTopic(topic string, function_name string, args ...interface{}) {
+	c.broadcast <- topicMessage{topic: topic, data: jsonCallRequest(function_name, args...)}
+}
+
+func (c *WebsocketHub) CallAdmins(function_name string, args ...interface{}) {
+	c.CallTopic(TopicAdmin, function_name, args...)
+}
+
+// TryCallTopic is CallTopic that drops the call instead of waiting when the hub is busy. Meant for frequent updates
+// (like the mic level) that are sent from threads which must not block.
+func (c *WebsocketHub) TryCallTopic(topic string, function_name string, args ...interface{}) bool {
+	select {
+	case c.broadcast <- topicMessage{topic: topic, data: jsonCallRequest(function_name, args...)}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *WebsocketHub) CallTappers(function_name string, args ...interface{}) {
+	c.tapBroadcast <- jsonCallRequest(function_name, args...)
 }
 
 // writePump pumps messages from the hub to the websocket connection.
@@ -110,181 +178,111 @@ func (c *WebsocketClient) writePump() {
 	}
 }
 
+// JavaScriptMessage is a call from a page. See rpc.go.
 type JavaScriptMessage struct {
+	// Optional. Calls with an ID receive a response.
+	ID   json.RawMessage   `json:"id,omitempty"`
 	Call string            `json:"call"`
 	Args []json.RawMessage `json:"args"`
 }
 
-type JavaScriptHandler func(*WebsocketClient, ...json.RawMessage)
-
-var JavaScriptHandlers = map[string]JavaScriptHandler{
-	"ToggleMuted": ToggleMuted,
-	"Ban":         Ban,
-	"ShowAlert": func(c *WebsocketClient, args ...json.RawMessage) {
-		if !c.admin {
-			return
-		}
-		var html string
-		err := json.Unmarshal(args[0], &html)
-		if err != nil {
-			fmt.Println("Can't unmarshal alert: ", err)
-			return
-		}
+var JavaScriptHandlers = map[string]*RPCMethod{
+	"ToggleMuted":          AdminRPC(ToggleMuted, "user"),
+	"ToggleTTSMarkup":      AdminRPC(ToggleTTSMarkup, "user"),
+	"Ban":                  AdminRPC(Ban, "user"),
+	"ListSounds":           PublicRPC(ListSounds),
+	"PlaySound":            AdminRPC(PlaySoundHandler, "name"),
+	"GetVADConfig":         AdminRPC(GetVADConfigHandler),
+	"SetVADConfig":         AdminRPC(SetVADConfigHandler, "patch"),
+	"GetStreamInfo":        AdminRPC(GetStreamInfoHandler),
+	"SetStreamInfo":        AdminRPC(SetStreamInfoHandler, "info"),
+	"SearchCategories":     AdminRPC(SearchCategoriesHandler, "query"),
+	"ListStreamPresets":    AdminRPC(ListStreamPresetsHandler),
+	"SaveStreamPreset":     AdminRPC(SaveStreamPresetHandler, "name", "info"),
+	"DeleteStreamPreset":   AdminRPC(DeleteStreamPresetHandler, "name"),
+	"ApplyStreamPreset":    AdminRPC(ApplyStreamPresetHandler, "name"),
+	"GetStatsHistory":      AdminRPC(GetStatsHistoryHandler),
+	"StartPoll":            AdminRPC(StartPollHandler, "question", "options", "durationSeconds", "mirror"),
+	"EndPoll":              AdminRPC(EndPollHandler),
+	"ResolvePrediction":    AdminRPC(ResolvePredictionHandler, "option"),
+	"OpenGiveaway":         AdminRPC(OpenGiveawayHandler, "keyword", "prize", "rules"),
+	"DrawGiveaway":         AdminRPC(DrawGiveawayHandler),
+	"CancelGiveaway":       AdminRPC(CancelGiveawayHandler),
+	"ListTimers":           AdminRPC(ListTimersHandler),
+	"SetTimerEnabled":      AdminRPC(SetTimerEnabledHandler, "name", "enabled"),
+	"FireTimer":            AdminRPC(FireTimerHandler, "name"),
+	"GetLeaderboard":       PublicRPC(GetLeaderboardHandler),
+	"AdjustPoints":         AdminRPC(AdjustPointsHandler, "key", "delta"),
+	"GetGiveaways":         AdminRPC(GetGiveawaysHandler),
+	"TapEvents":            AdminRPC(TapEventsHandler),
+	"GetWebhookDeliveries": AdminRPC(GetWebhookDeliveriesHandler),
+	"GetEventBusStats":     AdminRPC(GetEventBusStatsHandler),
+	"GetOBSState":          AdminRPC(GetOBSStateHandler),
+	"OBSSwitchScene":       AdminRPC(OBSSwitchSceneHandler, "scene"),
+	"OBSSetSourceVisible":  AdminRPC(OBSSetSourceVisibleHandler, "scene", "id", "visible"),
+	"OBSSetInputMute":      AdminRPC(OBSSetInputMuteHandler, "input", "muted"),
+	"OBSSetStreaming":      AdminRPC(OBSSetStreamingHandler, "active"),
+	"OBSSetRecording":      AdminRPC(OBSSetRecordingHandler, "active"),
+	"Subscribe":            PublicRPC(SubscribeHandler, "topics"),
+	"ShowAlert": AdminRPC(func(c *WebsocketClient, html string) {
 		TTSChannel <- Alert{
 			HTML: html,
 		}
 		fmt.Println("Debug Alert:", html)
-	},
-	"SetTitle": func(c *WebsocketClient, args ...json.RawMessage) {
-		if !c.admin {
-			return
-		}
-		var title string
-		err := json.Unmarshal(args[0], &title)
-		if err != nil {
-			fmt.Println("Can't unmarshal title: ", err)
-			return
-		}
-		if title == "" {
-			fmt.Println("Cannot set title to empty string")
-			return
-		}
-		fmt.Printf("Changing stream title to \"%s\"\n", title)
-		Webserver.Call("SetStreamTitle", title)
-		TwitchHelixChannel <- func(client *helix.Client) {
-			if title == twitchTitle {
-				return
-			}
-			resp, err := client.EditChannelInformation(&helix.EditChannelInformationParams{
-				BroadcasterID: twitchBroadcasterID,
-				Title:         title,
-			})
-			if err != nil {
-				fmt.Println("Couldn't edit Twitch title:", err)
-				return
-			}
-			if resp.StatusCode != 204 {
-				fmt.Println("Couldn't edit Twitch title:", resp.ErrorMessage)
-				return
-			}
-			twitchTitle = title
-		}
-		YouTubeBotChannel <- func(youtube *youtube.Service) error {
-			if youtubeVideoId == "" {
-				return nil
-			}
-			listCall := youtube.Videos.List([]string{"id", "snippet"})
-			listCall.Id(youtubeVideoId)
-			resp, err := listCall.Do()
-			if err != nil {
-				return err
-			}
-			if len(resp.Items) == 0 {
-				fmt.Println("Couldn't find YouTube video with ID:", youtubeVideoId)
-				return nil
-			}
-			video := resp.Items[0]
-			video.Id = youtubeVideoId // this is not returned because we specify fields
-			// Note that we need to have the video.Snippet.CategoryId set. That's the entire reason for the first request
-			video.Snippet.Title = title
-			video.Snippet.Tags = youtubeTags // for some reason the tags are not returned in the first request
-
-			updateCall := youtube.Videos.Update([]string{"id", "snippet"}, video)
-			_, err = updateCall.Do()
-			if err != nil {
-				return err
-			}
-			return nil
-		}
-	},
-	"Password": func(c *WebsocketClient, args ...json.RawMessage) {
-		if len(args) != 1 {
-			return // don't log anything - could be malicious
-		}
+	}, "html"),
+	"SetTitle": AdminRPC(SetTitleHandler, "title"),
+	"SkipAudio": AdminRPC(func(c *WebsocketClient) {
+		SkipAudio()
+	}),
+	"GetBusVolumes": AdminRPC(func(c *WebsocketClient) map[string]float64 {
+		return AudioMixer.Volumes()
+	}),
+	"SetBusVolume": AdminRPC(func(c *WebsocketClient, bus string, volume float64) (map[string]float64, error) {
+		if err := AudioMixer.SetVolume(bus, volume); err != nil {
+			return nil, err
+		}
+		return AudioMixer.Volumes(), nil
+	}, "bus", "volume"),
+	"Password": PublicRPC(func(c *WebsocketClient, password string) (*User, error) {
 		if c.user != nil {
-			return // already logged in
-		}
-		var password string
-		err := json.Unmarshal(args[0], &password)
-		if err != nil {
-			fmt.Println("Can't unmarshal password: ", err)
-			return
-		}
-		c.user = PasswordIndex[password]
-		if c.user == nil {
-			c.user = &User{}
-			PasswordIndex[password] = c.user
-		}
-		c.user.EnsureTicket()
-		c.user.websockets = append(c.user.websockets, c)
-		c.Call("Welcome", c.user)
-	},
-	"ListVoices": func(c *WebsocketClient, args ...json.RawMessage) {
-		voicesChan := make(chan []string)
-		TTSChannel <- func() {
-			voicesChan <- voices
-		}
-		localVoices := <-voicesChan
-		c.Call("ListVoicesResponse", localVoices)
-	},
-	"SetVoice": func(c *WebsocketClient, args ...json.RawMessage) {
-		if c.user == nil {
-			return
-		}
-		var requestedVoice string
-		err := json.Unmarshal(args[0], &requestedVoice)
-		if err != nil {
-			return
-		}
-		voicesChan := make(chan []string)
-		TTSChannel <- func() {
-			voicesChan <- voices
+			return nil, fmt.Errorf("already logged in")
 		}
-		localVoices := <-voicesChan
-		found := false
-		for _, voice := range localVoices {
-			if voice == requestedVoice {
-				found = true
-				break
+		user := onMainThread(func() User {
+			user := PasswordIndex[password]
+			if user == nil {
+				user = &User{}
+				PasswordIndex[password] = user
 			}
-		}
-		if !found {
-			return
-		}
-		c.user.Voice = requestedVoice
-		err = SaveUsers()
-		if err != nil {
-			fmt.Println("Couldn't save users:", err)
-		}
-	},
-	"SetNamePronunciation": func(c *WebsocketClient, args ...json.RawMessage) {
-		if c.user == nil {
-			return
-		}
-		var pronunciation string
-		err := json.Unmarshal(args[0], &pronunciation)
-		if err != nil {
-			return
-		}
+			user.EnsureTicket()
+			user.websockets = append(user.websockets, c)
+			c.user = user
+			return *user
+		})
+		return &user, nil
+	}, "password"),
+	"ListVoices": PublicRPC(func(c *WebsocketClient) []string {
+		return TTSVoices()
+	}),
+	"SetVoice": UserRPC(func(c *WebsocketClient, requestedVoice string) error {
+		if !slices.Contains(TTSVoices(), requestedVoice) {
+			return fmt.Errorf("unknown voice %q", requestedVoice)
+		}
+		return onMainThread(func() error {
+			c.user.Voice = requestedVoice
+			return SaveUsers()
+		})
+	}, "voice"),
+	"SetNamePronunciation": UserRPC(func(c *WebsocketClient, pronunciation string) error {
 		// Limit length to prevent abuse
 		if len(pronunciation) > 100 {
 			pronunciation = pronunciation[:100]
 		}
-		c.user.NamePronunciation = pronunciation
-		err = SaveUsers()
-		if err != nil {
-			fmt.Println("Couldn't save users:", err)
-		}
-	},
-	"Post": func(c *WebsocketClient, args ...json.RawMessage) {
-		if !c.admin {
-			return
-		}
-		var message string
-		err := json.Unmarshal(args[0], &message)
-		if err != nil {
-			return
-		}
+		return onMainThread(func() error {
+			c.user.NamePronunciation = pronunciation
+			return SaveUsers()
+		})
+	}, "pronunciation"),
+	"Post": AdminRPC(func(c *WebsocketClient, message string) {
 		PostTweet(message)
 		PostBluesky(message)
 
@@ -293,80 +291,68 @@ var JavaScriptHandlers = map[string]JavaScriptHandler{
 			HTML:            BOT_ICON + ` 📢 ` + message,
 			OriginalMessage: message,
 		}
-		MainChannel <- entry
-	},
-	"MicroblogNotify": func(c *WebsocketClient, args ...json.RawMessage) {
-		if !c.admin {
-			return
-		}
-
-		youtubeVideoIdLocal := GetYouTubeVideoID()
-		message := fmt.Sprintf("🔴 Live now: \"%s\"! 🎉🎉🎉\n\n📺 https://youtu.be/%s https://twitch.tv/maf_pl https://tv.algora.io/maf", twitchTitle, youtubeVideoIdLocal)
+		Events.Publish(ChatMessage{Entry: entry})
+	}, "message"),
+	"MicroblogNotify": AdminRPC(func(c *WebsocketClient) {
+		Stream.markAnnounced()
+		go func() {
+			err := AnnounceGoLive()
+			if err != nil {
+				fmt.Println("Couldn't announce the stream:", err)
+			}
+		}()
+	}),
+	"DeleteMessage": AdminRPC(DeleteMessageHandler, "entry"),
+}
 
-		// Send Twitter notification
-		PostTweet(message)
-		fmt.Printf("Tweeted: %s\n", message)
+// DeleteMessageHandler removes the message from the chat platforms & from chat_log.txt.
+func DeleteMessageHandler(c *WebsocketClient, msg ChatEntry) {
+	msg.DeleteUpstream()
+	Events.Publish(ModerationAction{Action: ModerationDelete, Target: msg.Author, MessageID: msg.ID})
+	if msg.ID != 0 {
+		MainChannel <- func() {
+			// Read chat_log.txt & remove message with ID == msg.ID
+			func() {
+				fmt.Println("Deleting message with ID", msg.ID)
+				src, err := os.Open("chat_log.txt")
+				if err != nil {
+					fmt.Println("Couldn't open chat_log.txt:", err)
+					return
+				}
+				defer src.Close()
+				dst, err := os.OpenFile("chat_log.txt.tmp", os.O_CREATE|os.O_WRONLY, 0644)
+				if err != nil {
+					fmt.Println("Couldn't open chat_log.txt.tmp:", err)
+					return
+				}
+				defer dst.Close()
 
-		// Send Bluesky notification
-		PostBluesky(message)
-		fmt.Printf("Posted to Bluesky: %s\n", message)
-	},
-	"DeleteMessage": func(c *WebsocketClient, args ...json.RawMessage) {
-		if !c.admin {
-			return
-		}
-		var msg ChatEntry
-		err := json.Unmarshal(args[0], &msg)
-		if err != nil {
-			fmt.Println("Can't unmarshal message ID: ", err)
-			return
-		}
-		msg.DeleteUpstream()
-		if msg.ID != 0 {
-			MainChannel <- func() {
-				// Read chat_log.txt & remove message with ID == msg.ID
-				func() {
-					fmt.Println("Deleting message with ID", msg.ID)
-					src, err := os.Open("chat_log.txt")
+				scanner := bufio.NewScanner(src)
+				for scanner.Scan() {
+					entryText := scanner.Text()
+					entry, err := MakeChatEntry(entryText)
 					if err != nil {
-						fmt.Println("Couldn't open chat_log.txt:", err)
-						return
+						fmt.Println("Couldn't parse chat entry:", err)
+						continue
 					}
-					defer src.Close()
-					dst, err := os.OpenFile("chat_log.txt.tmp", os.O_CREATE|os.O_WRONLY, 0644)
-					if err != nil {
-						fmt.Println("Couldn't open chat_log.txt.tmp:", err)
-						return
+					if entry.ID == msg.ID {
+						continue
 					}
-					defer dst.Close()
-
-					scanner := bufio.NewScanner(src)
-					for scanner.Scan() {
-						entryText := scanner.Text()
-						entry, err := MakeChatEntry(entryText)
-						if err != nil {
-							fmt.Println("Couldn't parse chat entry:", err)
-							continue
-						}
-						if entry.ID == msg.ID {
-							continue
-						}
-						dst.WriteString(entryText + "\n")
-					}
-					if err := scanner.Err(); err != nil {
-						return
-					}
-				}()
-				// Replace chat_log.txt with chat_log.txt.tmp
-				os.Rename("chat_log.txt.tmp", "chat_log.txt")
-				// Re-read chat_log.txt
-				chat_log, _ = ReadLastChatLog()
-				for _, entry := range chat_log {
-					Webserver.Call("OnChatMessage", entry)
+					dst.WriteString(entryText + "\n")
 				}
+				if err := scanner.Err(); err != nil {
+					return
+				}
+			}()
+			// Replace chat_log.txt with chat_log.txt.tmp
+			os.Rename("chat_log.txt.tmp", "chat_log.txt")
+			// Re-read chat_log.txt
+			chat_log, _ = ReadLastChatLog()
+			for _, entry := range chat_log {
+				Webserver.CallTopic(TopicChat, "OnChatMessage", entry)
 			}
 		}
-	},
+	}
 }
 
 // readPump pumps messages from the websocket connection to the hub.
@@ -394,11 +380,7 @@ func (c *WebsocketClient) readPump() {
 			fmt.Println(err)
 			continue
 		}
-		if handler, ok := JavaScriptHandlers[message.Call]; ok {
-			handler(c, message.Args...)
-		} else {
-			fmt.Printf("Unknown JavaScript method: %s(%v)\n", message.Call, message.Args)
-		}
+		c.handleRPC(message)
 	}
 }
 
@@ -408,10 +390,11 @@ func OnTwitchWebhook(w http.ResponseWriter, r *http.Request) {
 
 func StartWebserver(OnNewClient chan *WebsocketClient) *WebsocketHub {
 	hub := &WebsocketHub{
-		register:   make(chan *WebsocketClient),
-		unregister: make(chan *WebsocketClient),
-		clients:    make(map[*WebsocketClient]bool),
-		broadcast:  make(chan []byte),
+		register:     make(chan *WebsocketClient),
+		unregister:   make(chan *WebsocketClient),
+		clients:      make(map[*WebsocketClient]bool),
+		broadcast:    make(chan topicMessage),
+		tapBroadcast: make(chan []byte),
 	}
 
 	upgrader := websocket.Upgrader{
@@ -422,6 +405,8 @@ func StartWebserver(OnNewClient chan *WebsocketClient) *WebsocketHub {
 		},
 	}
 
+	WriteRPCClient()
+
 	go func() {
 		watcher, err := fsnotify.NewWatcher()
 		if err != nil {
@@ -460,6 +445,7 @@ func StartWebserver(OnNewClient chan *WebsocketClient) *WebsocketHub {
 
 	http.HandleFunc("/twitch-auth", OnTwitchAuth)
 	http.HandleFunc("/webhook/twitch", OnTwitchWebhook)
+	RegisterAPI(http.DefaultServeMux)
 
 	// Turn /live/ into alias for /
 	http.Handle("/live/", http.StripPrefix("/live", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -479,6 +465,11 @@ func StartWebserver(OnNewClient chan *WebsocketClient) *WebsocketHub {
 			return
 		}
 		client := &WebsocketClient{hub: hub, conn: conn, send: make(chan []byte, 256)}
+		topics, err := ParseTopics(r.URL.Query().Get("topics"))
+		if err != nil {
+			fmt.Println("Websocket topics:", err)
+		}
+		client.SetTopics(topics)
 
 		forwarded_headers := r.Header["X-Forwarded-For"]
 		switch len(forwarded_headers) {
@@ -519,10 +510,21 @@ func StartWebserver(OnNewClient chan *WebsocketClient) *WebsocketHub {
 			select {
 			case message := <-hub.broadcast:
 				for client := range hub.clients {
-					select {
-					case client.send <- message:
-					default:
-						close(client.send)
+					if message.topic != "" && !client.Wants(message.topic) {
+						continue
+					}
+					if !client.trySend(message.data) {
+						client.close()
+						delete(hub.clients, client)
+					}
+				}
+			case message := <-hub.tapBroadcast:
+				for client := range hub.clients {
+					if !client.tap.Load() {
+						continue
+					}
+					if !client.trySend(message) {
+						client.close()
 						delete(hub.clients, client)
 					}
 				}
@@ -536,15 +538,18 @@ func StartWebserver(OnNewClient chan *WebsocketClient) *WebsocketHub {
 					}
 				}
 			case client := <-hub.unregister:
-				if _, ok := hub.clients[client]; ok {
-					delete(hub.clients, client)
-					close(client.send)
-				}
-				if client.user != nil {
-					client.user.websockets = slices.DeleteFunc(client.user.websockets, func(c *WebsocketClient) bool {
-						return c == client
-					})
+				delete(hub.clients, client)
+				client.close()
+				if user := client.user; user != nil {
 					client.user = nil
+					// Users are only modified on the main thread. Async because the main thread may be waiting for the hub.
+					go func() {
+						MainChannel <- func() {
+							user.websockets = slices.DeleteFunc(user.websockets, func(c *WebsocketClient) bool {
+								return c == client
+							})
+						}
+					}()
 				}
 			}
 		}
@@ -3,6 +3,7 @@ package main
 import (
 	"errors"
 	"streambot/backoff"
+	"strings"
 
 	"github.com/fatih/color"
 	"golang.org/x/net/context"
@@ -21,9 +22,23 @@ type YouTubeFunc func(*youtube.Service) error
 var YouTubeBotChannel = make(chan YouTubeFunc)
 var youtubeColor = color.New(color.FgRed)
 
+// ytEmojiShortcutToURL maps the YouTube custom emoji shortcuts to the image URLs from ytEmojiShortcutToHTML.
+var ytEmojiShortcutToURL = func() map[string]string {
+	urls := make(map[string]string, len(ytEmojiShortcutToHTML))
+	for shortcut, emojiHtml := range ytEmojiShortcutToHTML {
+		if _, rest, found := strings.Cut(emojiHtml, `src="`); found {
+			urls[shortcut], _, _ = strings.Cut(rest, `"`)
+		}
+	}
+	return urls
+}()
+
 // This should only be accessed from YT goroutine use `GetYouTubeVideoID` instead.
 var youtubeVideoId string
 
+// Live chat of the current broadcast. Set together with youtubeVideoId.
+var youtubeLiveChatID string
+
 func GetYouTubeVideoID() string {
 	youtubeVideoIdChan := make(chan string)
 	YouTubeBotChannel <- func(youtube *youtube.Service) error {
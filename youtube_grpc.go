@@ -3,7 +3,6 @@ package main
 import (
 	"context"
 	"fmt"
-	"html"
 	"io"
 	"streambot/backoff"
 	"strings"
@@ -71,7 +70,7 @@ func YouTubeChatBotGRPC() {
 		}
 
 		youtubeVideoId = youtubeBroadcast.Id
-		youtubeLiveChatID := youtubeBroadcast.Snippet.LiveChatId
+		youtubeLiveChatID = youtubeBroadcast.Snippet.LiveChatId
 
 		youtubeColor.Println("Connecting to https://youtu.be/" + youtubeVideoId)
 
@@ -135,7 +134,7 @@ func YouTubeChatBotGRPC() {
 					PageToken:  pageToken,
 				}
 
-				Webserver.Call("Ping", "YouTube")
+				Webserver.CallTopic(TopicHealth, "Ping", "YouTube")
 				stream, err = grpcClient.StreamList(ctx, req)
 				if err != nil {
 					youtubeColor.Printf("Failed to stream chat messages: %v (type: %T)\n", err, err)
@@ -147,14 +146,14 @@ func YouTubeChatBotGRPC() {
 					}
 					break
 				}
-				Webserver.Call("Pong", "YouTube")
+				Webserver.CallTopic(TopicHealth, "Pong", "YouTube")
 			}
 
 			resp, err := stream.Recv()
-			Webserver.Call("Ping", "YouTube")
+			Webserver.CallTopic(TopicHealth, "Ping", "YouTube")
 			if err == io.EOF {
 				stream = nil
-				Webserver.Call("Pong", "YouTube")
+				Webserver.CallTopic(TopicHealth, "Pong", "YouTube")
 				innerBackoff.Success()
 				continue
 			}
@@ -166,7 +165,7 @@ func YouTubeChatBotGRPC() {
 				}
 				break
 			}
-			Webserver.Call("Pong", "YouTube")
+			Webserver.CallTopic(TopicHealth, "Pong", "YouTube")
 			innerBackoff.Success()
 
 			if resp.NextPageToken != nil {
@@ -201,24 +200,31 @@ func YouTubeChatBotGRPC() {
 						},
 					},
 					YouTubeMessageID: ptrToString(item.Id),
+					Platform:         PlatformYouTube,
 					timestamp:        parseISO8601(ptrToString(item.Snippet.PublishedAt)),
 				}
 
-				chatMessage.OriginalMessage = ptrToString(textDetails.TextMessageDetails.MessageText)
-				chatMessage.HTML = html.EscapeString(chatMessage.OriginalMessage)
-				chatMessage.textOnly = chatMessage.OriginalMessage
-
-				// Apply emoji replacements if we have them from InnerTube
-				for shortcut, emojiHtml := range ytEmojiShortcutToHTML {
-					chatMessage.HTML = strings.ReplaceAll(chatMessage.HTML, shortcut, emojiHtml)
-					chatMessage.textOnly = strings.ReplaceAll(chatMessage.textOnly, shortcut, "")
+				// YouTube has no badge images - the pages show the symbols of the roles
+				author := item.AuthorDetails
+				for _, role := range []struct {
+					name string
+					has  bool
+				}{
+					{RoleOwner, author.GetIsChatOwner()},
+					{RoleModerator, author.GetIsChatModerator()},
+					{RoleMember, author.GetIsChatSponsor()},
+					{RoleVerified, author.GetIsVerified()},
+				} {
+					if role.has {
+						chatMessage.AddRole(role.name)
+						chatMessage.Badges = append(chatMessage.Badges, ChatBadge{SetID: role.name})
+					}
 				}
+				chatMessage.OriginalMessage = ptrToString(textDetails.TextMessageDetails.MessageText)
+				chatMessage.AppendShortcodes(chatMessage.OriginalMessage, ytEmojiShortcutToURL)
+				chatMessage.Render()
 
-				chatMessage.HTML = YOUTUBE_ICON + " " + chatMessage.Author.HTML() + ": " + chatMessage.HTML
-				chatMessage.terminalMsg = fmt.Sprintf("  %s: %s\n", chatMessage.Author.DisplayName(), chatMessage.OriginalMessage)
-				chatMessage.ttsMsg = chatMessage.textOnly
-
-				MainChannel <- chatMessage
+				Events.Publish(ChatMessage{Entry: chatMessage})
 			}
 		}
 